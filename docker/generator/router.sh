@@ -59,6 +59,28 @@ printf '// Code generated by protoc-gen-rain. DO NOT EDIT.
 package router
 
 type Empty struct{}
+
+func (*Empty) IsRequest() {}
+
+func (*Empty) IsResponse() {}
+
+// RequestMessage is implemented by the inputs of methods generated with marker_interfaces=true.
+type RequestMessage interface {
+	IsRequest()
+}
+
+// ResponseMessage is implemented by the outputs of methods generated with marker_interfaces=true.
+type ResponseMessage interface {
+	IsResponse()
+}
+
+// FieldMeta describes a message field, as generated with field_meta=true.
+type FieldMeta struct {
+	Name    string            // proto field name
+	Number  int32             // proto field number
+	Type    string            // proto scalar type or fully-qualified message/enum name
+	Options map[string]string // @tag annotations of the field
+}
 ' > $ROUTER_PATH/router/model.go
 
 printf '// Code generated by protoc-gen-rain. DO NOT EDIT.
@@ -67,6 +89,7 @@ package router
 
 import (
     "encoding/json"
+	"fmt"
 
 	"github.com/gin-gonic/gin"
 )
@@ -90,6 +113,15 @@ func JSON(ctx *gin.Context, data any) {
 	})
 }
 
+// JSONStatus is JSON with another success status than 200, e.g. 201 for creations.
+func JSONStatus(ctx *gin.Context, status int, data any) {
+	ctx.JSON(status, Response{
+		Code: 0,
+		Msg:  "",
+		Data: data,
+	})
+}
+
 func Error(ctx *gin.Context, code int, err error) {
 	ctx.JSON(200, Response{
 		Code: code,
@@ -99,6 +131,77 @@ func Error(ctx *gin.Context, code int, err error) {
 	ctx.Abort()
 }
 
+// StreamDecode decodes the request body as a JSON array and calls fn with every element
+// in turn, without holding the whole array in memory. It stops at the first error.
+func StreamDecode[T any](ctx *gin.Context, fn func(*T) error) error {
+	dec := json.NewDecoder(ctx.Request.Body)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d.String() != "[" {
+		return fmt.Errorf("stream decode: want a JSON array, got %%v", tok)
+	}
+
+	for dec.More() {
+		elem := new(T)
+		if err := dec.Decode(elem); err != nil {
+			return err
+		}
+		if err := fn(elem); err != nil {
+			return err
+		}
+	}
+
+	_, err = dec.Token()
+	return err
+}
+
+// Stream is the sink server-streaming handlers send their output messages of type T to.
+type Stream[T any] interface {
+	// Send writes msg to the client. It fails once the client has gone away.
+	Send(msg *T) error
+}
+
+type sseStream[T any] struct {
+	ctx     *gin.Context
+	started bool
+}
+
+// SSE returns the Stream writing every message as a server-sent event of the response to ctx.
+// The event stream headers are set on the first message, so that a handler failing before
+// sending anything can still answer with Error.
+func SSE[T any](ctx *gin.Context) Stream[T] {
+	return &sseStream[T]{ctx: ctx}
+}
+
+func (s *sseStream[T]) Send(msg *T) error {
+	if err := s.ctx.Request.Context().Err(); err != nil {
+		return err
+	}
+
+	if !s.started {
+		s.started = true
+		s.ctx.Header("Content-Type", "text/event-stream")
+		s.ctx.Header("Cache-Control", "no-cache")
+		s.ctx.Header("Connection", "keep-alive")
+	}
+
+	s.ctx.SSEvent("message", msg)
+	s.ctx.Writer.Flush()
+	return nil
+}
+
+// SSEError ends an event stream started by SSE with an error event holding a Response.
+func SSEError(ctx *gin.Context, code int, err error) {
+	ctx.SSEvent("error", Response{
+		Code: code,
+		Msg:  err.Error(),
+	})
+	ctx.Writer.Flush()
+}
+
 ' > $ROUTER_PATH/router/response.go
 
 
@@ -107,14 +210,32 @@ printf '// Code generated by protoc-gen-rain. DO NOT EDIT.
 package router
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 var mIns sync.Map
 
+// BufferPool holds the *bytes.Buffer request bodies are read into with buffered_bind=true.
+var BufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
 func RegisterMiddleware(name string, handler gin.HandlerFunc) {
 	mIns.Store(name, handler)
 }
@@ -145,4 +266,653 @@ func Handle(g *gin.Engine, method, path string, middlewares []string, handler gi
 	g.Handle(method, path, handlers...)
 }
 
+// AccessLog runs fn, the handler of a route, and logs the request labelled with the route
+// name once the response is complete: the bytes read from the request body and written to
+// the response, the duration and the error fn failed the request with, if any.
+//
+//	router.AccessLog(ctx, "UserService.GetUser", func() error { ... })
+func AccessLog(ctx *gin.Context, label string, fn func() error) {
+	start := time.Now()
+
+	body := &countingReader{ReadCloser: ctx.Request.Body}
+	if ctx.Request.Body != nil {
+		ctx.Request.Body = body
+	}
+	err := fn()
+
+	line := fmt.Sprintf("%%s %%s %%s status=%%d in=%%d out=%%d duration=%%s",
+		label, ctx.Request.Method, ctx.Request.URL.Path,
+		ctx.Writer.Status(), body.n, ctx.Writer.Size(), time.Since(start))
+	if err != nil {
+		line += " error=" + strconv.Quote(err.Error())
+	}
+	log.Print(line)
+}
+
+// countingReader counts the bytes read from a request body, whose ContentLength is -1
+// when it is chunked.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// ObserveFunc records the duration of a request, typically into a Prometheus histogram,
+// with the labels of its route: service, method and status, the class of its status code.
+type ObserveFunc func(labels map[string]string, seconds float64)
+
+var observeFunc ObserveFunc
+
+// RegisterObserveFunc sets the function recording the metrics of handlers generated with metrics=true.
+func RegisterObserveFunc(f ObserveFunc) {
+	observeFunc = f
+}
+
+// Metrics starts timing the request and returns the function recording it through the
+// registered ObserveFunc, to be deferred by the handler so that the final status is known:
+//
+//	defer router.Metrics(ctx, "UserService", "GetUser")()
+func Metrics(ctx *gin.Context, service, method string) func() {
+	start := time.Now()
+
+	return func() {
+		if observeFunc == nil {
+			return
+		}
+
+		observeFunc(map[string]string{
+			"service": service,
+			"method":  method,
+			"status":  StatusClass(ctx.Writer.Status()),
+		}, time.Since(start).Seconds())
+	}
+}
+
+// StatusClass returns the class of an HTTP status code, e.g. 4xx for 404.
+func StatusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// SpanFunc starts a span for the request, typically with an OpenTelemetry tracer also putting
+// it on the context of ctx.Request, and returns the function ending it.
+type SpanFunc func(ctx *gin.Context, name string, attrs map[string]string) func()
+
+var spanFunc SpanFunc
+
+// RegisterSpanFunc sets the function opening the spans of handlers generated with otel=true.
+func RegisterSpanFunc(f SpanFunc) {
+	spanFunc = f
+}
+
+// OTelSpan starts the span named name, e.g. UserService/GetUser, with the route attributes
+// through the registered SpanFunc and returns the function ending it, to be deferred.
+func OTelSpan(ctx *gin.Context, name string, attrs map[string]string) func() {
+	if spanFunc == nil {
+		return func() {}
+	}
+
+	return spanFunc(ctx, name, attrs)
+}
+
+// Chain resolves the named middlewares once so that routes sharing them can reuse the chain.
+// A missing middleware yields a chain failing every request, as Handle does.
+func Chain(names ...string) gin.HandlersChain {
+	chain := make(gin.HandlersChain, 0, len(names))
+	for _, v := range names {
+		h, ok := mIns.Load(v)
+		if !ok {
+			name := v
+			return gin.HandlersChain{func(ctx *gin.Context) {
+				Error(ctx, 500, fmt.Errorf("middleware: %%s not found", name))
+			}}
+		}
+
+		chain = append(chain, h.(gin.HandlerFunc))
+	}
+
+	return chain
+}
+
+// HandleChain registers handler behind a chain built by Chain.
+func HandleChain(g *gin.Engine, method, path string, chain gin.HandlersChain, handler gin.HandlerFunc) {
+	handlers := make(gin.HandlersChain, 0, len(chain)+1)
+	handlers = append(handlers, chain...)
+	handlers = append(handlers, handler)
+
+	g.Handle(method, path, handlers...)
+}
+
+// TransformFunc converts a field value to its storage representation, e.g. by encrypting it.
+type TransformFunc func(value string) (string, error)
+
+var transforms sync.Map
+
+// RegisterTransform sets the function applied to fields annotated transform:<name>.
+func RegisterTransform(name string, fn TransformFunc) {
+	transforms.Store(name, fn)
+}
+
+// Transform applies the transform registered under name to value.
+func Transform(name, value string) (string, error) {
+	fn, ok := transforms.Load(name)
+	if !ok {
+		return "", fmt.Errorf("transform: %%s not registered", name)
+	}
+
+	return fn.(TransformFunc)(value)
+}
+
+// EnumQuery replaces enum value names in the query parameter key by their numbers, so that
+// query binding, which only understands numbers, accepts ?status=ACTIVE.
+func EnumQuery[T ~int32](ctx *gin.Context, key string, parse func(string) (T, error)) error {
+	q := ctx.Request.URL.Query()
+	values, ok := q[key]
+	if !ok {
+		return nil
+	}
+
+	for i, v := range values {
+		if v == "" {
+			continue
+		}
+
+		n, err := EnumValue(v, parse)
+		if err != nil {
+			return err
+		}
+		values[i] = strconv.Itoa(int(n))
+	}
+
+	ctx.Request.URL.RawQuery = q.Encode()
+	return nil
+}
+
+// EnumValue returns the enum value numbered or named s, accepting the path variables and
+// headers bound to enum fields in the forms EnumQuery accepts in the query.
+func EnumValue[T ~int32](s string, parse func(string) (T, error)) (T, error) {
+	if n, err := strconv.ParseInt(s, 10, 32); err == nil {
+		return T(n), nil
+	}
+	return parse(s)
+}
+
+// IdempotentResponse is the response rendered for an Idempotency-Key, replayed to the
+// requests repeating the key.
+type IdempotentResponse struct {
+	Status int
+	Body   []byte
+}
+
+// IdempotencyFunc runs fn at most once per key, e.g. by recording keys and the responses
+// fn returns in a shared store, and returns the response of the first run for repeated keys.
+type IdempotencyFunc func(ctx *gin.Context, key string, fn func() IdempotentResponse) IdempotentResponse
+
+var idempotency IdempotencyFunc
+
+// RegisterIdempotency sets the function used by handlers of methods annotated idempotent:true.
+func RegisterIdempotency(f IdempotencyFunc) {
+	idempotency = f
+}
+
+// Idempotent serves the request with serve, which renders the response, through the
+// registered IdempotencyFunc: the status and body serve renders are recorded for key, and
+// written again without calling serve for the requests repeating it. Without a key or a
+// registered function the request is not idempotent and serve is called directly.
+func Idempotent(ctx *gin.Context, key string, serve func()) {
+	if key == "" || idempotency == nil {
+		serve()
+		return
+	}
+
+	served := false
+	resp := idempotency(ctx, key, func() IdempotentResponse {
+		served = true
+		w := &recordingWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = w
+		serve()
+		ctx.Writer = w.ResponseWriter
+		return IdempotentResponse{Status: w.Status(), Body: w.body.Bytes()}
+	})
+	if !served {
+		ctx.Data(resp.Status, "application/json; charset=utf-8", resp.Body)
+	}
+}
+
+// recordingWriter keeps a copy of the body written to the response.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// MaxDecompressedBody is the most bytes read from a gzip request body once decompressed
+// with decompress_request=true, guarding against small bodies inflating without bound.
+var MaxDecompressedBody int64 = 32 << 20
+
+// IsTransient reports whether a handler error is worth retrying for methods annotated
+// retry:<n>. By default errors with a Temporary() true method are, as net errors.
+var IsTransient = func(err error) bool {
+	var t interface{ Temporary() bool }
+	return errors.As(err, &t) && t.Temporary()
+}
+
+// RetryBackoff is the wait before the first retry, doubled before every next one.
+var RetryBackoff = 50 * time.Millisecond
+
+// Retry calls fn up to attempts times while it fails with a transient error, waiting
+// RetryBackoff and then twice as long after every failure. It stops waiting and returns
+// the error of ctx once ctx is done.
+func Retry(ctx context.Context, attempts int, fn func() error) error {
+	backoff := RetryBackoff
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		if err = fn(); err == nil || !IsTransient(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// StrictJSON binds JSON bodies like binding.JSON but fails on fields the input does not
+// declare. It is used by methods annotated strictjson:true or with strict_json=true.
+var StrictJSON binding.BindingBody = strictJSON{}
+
+type strictJSON struct{}
+
+func (strictJSON) Name() string {
+	return "json"
+}
+
+func (s strictJSON) Bind(req *http.Request, obj any) error {
+	if req == nil || req.Body == nil {
+		return errors.New("invalid request")
+	}
+
+	return s.decode(req.Body, obj)
+}
+
+func (s strictJSON) BindBody(body []byte, obj any) error {
+	return s.decode(bytes.NewReader(body), obj)
+}
+
+func (strictJSON) decode(r io.Reader, obj any) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(obj); err != nil {
+		return err
+	}
+
+	if binding.Validator == nil {
+		return nil
+	}
+	return binding.Validator.ValidateStruct(obj)
+}
+
+// TrailingSlash registers the other form of path, with or without its trailing slash, for
+// routes annotated trailingslash:<mode>. With "redirect" the requests are redirected to path,
+// with "strip" they are served by the route of path, with "strict" they get 404 whatever
+// the RedirectTrailingSlash setting of the engine.
+func TrailingSlash(g *gin.Engine, method, path, mode string) {
+	toggle := func(p string) string {
+		if strings.HasSuffix(p, "/") {
+			return strings.TrimSuffix(p, "/")
+		}
+		return p + "/"
+	}
+
+	g.Handle(method, toggle(path), func(ctx *gin.Context) {
+		switch mode {
+		case "redirect":
+			code := http.StatusMovedPermanently
+			if method != http.MethodGet && method != http.MethodHead {
+				code = http.StatusPermanentRedirect
+			}
+
+			u := *ctx.Request.URL
+			u.Path = toggle(u.Path)
+			ctx.Redirect(code, u.String())
+		case "strip":
+			ctx.Request.URL.Path = toggle(ctx.Request.URL.Path)
+			g.HandleContext(ctx)
+		default:
+			ctx.AbortWithStatus(http.StatusNotFound)
+		}
+	})
+}
+
 ' > $ROUTER_PATH/router/router.go
+printf '// Code generated by protoc-gen-rain. DO NOT EDIT.
+
+package router
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	userKey          = "router.user"
+	routeTemplateKey = "router.route_template"
+	cursorKey        = "router.cursor"
+	tenantKey        = "router.tenant"
+)
+
+type ginContext struct {
+	context.Context
+	keys map[string]any
+}
+
+// Value looks the key up in the values set on the gin context first.
+func (c ginContext) Value(key any) any {
+	if k, ok := key.(string); ok {
+		if v, ok := c.keys[k]; ok {
+			return v
+		}
+	}
+
+	return c.Context.Value(key)
+}
+
+// Context returns the request context carrying the values set on the gin context with ctx.Set,
+// so that they stay available to handlers generated with stdctx=true.
+func Context(ctx *gin.Context) context.Context {
+	return ginContext{Context: ctx.Request.Context(), keys: ctx.Copy().Keys}
+}
+
+// FromGin returns a value set on the gin context from a context built by Context.
+func FromGin(ctx context.Context, key string) (any, bool) {
+	if c, ok := ctx.(ginContext); ok {
+		v, ok := c.keys[key]
+		return v, ok
+	}
+
+	v := ctx.Value(key)
+	return v, v != nil
+}
+
+// AuthUser is the authenticated user passed to handlers generated with auth_context=true.
+type AuthUser struct {
+	ID    string
+	Name  string
+	Extra map[string]any
+}
+
+// SetUser stores the authenticated user on the request, usually from an auth middleware.
+func SetUser(ctx *gin.Context, user *AuthUser) {
+	ctx.Set(userKey, user)
+}
+
+// SetRouteTemplate stores the google.api.http template of the matched route, e.g. /v1/users/{id}.
+func SetRouteTemplate(ctx *gin.Context, template string) {
+	ctx.Set(routeTemplateKey, template)
+}
+
+// RouteTemplate returns the template of the matched route, set by handlers generated with route_context=true.
+func RouteTemplate(ctx *gin.Context) string {
+	return ctx.GetString(routeTemplateKey)
+}
+
+// WithTenant stores the tenant of the request, as resolved by handlers of methods annotated
+// tenant:header:<name> or tenant:path:<name>.
+func WithTenant(ctx *gin.Context, tenant string) {
+	ctx.Set(tenantKey, tenant)
+}
+
+// Tenant returns the tenant stored by WithTenant, or "". ctx is the gin context or the
+// context built from it by Context.
+func Tenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey).(string)
+	return tenant
+}
+
+// EncodeCursor turns a pagination cursor into an opaque page token.
+func EncodeCursor(cursor any) (string, error) {
+	bts, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bts), nil
+}
+
+// DecodeCursor decodes a page token made by EncodeCursor. An empty token yields nil.
+func DecodeCursor[T any](token string) (*T, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	bts, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := new(T)
+	if err := json.Unmarshal(bts, cursor); err != nil {
+		return nil, err
+	}
+
+	return cursor, nil
+}
+
+// SetCursor stores the decoded cursor on the request.
+func SetCursor(ctx *gin.Context, cursor any) {
+	ctx.Set(cursorKey, cursor)
+}
+
+// Cursor returns the cursor decoded by handlers of methods annotated cursor:<type>, or nil
+// for the first page.
+func Cursor[T any](ctx *gin.Context) *T {
+	if v, ok := ctx.Get(cursorKey); ok {
+		cursor, _ := v.(*T)
+		return cursor
+	}
+
+	return nil
+}
+
+// User returns the authenticated user stored by SetUser, or nil.
+func User(ctx *gin.Context) *AuthUser {
+	if v, ok := ctx.Get(userKey); ok {
+		user, _ := v.(*AuthUser)
+		return user
+	}
+
+	return nil
+}
+
+// HTTPContext is the request as seen by the adapters generated with adapter=true, so that
+// they can be served by another router than gin.
+type HTTPContext interface {
+	// Context returns the context of the request.
+	Context() context.Context
+	// Bind decodes the request body into v.
+	Bind(v any) error
+	// BindQuery decodes the query string into v.
+	BindQuery(v any) error
+	// Param returns the value of the path parameter name.
+	Param(name string) string
+	// Header returns the value of the request header name.
+	Header(name string) string
+	// User returns the authenticated user of the request, or nil.
+	User() *AuthUser
+	// JSON writes data in a Response with the status code.
+	JSON(status int, data any)
+	// Error writes err in a Response with the error code.
+	Error(code int, err error)
+}
+
+type ginHTTPContext struct {
+	ctx *gin.Context
+}
+
+// GinHTTP returns the HTTPContext of a gin request.
+func GinHTTP(ctx *gin.Context) HTTPContext {
+	return ginHTTPContext{ctx: ctx}
+}
+
+func (c ginHTTPContext) Context() context.Context  { return Context(c.ctx) }
+func (c ginHTTPContext) Bind(v any) error          { return c.ctx.ShouldBind(v) }
+func (c ginHTTPContext) BindQuery(v any) error     { return c.ctx.ShouldBindQuery(v) }
+func (c ginHTTPContext) Param(name string) string  { return c.ctx.Param(name) }
+func (c ginHTTPContext) Header(name string) string { return c.ctx.GetHeader(name) }
+func (c ginHTTPContext) User() *AuthUser           { return User(c.ctx) }
+func (c ginHTTPContext) JSON(status int, data any) { JSONStatus(c.ctx, status, data) }
+func (c ginHTTPContext) Error(code int, err error) { Error(c.ctx, code, err) }
+' > $ROUTER_PATH/router/context.go
+
+printf '// Code generated by protoc-gen-rain. DO NOT EDIT.
+
+package router
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+var validate = validator.New()
+
+// Validate checks v against the validate struct tags generated from validate:<rules> annotations.
+func Validate(v any) error {
+	return validate.Struct(v)
+}
+
+// validateJSON names the failing fields by their JSON name.
+var validateJSON = func() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		if name := strings.Split(f.Tag.Get("json"), ",")[0]; name != "-" {
+			return name
+		}
+		return ""
+	})
+	return v
+}()
+
+// ValidationError is a failing field, named by its JSON path from the validated message.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+// ValidationErrors lists every failing field of a message validated with ValidateAll.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, ve := range e {
+		msgs = append(msgs, ve.Field+": "+ve.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ValidateAll checks v against its validate struct tags like Validate, but reports every
+// failing field, nested ones prefixed with their path, as ValidationErrors.
+func ValidateAll(v any) error {
+	err := validateJSON.Struct(v)
+
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return err
+	}
+
+	errs := make(ValidationErrors, 0, len(verrs))
+	for _, fe := range verrs {
+		field := fe.Namespace()
+		if i := strings.Index(field, "."); i >= 0 {
+			field = field[i+1:]
+		}
+		errs = append(errs, ValidationError{Field: field, Message: fe.Error()})
+	}
+	return errs
+}
+
+// CodedError is an error carrying the response code it should be rendered with.
+type CodedError interface {
+	error
+	Code() int
+}
+
+// WithCode returns err carrying code, the code handleError renders it with under
+// error_helper=true, unless err already carries a code of its own.
+func WithCode(err error, code int) error {
+	var coded CodedError
+	if errors.As(err, &coded) {
+		return err
+	}
+	return codedError{error: err, code: code}
+}
+
+type codedError struct {
+	error
+	code int
+}
+
+func (e codedError) Code() int {
+	return e.code
+}
+
+func (e codedError) Unwrap() error {
+	return e.error
+}
+
+// FieldErrors lists the fields that failed validation, one object per field.
+type FieldErrors []map[string]string
+
+// FieldError renders validation failures per field.
+func FieldError(ctx *gin.Context, code int, errs FieldErrors) {
+	ctx.JSON(200, Response{
+		Code: code,
+		Msg:  "invalid request",
+		Data: errs,
+	})
+	ctx.Abort()
+}
+
+// JSONFieldName returns the JSON name of the named field of the struct v points to.
+func JSONFieldName(v any, field string) string {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if f, ok := t.FieldByName(field); ok {
+		if name := strings.Split(f.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+
+	return field
+}
+' > $ROUTER_PATH/router/errors.go
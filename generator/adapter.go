@@ -0,0 +1,221 @@
+package generator
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// generateAdapter prints XxxAdapter, serving each method of the service, and each of its
+// additional_bindings, from a router.HTTPContext rather than a gin context, its
+// implementation calling an XxxHandler, and RegisterXxxAdapter mounting an adapter on gin
+// through router.GinHTTP.
+func (g *Generator) generateAdapter(servName, prefix string, service *descriptor.ServiceDescriptorProto, methodAnnotations []map[string]string) {
+	g.P(`// `, servName, `Adapter serves the routes of `, servName, ` independently of the HTTP router.`)
+	g.P(`type `, servName, `Adapter interface {`)
+	for _, method := range service.Method {
+		for _, name := range g.adapterMethodNames(method) {
+			g.P(name, `(ctx router.HTTPContext)`)
+		}
+	}
+	g.P(`}`)
+	g.P()
+
+	impl := strings.ToLower(servName[:1]) + servName[1:] + "Adapter"
+
+	g.P(`type `, impl, ` struct {`)
+	g.P(`h `, servName, `Handler`)
+	g.P(`}`)
+	g.P()
+	g.P(`// New`, servName, `Adapter returns the `, servName, `Adapter calling h.`)
+	g.P(`func New`, servName, `Adapter(h `, servName, `Handler) `, servName, `Adapter {`)
+	g.P(`return `, impl, `{h: h}`)
+	g.P(`}`)
+	g.P()
+
+	for i, method := range service.Method {
+		g.generateAdapterMethod(impl, prefix, method, methodAnnotations[i])
+	}
+
+	g.P(`// Register`, servName, `Adapter registers the routes of a on g.`)
+	g.P(`func Register`, servName, `Adapter(g *gin.Engine, a `, servName, `Adapter) {`)
+	if g.sharedChains {
+		g.generateChains(methodAnnotations)
+	}
+	for i, method := range service.Method {
+		var middlewares []string
+		if val, ok := methodAnnotations[i]["middleware"]; ok {
+			middlewares = strings.Split(val, ",")
+		}
+
+		names := g.adapterMethodNames(method)
+		for j, opts := range g.httpRules(method) {
+			verb, url := g.httpPattern(method, opts)
+			g.generateRoute(verb, prefix+url, middlewares)
+			g.P(`a.`, names[j], `(router.GinHTTP(ctx))`)
+			g.P(`})`)
+		}
+	}
+	g.P(`}`)
+	g.P()
+}
+
+// adapterMethodNames returns the names of the adapter methods serving the HTTP rule of a
+// method and its additional_bindings: the method name, then <Method>Binding<n> for the
+// n-th additional binding.
+func (g *Generator) adapterMethodNames(method *descriptor.MethodDescriptorProto) []string {
+	methName := g.methodName(method)
+	names := []string{methName}
+	for i := 1; i < len(g.httpRules(method)); i++ {
+		names = append(names, methName+"Binding"+strconv.Itoa(i))
+	}
+	return names
+}
+
+// generateAdapterMethod prints the adapter methods of a service method, one per binding:
+// the input is bound from the query for read-only verbs or the body otherwise, completed
+// from the path, then passed to the handler whose output is written with the method's
+// success status. With additional_bindings, the binding methods share serve<Method>, which
+// handles the bound input.
+func (g *Generator) generateAdapterMethod(impl, prefix string, method *descriptor.MethodDescriptorProto, customAnnotations map[string]string) {
+	g.inAdapter = true
+	defer func() { g.inAdapter = false }()
+
+	if method.GetServerStreaming() || method.GetClientStreaming() {
+		g.Fail("adapter:", method.GetName(), "is a streaming method, adapters serve unary methods only")
+	}
+	// Adapters bind through router.HTTPContext, which has none of the gin request handling
+	// these annotations need.
+	if _, ok := customAnnotations["rawbody"]; ok {
+		g.Fail("adapter:", method.GetName(), "has a rawbody annotation, adapters can't read the raw body")
+	}
+	if val, ok := customAnnotations["binding"]; ok && !strings.EqualFold(val, "json") {
+		g.Fail("adapter:", method.GetName(), "is bound with", val, "binding, adapters bind JSON bodies only")
+	}
+	if strings.EqualFold(customAnnotations["strictjson"], "true") {
+		g.Fail("adapter:", method.GetName(), "has strictjson:true, adapters can't reject unknown fields")
+	}
+	if isStreamDecode(customAnnotations) {
+		g.Fail("adapter:", method.GetName(), "has stream_decode:true, adapters serve unary methods only")
+	}
+	for _, key := range []string{"tenant", "version", "cursor", "cachecontrol", "retry", "idempotent", "trailingslash"} {
+		if _, ok := customAnnotations[key]; ok {
+			g.Fail("adapter:", method.GetName(), "has the", key, "annotation, adapters don't implement it")
+		}
+	}
+
+	gec := g.errorCode(method, customAnnotations)
+	methName := g.methodName(method)
+
+	inType := g.inputTypeName(method)
+	needBind := true
+	if inType == "router.Empty" {
+		needBind = false
+	} else if d, ok := g.ObjectNamed(method.GetInputType()).(*Descriptor); ok && len(d.Field) == 0 {
+		needBind = false
+	}
+	outType := g.typeName(method.GetOutputType())
+
+	rules := g.httpRules(method)
+	shared := len(rules) > 1
+	names := g.adapterMethodNames(method)
+	for i, opts := range rules {
+		verb, url := g.httpPattern(method, opts)
+		url = prefix + url
+
+		if opts.ResponseBody != "" && opts.ResponseBody != "json" {
+			g.Fail("adapter:", method.GetName(), "has the response_body", opts.ResponseBody+",", "adapters write the output as JSON only")
+		}
+		if verb == "HEAD" {
+			g.Fail("adapter:", method.GetName(), "is a HEAD method, adapters write the output as JSON only")
+		}
+		if g.enumParse && readOnlyVerbs[verb] {
+			if d, ok := g.ObjectNamed(method.GetInputType()).(*Descriptor); ok {
+				for _, field := range d.Field {
+					if field.GetType() == descriptor.FieldDescriptorProto_TYPE_ENUM {
+						g.Fail("adapter:", method.GetName(), "binds the enum", field.GetName(), "from the query, enum_parse names are only converted with gin")
+					}
+				}
+			}
+		}
+
+		g.P(`func (a `, impl, `) `, names[i], `(ctx router.HTTPContext) {`)
+		if shared {
+			g.P(`input := `, inType, `{}`)
+		} else {
+			g.P(`input, output := `, inType, `{}, `, outType, `{}`)
+		}
+		g.P()
+		if needBind {
+			bindCall := `ctx.Bind(&input)`
+			if readOnlyVerbs[verb] {
+				bindCall = `ctx.BindQuery(&input)`
+			}
+			if strings.EqualFold(customAnnotations["bindcheck"], "false") {
+				g.P(`_ = `, bindCall)
+			} else {
+				g.P(`if err := `, bindCall, `; err != nil {`)
+				g.P(g.errorCall(gec))
+				g.P(`return`)
+				g.P(`}`)
+			}
+			g.generateWildcards(method, url)
+			g.generatePathParams(method, url, gec)
+		}
+		if shared {
+			if needBind {
+				g.P()
+			}
+			g.P(`a.serve`, methName, `(ctx, input)`)
+		} else {
+			g.generateAdapterServe(method, needBind, gec, customAnnotations)
+		}
+		g.P(`}`)
+		g.P()
+	}
+
+	if shared {
+		g.P(`// serve`, methName, ` serves the input bound by `, strings.Join(names, ", "), `.`)
+		g.P(`func (a `, impl, `) serve`, methName, `(ctx router.HTTPContext, input `, inType, `) {`)
+		g.P(`output := `, outType, `{}`)
+		g.P()
+		g.generateAdapterServe(method, needBind, gec, customAnnotations)
+		g.P(`}`)
+		g.P()
+	}
+}
+
+// generateAdapterServe prints the rest of an adapter method once its input is bound: the
+// header fields, the maxlen and validate checks, then the call to the handler and the
+// rendering of its output.
+func (g *Generator) generateAdapterServe(method *descriptor.MethodDescriptorProto, needBind bool, gec string, customAnnotations map[string]string) {
+	if needBind {
+		g.generateHeaderFields(method, gec)
+		g.generateMaxLen(method)
+		if d, ok := g.ObjectNamed(method.GetInputType()).(*Descriptor); ok && len(g.defaultFields(d)) > 0 {
+			g.P(`input.ApplyDefaults()`)
+		}
+		g.P()
+		if g.hasValidateTags(method) {
+			g.generateValidate(gec)
+		}
+	}
+
+	user := ""
+	if g.needAuthUser(customAnnotations) {
+		user = "ctx.User(), "
+	}
+
+	g.P(`if err := a.h.`, g.methodName(method), `(ctx.Context(), `, user, `&input, &output); err != nil {`)
+	g.P(g.errorCall(gec))
+	g.P(`return`)
+	g.P(`}`)
+	g.P()
+
+	status := "200"
+	if val, ok := g.successStatus(customAnnotations); ok {
+		status = val
+	}
+	g.P(`ctx.JSON(`, status, `, &output)`)
+}
@@ -0,0 +1,184 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"google.golang.org/genproto/googleapis/api/annotations"
+)
+
+func TestAdapterUnsupported(t *testing.T) {
+	tests := []struct {
+		name    string
+		param   string
+		method  int32  // index of the method the comment leads
+		comment string // leading comment of the method
+		want    string
+	}{
+		{"decompress_request", "decompress_request=true", 1, "", "adapter and decompress_request can't be combined"},
+		{"content_type_check", "content_type_check=true", 1, "", "adapter and content_type_check can't be combined"},
+		{"buffered_bind", "buffered_bind=true", 1, "", "adapter and buffered_bind can't be combined"},
+		{"strict_json", "strict_json=true", 1, "", "adapter and strict_json can't be combined"},
+		{"field_errors", "field_errors=true", 1, "", "adapter and field_errors can't be combined"},
+		{"access_log", "access_log=true", 1, "", "adapter and access_log can't be combined"},
+		{"metrics", "metrics=true", 1, "", "adapter and metrics can't be combined"},
+		{"otel", "otel=true", 1, "", "adapter and otel can't be combined"},
+		{"route_context", "route_context=true", 1, "", "adapter and route_context can't be combined"},
+		{"deprecation_log", "deprecation_log=true", 1, "", "adapter and deprecation_log can't be combined"},
+		{"rawbody", "", 1, " @tag rawbody:payload\n", "has a rawbody annotation"},
+		{"binding", "", 1, " @tag binding:form\n", "is bound with form binding"},
+		{"strictjson", "", 1, " @tag strictjson:true\n", "has strictjson:true"},
+		{"stream_decode", "", 1, " @tag stream_decode:true\n", "has stream_decode:true"},
+		{"tenant", "", 0, " @tag tenant:header:X-Tenant\n", "has the tenant annotation"},
+		{"version", "", 0, " @tag version:2\n", "has the version annotation"},
+		{"cursor", "", 0, " @tag cursor:Cursor\n", "has the cursor annotation"},
+		{"cachecontrol", "", 0, " @tag cachecontrol:no-cache\n", "has the cachecontrol annotation"},
+		{"retry", "", 0, " @tag retry:3\n", "has the retry annotation"},
+		{"idempotent", "", 0, " @tag idempotent:true\n", "has the idempotent annotation"},
+		{"trailingslash", "", 0, " @tag trailingslash:redirect\n", "has the trailingslash annotation"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := goldenUserFile()
+			// payload is the bytes field the rawbody case reads the body into, page_token the
+			// field the cursor case decodes.
+			user := file.MessageType[1]
+			user.Field = append(user.Field, goldenField("payload", 4, descriptor.FieldDescriptorProto_TYPE_BYTES, ""))
+			req := file.MessageType[0]
+			req.Field = append(req.Field, goldenField("page_token", 2, descriptor.FieldDescriptorProto_TYPE_STRING, ""))
+			file.MessageType = append(file.MessageType, goldenMessage("Cursor"))
+			if tt.comment != "" {
+				goldenComment(file, tt.comment, 6, 0, 2, tt.method)
+			}
+			param := "stdctx=true,adapter=true"
+			if tt.param != "" {
+				param += "," + tt.param
+			}
+
+			if out := generateError(t, generateRequest(t, param, file)); !strings.Contains(out, tt.want) {
+				t.Errorf("generating %q failed with %q, want an error containing %q", param, out, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdapterUnsupportedRule(t *testing.T) {
+	tests := []struct {
+		name string
+		rule *annotations.HttpRule
+		want string
+	}{
+		{"response_body", &annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: "/v1/users/{user_id}"}, ResponseBody: "user_name"}, "has the response_body user_name"},
+		{"HEAD", &annotations.HttpRule{Pattern: &annotations.HttpRule_Custom{Custom: &annotations.CustomHttpPattern{Kind: "HEAD", Path: "/v1/users/{user_id}"}}}, "is a HEAD method"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := goldenUserFile()
+			if err := proto.SetExtension(file.Service[0].Method[0].Options, annotations.E_Http, tt.rule); err != nil {
+				t.Fatal(err)
+			}
+
+			if out := generateError(t, generateRequest(t, "stdctx=true,adapter=true", file)); !strings.Contains(out, tt.want) {
+				t.Errorf("generating the adapter failed with %q, want an error containing %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdapterEnumParse(t *testing.T) {
+	file := goldenUserFile()
+	file.EnumType = append(file.EnumType, goldenEnum("Status", []string{"STATUS_UNKNOWN", "STATUS_ACTIVE"}, []int32{0, 1}))
+	req := file.MessageType[0]
+	req.Field = append(req.Field, goldenField("status", 2, descriptor.FieldDescriptorProto_TYPE_ENUM, ".user.Status"))
+
+	out := generateError(t, generateRequest(t, "stdctx=true,adapter=true,enum_parse=true", file))
+	if want := "binds the enum status from the query"; !strings.Contains(out, want) {
+		t.Errorf("generating the adapter failed with %q, want an error containing %q", out, want)
+	}
+}
+
+// adapterTest serves the UserService of TestGoldenAdapter through its adapter, GetUser
+// being bound from the path and from the body of its additional binding, CreateUser
+// checked against maxlen.
+const adapterTest = `package user
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type adapterHandler struct{}
+
+func (adapterHandler) GetUser(ctx context.Context, in *GetUserReq, out *User) error {
+	out.UserId = in.UserId
+	return nil
+}
+
+func (adapterHandler) CreateUser(ctx context.Context, in *User, out *User) error {
+	*out = *in
+	return nil
+}
+
+func (adapterHandler) Ping(ctx context.Context, in *Empty, out *Empty) error {
+	return nil
+}
+
+func TestAdapter(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceAdapter(g, NewUserServiceAdapter(adapterHandler{}))
+
+	for _, tt := range []struct {
+		method, target, body string
+		code                 int
+		userID               float64
+	}{
+		{"GET", "/v1/users/7", "", 0, 7},
+		{"POST", "/v1/lookups", ` + "`" + `{"userId":8}` + "`" + `, 0, 8},
+		{"GET", "/v1/users/0", "", 500, 0},
+		{"POST", "/v1/lookups", ` + "`" + `{"userId":0}` + "`" + `, 500, 0},
+	} {
+		_, resp := serve(t, g, tt.method, tt.target, tt.body)
+		if resp.Code != tt.code {
+			t.Errorf("%s %s = %v, want code %d", tt.method, tt.target, resp, tt.code)
+			continue
+		}
+		if out, _ := resp.Data.(map[string]any); tt.code == 0 && out["userId"] != tt.userID {
+			t.Errorf("%s %s = %v, want userId %v", tt.method, tt.target, resp, tt.userID)
+		}
+	}
+
+	_, resp := serve(t, g, "POST", "/v1/users", ` + "`" + `{"userName":"ann"}` + "`" + `)
+	if out, _ := resp.Data.(map[string]any); resp.Code != 0 || out["userName"] != "ann" {
+		t.Errorf("POST /v1/users = %v, want userName ann", resp)
+	}
+	if _, resp := serve(t, g, "POST", "/v1/users", ` + "`" + `{"userName":"annabel"}` + "`" + `); resp.Code != 400 {
+		t.Errorf("POST /v1/users with a long name = %v, want code 400", resp)
+	}
+	if _, resp := serve(t, g, "GET", "/v1/ping", ""); resp.Code != 0 {
+		t.Errorf("GET /v1/ping = %v, want code 0", resp)
+	}
+}
+`
+
+func TestGoldenAdapter(t *testing.T) {
+	file := goldenUserFile()
+	goldenBindings(file.Service[0].Method[0], &annotations.HttpRule{Pattern: &annotations.HttpRule_Post{Post: "/v1/lookups"}, Body: "*"})
+	goldenComment(file, " @tag validate:gt=0\n", 4, 0, 2, 0)
+	goldenComment(file, " @tag maxlen:5\n", 4, 1, 2, 1)
+
+	resp := generateGolden(t, "stdctx=true,adapter=true,validate_aggregate=true", file)
+	checkGolden(t, "adapter", resp)
+
+	api := goldenContent(t, resp, "user/user.api.go")
+	if !strings.Contains(api, "GetUserBinding1(ctx router.HTTPContext)") || !strings.Contains(api, "input.Validate()") {
+		t.Errorf("user.api.go has no GetUserBinding1 adapter method validating with input.Validate():\n%s", api)
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":   serveTest,
+		"router/user/adapter_test.go": adapterTest,
+	})
+}
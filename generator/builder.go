@@ -0,0 +1,109 @@
+package generator
+
+import (
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/genproto/googleapis/api/annotations"
+)
+
+// isPostBody reports whether the message is bound from the body of a POST method
+// of one of the files being generated.
+func (g *Generator) isPostBody(message *Descriptor) bool {
+	for _, f := range g.genFiles {
+		for _, service := range f.Service {
+			for _, method := range service.Method {
+				if g.ObjectNamed(method.GetInputType()) != Object(message) {
+					continue
+				}
+				if method.Options == nil || !proto.HasExtension(method.Options, annotations.E_Http) {
+					continue
+				}
+				ext, _ := proto.GetExtension(method.Options, annotations.E_Http)
+				if opts, ok := ext.(*annotations.HttpRule); ok {
+					if _, ok := opts.Pattern.(*annotations.HttpRule_Post); ok {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// generateBodyBuilder prints the New constructor and a chainable With setter per field,
+// so request bodies can be written as NewCreateUserReq().WithName("x").WithAge(30).
+func (g *Generator) generateBodyBuilder(mc *msgCtx, topLevelFields []topLevelField) {
+	recv := g.receiverName(mc.goName)
+
+	g.P("// New", mc.goName, " returns an empty ", mc.goName, " to be filled by its With setters.")
+	g.P("func New", mc.goName, "() *", mc.goName, " {")
+	g.P("return &", mc.goName, "{}")
+	g.P("}")
+	g.P()
+
+	for _, pf := range topLevelFields {
+		f, ok := pf.(*simpleField)
+		if !ok {
+			continue
+		}
+		g.P("// With", f.goName, " sets ", f.goName, " and returns the message for chaining.")
+		g.P("func (", recv, " *", mc.goName, ") With", f.goName, "(v ", f.goType, ") *", mc.goName, " {")
+		g.P(recv, ".", f.goName, " = v")
+		g.P("return ", recv)
+		g.P("}")
+		g.P()
+	}
+}
+
+// requiredFields returns the fields of the message whose validate annotation has the
+// required rule, in declaration order.
+func requiredFields(topLevelFields []topLevelField, options map[string]map[string]string) []*simpleField {
+	var fields []*simpleField
+	for _, pf := range topLevelFields {
+		f, ok := pf.(*simpleField)
+		if !ok {
+			continue
+		}
+		for _, rule := range strings.Split(options[f.goName]["validate"], ",") {
+			if rule == "required" {
+				fields = append(fields, f)
+				break
+			}
+		}
+	}
+	return fields
+}
+
+// generateCheckedConstructor prints the New constructor taking the required fields of the
+// message and running its Validate method, so that invalid messages can't be constructed.
+func (g *Generator) generateCheckedConstructor(mc *msgCtx, topLevelFields []topLevelField, options map[string]map[string]string) {
+	fields := requiredFields(topLevelFields, options)
+
+	params := make([]string, len(fields))
+	args := make([]string, len(fields))
+	for i, f := range fields {
+		name := strings.ToLower(f.goName[:1]) + f.goName[1:]
+		if name == "m" || isGoKeyword[name] || isGoPredeclaredIdentifier[name] {
+			name += "_"
+		}
+		params[i] = name + " " + f.goType
+		args[i] = f.goName + ": " + name + ","
+	}
+
+	g.P("// New", mc.goName, " returns a ", mc.goName, " holding the required fields, or the error of its")
+	g.P("// Validate method if it is invalid.")
+	g.P("func New", mc.goName, "(", strings.Join(params, ", "), ") (*", mc.goName, ", error) {")
+	g.P("m := &", mc.goName, "{")
+	for _, arg := range args {
+		g.P(arg)
+	}
+	g.P("}")
+	g.P("if err := m.Validate(); err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P()
+	g.P("return m, nil")
+	g.P("}")
+	g.P()
+}
@@ -0,0 +1,80 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+// bodyBuilderTest checks the chained builder of the User of TestGoldenBodyBuilder.
+const bodyBuilderTest = `package user
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUserBuilder(t *testing.T) {
+	got := NewUser().WithUserId(1).WithUserName("ann").WithTags([]string{"admin"})
+	want := &User{UserId: 1, UserName: "ann", Tags: []string{"admin"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("built %+v, want %+v", got, want)
+	}
+}
+`
+
+func TestGoldenBodyBuilder(t *testing.T) {
+	resp := generateGolden(t, "body_builder=true", goldenUserFile())
+	checkGolden(t, "body_builder", resp)
+
+	// GetUserReq is bound from the query and Empty from the query of Ping.
+	if model := goldenContent(t, resp, "user/user.model.go"); strings.Contains(model, "func NewGetUserReq") || strings.Contains(model, "func NewEmpty") {
+		t.Errorf("user.model.go has builders for messages that aren't POST bodies:\n%s", model)
+	}
+	compileGolden(t, resp, map[string]string{"router/user/builder_test.go": bodyBuilderTest})
+}
+
+// checkedConstructorTest checks NewUser refuses to construct an invalid User.
+const checkedConstructorTest = `package user
+
+import (
+	"errors"
+	"testing"
+
+	"example.com/app/router/router"
+)
+
+func TestCheckedConstructor(t *testing.T) {
+	for _, name := range []string{"", "a"} {
+		var verrs router.ValidationErrors
+		if u, err := NewUser(name); u != nil || !errors.As(err, &verrs) {
+			t.Errorf("NewUser(%q) = %+v, %v, want router.ValidationErrors", name, u, err)
+		}
+	}
+
+	u, err := NewUser("ann")
+	if err != nil || u.UserName != "ann" {
+		t.Errorf("NewUser(ann) = %+v, %v, want a user named ann", u, err)
+	}
+}
+`
+
+func TestGoldenCheckedConstructor(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag validate:required,min=2\n", 4, 1, 2, 1)
+
+	resp := generateGolden(t, "checked_constructors=true,validate_aggregate=true", file)
+	checkGolden(t, "checked_constructors", resp)
+	if model := goldenContent(t, resp, "user/user.model.go"); !strings.Contains(model, "func NewUser(userName string) (*User, error) {") {
+		t.Errorf("user.model.go has no NewUser taking the required user name:\n%s", model)
+	}
+	compileGolden(t, resp, map[string]string{"router/user/checked_constructor_test.go": checkedConstructorTest})
+
+	for param, want := range map[string]string{
+		"checked_constructors=true": "requires validate_aggregate=true",
+		"checked_constructors=true,validate_aggregate=true,body_builder=true": "enable only one",
+	} {
+		if _, err := Run(generateRequest(t, param, file)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Run(%q) = %v, want an error containing %q", param, err, want)
+		}
+	}
+}
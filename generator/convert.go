@@ -0,0 +1,341 @@
+package generator
+
+import (
+	"path"
+	"strings"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// pbPackage is the name under which the protoc-gen-go package is imported.
+const pbPackage = "pb"
+
+// generatePBConvert prints the ToPB method and the FromPB function converting the
+// message to and from the struct generated by protoc-gen-go in the pb_import package.
+// Fields mapped to interface{} or map[string]interface{} (Any, Struct, Value, ListValue)
+// have no direct counterpart and are left out of the conversion.
+func (g *Generator) generatePBConvert(mc *msgCtx, topLevelFields []topLevelField) {
+	g.addExternalImport(GoImportPath(g.pbImport), pbPackage)
+
+	pbType := pbPackage + "." + mc.goName
+	recv := g.receiverName(mc.goName)
+
+	g.P("// ToPB converts ", mc.goName, " to its protoc-gen-go counterpart.")
+	g.P("func (", recv, " *", mc.goName, ") ToPB() *", pbType, " {")
+	g.P("if ", recv, " == nil {")
+	g.P("return nil")
+	g.P("}")
+	g.P()
+	g.P("out := &", pbType, "{}")
+	for i, field := range mc.message.Field {
+		if f, ok := topLevelFields[i].(*simpleField); ok {
+			g.generateFieldToPB(mc, recv, field, f)
+		}
+	}
+	g.P("return out")
+	g.P("}")
+	g.P()
+
+	g.P("// FromPB", mc.goName, " converts the protoc-gen-go ", mc.goName, " to ", mc.goName, ".")
+	g.P("func FromPB", mc.goName, "(in *", pbType, ") *", mc.goName, " {")
+	g.P("if in == nil {")
+	g.P("return nil")
+	g.P("}")
+	g.P()
+	g.P("m := &", mc.goName, "{}")
+	for i, field := range mc.message.Field {
+		if f, ok := topLevelFields[i].(*simpleField); ok {
+			g.generateFieldFromPB(mc, field, f)
+		}
+	}
+	g.P("return m")
+	g.P("}")
+	g.P()
+}
+
+// pbFieldName returns the name protoc-gen-go gives the field, never stripped of the message
+// name prefix.
+func pbFieldName(message *Descriptor, field *descriptor.FieldDescriptorProto) string {
+	names, _ := allocFieldNames(message, false)
+	for i, f := range message.Field {
+		if f == field {
+			return names[i]
+		}
+	}
+	return CamelCase(field.GetName())
+}
+
+// generatePBEnumConvert prints the ToPB method and the FromPB function converting the
+// enum to and from the enum type generated by protoc-gen-go in the pb_import package.
+func (g *Generator) generatePBEnumConvert(ccTypeName string) {
+	g.addExternalImport(GoImportPath(g.pbImport), pbPackage)
+
+	pbType := pbPackage + "." + ccTypeName
+	recv := g.receiverName(ccTypeName)
+
+	g.P("// ToPB converts ", ccTypeName, " to its protoc-gen-go counterpart.")
+	g.P("func (", recv, " ", ccTypeName, ") ToPB() ", pbType, " {")
+	g.P("return ", pbType, "(", recv, ")")
+	g.P("}")
+	g.P()
+	g.P("// FromPB", ccTypeName, " converts the protoc-gen-go ", ccTypeName, " to ", ccTypeName, ".")
+	g.P("func FromPB", ccTypeName, "(x ", pbType, ") ", ccTypeName, " {")
+	g.P("return ", ccTypeName, "(x)")
+	g.P("}")
+	g.P()
+}
+
+func (g *Generator) generateFieldToPB(mc *msgCtx, recv string, field *descriptor.FieldDescriptorProto, f *simpleField) {
+	if strings.Contains(f.goType, "interface{}") {
+		return
+	}
+
+	name, pbName := f.goName, pbFieldName(mc.message, field)
+
+	if fn, ok := g.wrapperPBFunc(field); ok {
+		g.P("if ", recv, ".", name, " != nil {")
+		g.P("out.", pbName, " = ", fn, "(*", recv, ".", name, ")")
+		g.P("}")
+		return
+	}
+
+	if d, ok := g.mapEntry(field); ok {
+		keyType, _ := g.GoType("", d, d.Field[0])
+		keyType = strings.TrimPrefix(keyType, "*")
+		valField := d.Field[1]
+		g.P("if ", recv, ".", name, " != nil {")
+		g.P("out.", pbName, " = make(map[", keyType, "]", g.pbElemType(valField), ", len(", recv, ".", name, "))")
+		g.P("for k, v := range ", recv, ".", name, " {")
+		g.P("out.", pbName, "[k] = ", g.elemToPB(valField, "v"))
+		g.P("}")
+		g.P("}")
+		return
+	}
+
+	switch {
+	case isRepeated(field):
+		if conv := g.elemToPB(field, "v"); conv != "v" {
+			g.P("for _, v := range ", recv, ".", name, " {")
+			g.P("out.", pbName, " = append(out.", pbName, ", ", conv, ")")
+			g.P("}")
+		} else {
+			g.P("out.", pbName, " = ", recv, ".", name)
+		}
+	case field.GetProto3Optional():
+		g.P("{")
+		g.P("v := ", g.elemToPB(field, recv+"."+name))
+		g.P("out.", pbName, " = &v")
+		g.P("}")
+	case field.OneofIndex != nil:
+		oneof := CamelCase(mc.message.OneofDecl[field.GetOneofIndex()].GetName())
+		g.P("if ", zeroCheck(field, recv+"."+name), " {")
+		g.P("out.", oneof, " = &", pbPackage, ".", mc.goName, "_", pbName, "{", pbName, ": ", g.elemToPB(field, recv+"."+name), "}")
+		g.P("}")
+	case strings.HasPrefix(f.goType, "*") && field.GetType() == descriptor.FieldDescriptorProto_TYPE_ENUM:
+		g.P("if ", recv, ".", name, " != nil {")
+		g.P("v := ", g.elemToPB(field, "*"+recv+"."+name))
+		g.P("out.", pbName, " = &v")
+		g.P("}")
+	case isWellKnownType(field):
+		g.P("if ", recv, ".", name, " != nil {")
+		g.P("out.", pbName, " = ", g.elemToPB(field, recv+"."+name))
+		g.P("}")
+	default:
+		g.P("out.", pbName, " = ", g.elemToPB(field, recv+"."+name))
+	}
+}
+
+func (g *Generator) generateFieldFromPB(mc *msgCtx, field *descriptor.FieldDescriptorProto, f *simpleField) {
+	if strings.Contains(f.goType, "interface{}") {
+		return
+	}
+
+	name, pbName := f.goName, pbFieldName(mc.message, field)
+
+	if _, ok := g.wrapperPBFunc(field); ok {
+		g.P("if in.", pbName, " != nil {")
+		g.P("v := in.", pbName, ".GetValue()")
+		g.P("m.", name, " = &v")
+		g.P("}")
+		return
+	}
+
+	if d, ok := g.mapEntry(field); ok {
+		g.P("if in.", pbName, " != nil {")
+		g.P("m.", name, " = make(", f.goType, ", len(in.", pbName, "))")
+		g.P("for k, v := range in.", pbName, " {")
+		if valField := d.Field[1]; g.mapValues && valField.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE && !isWellKnownType(valField) {
+			g.P("if x := ", g.elemFromPB(valField, "v"), "; x != nil {")
+			g.P("m.", name, "[k] = *x")
+			g.P("}")
+		} else {
+			g.P("m.", name, "[k] = ", g.elemFromPB(valField, "v"))
+		}
+		g.P("}")
+		g.P("}")
+		return
+	}
+
+	switch {
+	case isRepeated(field):
+		if conv := g.elemFromPB(field, "v"); conv != "v" {
+			g.P("for _, v := range in.", pbName, " {")
+			g.P("m.", name, " = append(m.", name, ", ", conv, ")")
+			g.P("}")
+		} else {
+			g.P("m.", name, " = in.", pbName)
+		}
+	case field.GetProto3Optional():
+		g.P("if in.", pbName, " != nil {")
+		g.P("m.", name, " = ", g.elemFromPB(field, "*in."+pbName))
+		g.P("}")
+	case field.OneofIndex != nil:
+		g.P("if v, ok := in.", CamelCase(mc.message.OneofDecl[field.GetOneofIndex()].GetName()), ".(*", pbPackage, ".", mc.goName, "_", pbName, "); ok {")
+		g.P("m.", name, " = ", g.elemFromPB(field, "v."+pbName))
+		g.P("}")
+	case strings.HasPrefix(f.goType, "*") && field.GetType() == descriptor.FieldDescriptorProto_TYPE_ENUM:
+		g.P("if in.", pbName, " != nil {")
+		g.P("v := ", g.elemFromPB(field, "*in."+pbName))
+		g.P("m.", name, " = &v")
+		g.P("}")
+	case isWellKnownType(field):
+		g.P("if in.", pbName, " != nil {")
+		g.P("m.", name, " = ", g.elemFromPB(field, "in."+pbName))
+		g.P("}")
+	default:
+		g.P("m.", name, " = ", g.elemFromPB(field, "in."+pbName))
+	}
+}
+
+// wrappersImport is the package of the protoc-gen-go wrapper types.
+const wrappersImport = "google.golang.org/protobuf/types/known/wrapperspb"
+
+// wrapperPBFunc returns the wrapperspb constructor of a singular wrapper field, whose Go type
+// is a nullable scalar rather than the protoc-gen-go message. Repeated, map and oneof
+// wrappers have no such conversion and fail the generation.
+func (g *Generator) wrapperPBFunc(field *descriptor.FieldDescriptorProto) (string, bool) {
+	if d, ok := g.mapEntry(field); ok {
+		if _, ok := wrapperTypes[d.Field[1].GetTypeName()]; ok {
+			g.Fail("pb_convert: map field", field.GetName(), "has wrapper values")
+		}
+		return "", false
+	}
+
+	if _, ok := wrapperTypes[field.GetTypeName()]; !ok {
+		return "", false
+	}
+	if isRepeated(field) || field.OneofIndex != nil && !field.GetProto3Optional() {
+		g.Fail("pb_convert: wrapper field", field.GetName(), "must be a singular field outside of oneofs")
+	}
+
+	g.addExternalImport(wrappersImport, "")
+
+	// The constructors are named after the wrappers: String for StringValue.
+	return "wrapperspb." + strings.TrimSuffix(strings.TrimPrefix(field.GetTypeName(), ".google.protobuf."), "Value"), true
+}
+
+// mapEntry returns the map entry descriptor of a map field.
+func (g *Generator) mapEntry(field *descriptor.FieldDescriptorProto) (*Descriptor, bool) {
+	if field.GetType() != descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+		return nil, false
+	}
+	d, ok := g.ObjectNamed(field.GetTypeName()).(*Descriptor)
+	if !ok || !d.GetOptions().GetMapEntry() {
+		return nil, false
+	}
+	return d, true
+}
+
+// isWellKnownType reports whether the field refers to a google.protobuf type, which
+// protoc-gen-go declares in its own packages rather than in the pb_import package.
+func isWellKnownType(field *descriptor.FieldDescriptorProto) bool {
+	return strings.HasPrefix(field.GetTypeName(), ".google.protobuf.")
+}
+
+// pbWellKnownTypes maps the well-known messages converted by pb_convert to their
+// protoc-gen-go package. Both hold Seconds and Nanos, copied field by field.
+var pbWellKnownTypes = map[string]string{
+	".google.protobuf.Timestamp": "google.golang.org/protobuf/types/known/timestamppb",
+	".google.protobuf.Duration":  "google.golang.org/protobuf/types/known/durationpb",
+}
+
+// pbWellKnownType returns the protoc-gen-go type of a Timestamp or Duration field, importing
+// its package. Other well-known messages have no conversion and fail the generation.
+func (g *Generator) pbWellKnownType(field *descriptor.FieldDescriptorProto) string {
+	importPath, ok := pbWellKnownTypes[field.GetTypeName()]
+	if !ok {
+		g.Fail("pb_convert: field", field.GetName(), "has type", field.GetTypeName(), "which has no protoc-gen-go conversion")
+	}
+	g.addExternalImport(GoImportPath(importPath), "")
+	return path.Base(importPath) + "." + strings.TrimPrefix(field.GetTypeName(), ".google.protobuf.")
+}
+
+// pbTypeName returns the name of the protoc-gen-go type for a message or enum
+// declared in the package being generated.
+func (g *Generator) pbTypeName(field *descriptor.FieldDescriptorProto) string {
+	obj := g.ObjectNamed(field.GetTypeName())
+	if obj.File().GetPackage() != g.file.GetPackage() {
+		g.Fail("pb_convert: type", field.GetTypeName(), "of field", field.GetName(), "is declared in another package")
+	}
+	return pbPackage + "." + CamelCaseSlice(obj.TypeName())
+}
+
+// pbElemType returns the protoc-gen-go element type of a map value field.
+func (g *Generator) pbElemType(field *descriptor.FieldDescriptorProto) string {
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		if isWellKnownType(field) {
+			return "*" + g.pbWellKnownType(field)
+		}
+		return "*" + g.pbTypeName(field)
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		return g.pbTypeName(field)
+	}
+	typ, _ := g.GoType("", nil, field)
+	return strings.TrimPrefix(typ, "*")
+}
+
+// elemToPB returns the expression converting a single element to its protoc-gen-go value.
+func (g *Generator) elemToPB(field *descriptor.FieldDescriptorProto, x string) string {
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		if isWellKnownType(field) {
+			return "&" + g.pbWellKnownType(field) + "{Seconds: " + x + ".Seconds, Nanos: " + x + ".Nanos}"
+		}
+		return x + ".ToPB()"
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		return g.pbTypeName(field) + "(" + x + ")"
+	}
+	return x
+}
+
+// elemFromPB returns the expression converting a single protoc-gen-go element to our value.
+func (g *Generator) elemFromPB(field *descriptor.FieldDescriptorProto, x string) string {
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		obj := g.ObjectNamed(field.GetTypeName())
+		if isWellKnownType(field) {
+			g.pbWellKnownType(field)
+			return "&" + g.TypeName(obj) + "{Seconds: " + x + ".GetSeconds(), Nanos: " + x + ".GetNanos()}"
+		}
+		return g.DefaultPackageName(obj) + "FromPB" + CamelCaseSlice(obj.TypeName()) + "(" + x + ")"
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		return g.TypeName(g.ObjectNamed(field.GetTypeName())) + "(" + x + ")"
+	}
+	return x
+}
+
+// zeroCheck returns the condition under which a oneof member holds a value.
+func zeroCheck(field *descriptor.FieldDescriptorProto, x string) string {
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_GROUP:
+		return x + " != nil"
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		return "len(" + x + ") > 0"
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		return x + ` != ""`
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return x
+	}
+	return x + " != 0"
+}
@@ -0,0 +1,156 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+)
+
+// wellKnownFile returns a file of the google.protobuf package declaring the message,
+// made of seconds and nanos like Timestamp and Duration. generateImports leaves the
+// google/protobuf directory out, so the file is named like the other files, name/name.proto,
+// for the generated package of the message to be imported.
+func wellKnownFile(name, message string) *descriptor.FileDescriptorProto {
+	return &descriptor.FileDescriptorProto{
+		Name:    proto.String(name + "/" + name + ".proto"),
+		Package: proto.String("google.protobuf"),
+		Syntax:  proto.String("proto3"),
+		Options: &descriptor.FileOptions{GoPackage: proto.String(goldenRepo + "/" + name + ";" + name)},
+		MessageType: []*descriptor.DescriptorProto{
+			goldenMessage(message,
+				goldenField("seconds", 1, descriptor.FieldDescriptorProto_TYPE_INT64, ""),
+				goldenField("nanos", 2, descriptor.FieldDescriptorProto_TYPE_INT32, ""),
+			),
+		},
+	}
+}
+
+// eventPB is the protoc-gen-go counterpart of the Event message of TestGoldenPBConvert.
+const eventPB = `package pb
+
+import (
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type Status int32
+
+type Event struct {
+	Id       string
+	At       *timestamppb.Timestamp
+	Took     *durationpb.Duration
+	History  []*timestamppb.Timestamp
+	Spans    map[string]*durationpb.Duration
+	Child    *Event
+	Children []*Event
+	Status   Status
+}
+`
+
+func TestGoldenPBConvert(t *testing.T) {
+	spans := goldenMessage("SpansEntry",
+		goldenField("key", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+		goldenField("value", 2, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Duration"),
+	)
+	spans.Options = &descriptor.MessageOptions{MapEntry: proto.Bool(true)}
+
+	event := goldenMessage("Event",
+		goldenField("id", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+		goldenField("at", 2, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp"),
+		goldenField("took", 3, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Duration"),
+		goldenRepeated(goldenField("history", 4, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp")),
+		goldenRepeated(goldenField("spans", 5, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".event.Event.SpansEntry")),
+		goldenField("child", 6, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".event.Event"),
+		goldenRepeated(goldenField("children", 7, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".event.Event")),
+		goldenField("status", 8, descriptor.FieldDescriptorProto_TYPE_ENUM, ".event.Status"),
+	)
+	event.NestedType = []*descriptor.DescriptorProto{spans}
+
+	file := goldenFile("event/event.proto",
+		[]*descriptor.DescriptorProto{event},
+		[]*descriptor.EnumDescriptorProto{goldenEnum("Status", []string{"STATUS_UNKNOWN", "STATUS_DONE"}, []int32{0, 1})},
+		nil)
+	file.Dependency = []string{"timestamp/timestamp.proto", "duration/duration.proto"}
+
+	// Each package is generated by a run of its own.
+	files := []*descriptor.FileDescriptorProto{wellKnownFile("timestamp", "Timestamp"), wellKnownFile("duration", "Duration"), file}
+	resp := &plugin.CodeGeneratorResponse{}
+	for _, f := range files {
+		req := generateRequest(t, "pb_convert=true,pb_import=example.com/app/pb", files...)
+		req.FileToGenerate = []string{f.GetName()}
+		resp.File = append(resp.File, generate(req).File...)
+	}
+	checkGolden(t, "pb_convert", resp)
+	compileGolden(t, resp, map[string]string{"pb/event.pb.go": eventPB})
+}
+
+func TestPBConvertUnsupportedWellKnownType(t *testing.T) {
+	file := goldenFile("event/event.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("Event", goldenField("mask", 1, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.FieldMask")),
+		},
+		nil, nil)
+	file.Dependency = []string{"field_mask/field_mask.proto"}
+
+	mask := wellKnownFile("field_mask", "FieldMask")
+	mask.MessageType[0].Field = []*descriptor.FieldDescriptorProto{
+		goldenRepeated(goldenField("paths", 1, descriptor.FieldDescriptorProto_TYPE_STRING, "")),
+	}
+
+	req := generateRequest(t, "pb_convert=true,pb_import=example.com/app/pb", file, mask)
+	req.FileToGenerate = []string{file.GetName()}
+	out := generateError(t, req)
+	if want := "has no protoc-gen-go conversion"; !strings.Contains(out, want) {
+		t.Errorf("generating the FieldMask field failed with %q, want %q", out, want)
+	}
+}
+
+// statusPB is the protoc-gen-go counterpart of the Status enum of TestGoldenPBEnumConvert.
+const statusPB = `package pb
+
+type Status int32
+
+const (
+	Status_STATUS_UNKNOWN Status = 0
+	Status_STATUS_DONE    Status = 1
+)
+`
+
+// pbEnumConvertTest round-trips Status through its protoc-gen-go counterpart.
+const pbEnumConvertTest = `package event
+
+import (
+	"testing"
+
+	"example.com/app/pb"
+)
+
+func TestPBEnumConvert(t *testing.T) {
+	if got := Status_STATUS_DONE.ToPB(); got != pb.Status_STATUS_DONE {
+		t.Errorf("ToPB() = %v, want %v", got, pb.Status_STATUS_DONE)
+	}
+	if got := FromPBStatus(Status_STATUS_DONE.ToPB()); got != Status_STATUS_DONE {
+		t.Errorf("FromPBStatus(ToPB()) = %v, want %v", got, Status_STATUS_DONE)
+	}
+}
+`
+
+func TestGoldenPBEnumConvert(t *testing.T) {
+	file := goldenFile("event/event.proto", nil,
+		[]*descriptor.EnumDescriptorProto{goldenEnum("Status", []string{"STATUS_UNKNOWN", "STATUS_DONE"}, []int32{0, 1})},
+		nil)
+
+	resp := generateGolden(t, "pb_enum_convert=true,pb_import=example.com/app/pb", file)
+	checkGolden(t, "pb_enum_convert", resp)
+	compileGolden(t, resp, map[string]string{
+		"pb/event.pb.go":                       statusPB,
+		"router/event/pb_enum_convert_test.go": pbEnumConvertTest,
+	})
+
+	if _, err := Run(generateRequest(t, "pb_enum_convert=true", file)); err == nil || !strings.Contains(err.Error(), "requires pb_import") {
+		t.Errorf("Run = %v, want an error for the missing pb_import", err)
+	}
+}
@@ -0,0 +1,86 @@
+package generator
+
+import (
+	"strings"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// defaultField is a field of a request message together with the literal it defaults to.
+type defaultField struct {
+	field *descriptor.FieldDescriptorProto
+	value string
+}
+
+// defaultFields returns the fields of message having a counterpart of the same name and type
+// with a [default = ...] value in the defaults_from message of the package.
+func (g *Generator) defaultFields(message *Descriptor) []defaultField {
+	if g.defaultsFrom == "" || message.File().GetPackage() != g.file.GetPackage() {
+		return nil
+	}
+
+	name := g.defaultsFrom
+	if !strings.HasPrefix(name, ".") {
+		name = "." + g.file.GetPackage() + "." + name
+	}
+	defaults, ok := g.ObjectNamed(name).(*Descriptor)
+	if !ok {
+		g.Fail("defaults_from:", g.defaultsFrom, "is not a message")
+	}
+	if defaults == message {
+		return nil
+	}
+
+	var fields []defaultField
+	for _, field := range message.Field {
+		if isRepeated(field) || field.OneofIndex != nil && !field.GetProto3Optional() {
+			continue
+		}
+		for _, def := range defaults.Field {
+			if def.GetName() != field.GetName() || def.DefaultValue == nil {
+				continue
+			}
+			if def.GetType() != field.GetType() || def.GetTypeName() != field.GetTypeName() {
+				g.Fail("defaults_from: field", def.GetName(), "of", g.defaultsFrom, "does not match the type of", field.GetName())
+			}
+			typename, _ := g.GoType("", defaults, def)
+			if value := g.getterDefault(def, strings.TrimPrefix(typename, "*")); value != "nil" {
+				fields = append(fields, defaultField{field: field, value: value})
+			}
+		}
+	}
+	return fields
+}
+
+// generateDefaults prints ApplyDefaults, setting the zero fields of a request message to
+// the values declared in the defaults_from message.
+func (g *Generator) generateDefaults(mc *msgCtx, fields []defaultField) {
+	recv := g.receiverName(mc.goName)
+
+	g.P("// ApplyDefaults sets the zero fields of ", mc.goName, " to their ", g.defaultsFrom, " value.")
+	g.P("func (", recv, " *", mc.goName, ") ApplyDefaults() {")
+	for _, df := range fields {
+		name := recv + "." + g.fieldGoName(mc.message, df.field)
+		typename, _ := g.GoType("", mc.message, df.field)
+		if strings.HasPrefix(typename, "*") {
+			g.P("if ", name, " == nil {")
+			g.P("v := ", strings.TrimPrefix(typename, "*"), "(", df.value, ")")
+			g.P(name, " = &v")
+			g.P("}")
+			continue
+		}
+
+		switch {
+		case df.field.GetType() == descriptor.FieldDescriptorProto_TYPE_STRING || typename == "json.Number":
+			g.P("if ", name, ` == "" {`)
+		case df.field.GetType() == descriptor.FieldDescriptorProto_TYPE_BOOL:
+			g.P("if !", name, " {")
+		default:
+			g.P("if ", name, " == 0 {")
+		}
+		g.P(name, " = ", df.value)
+		g.P("}")
+	}
+	g.P("}")
+	g.P()
+}
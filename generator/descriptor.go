@@ -161,7 +161,7 @@ func wrapImported(file *FileDescriptor, g *Generator) (sl []*ImportedDescriptor)
 func extractComments(file *FileDescriptor) {
 	file.comments = make(map[string]*descriptor.SourceCodeInfo_Location)
 	for _, loc := range file.GetSourceCodeInfo().GetLocation() {
-		if loc.LeadingComments == nil {
+		if loc.LeadingComments == nil && loc.TrailingComments == nil {
 			continue
 		}
 		var p []string
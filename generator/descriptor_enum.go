@@ -42,7 +42,7 @@ func (e *EnumDescriptor) TypeName() (s []string) {
 func (e *EnumDescriptor) prefix() string {
 	if e.parent == nil {
 		// If the enum is not part of a message, the prefix is just the type name.
-		return CamelCase(*e.Name) + "_"
+		return exported(CamelCase(*e.Name)) + "_"
 	}
 	typeName := e.TypeName()
 	return CamelCaseSlice(typeName[0:len(typeName)-1]) + "_"
@@ -64,19 +64,30 @@ func (d *FileDescriptor) goPackageOption() (impPath GoImportPath, pkg GoPackageN
 	return "", cleanPackageName(opt), true
 }
 
-// goFileName returns the output name for the generated Go file.
-func (d *FileDescriptor) goFileName(pathType pathType, typ string) string {
+// goFileName returns the output name for the generated file ending in suffix, e.g. ".model.go".
+func (d *FileDescriptor) goFileName(pathType pathType, suffix string) string {
 	name := *d.Name
 	if ext := path.Ext(name); ext == ".proto" || ext == ".protodevel" {
 		name = name[:len(name)-len(ext)]
 	}
 
-	name += "." + typ + ".go"
+	name += suffix
 
 	if pathType == pathTypeSourceRelative {
 		return name
 	}
 
+	// With flat=true the files are written at the root of the output directory.
+	if pathType == pathTypeFlat {
+		return path.Base(name)
+	}
+
+	// With group_by=package the files of a package share the directory of its dotted name.
+	if pathType == pathTypePackage {
+		_, name = path.Split(name)
+		return path.Join(strings.Replace(d.GetPackage(), ".", "/", -1), name)
+	}
+
 	// Does the file have a "go_package" option?
 	// If it does, it may override the filename.
 	if impPath, _, ok := d.goPackageOption(); ok && impPath != "" {
@@ -41,10 +41,60 @@ func (f *simpleField) decl(g *Generator, mc *msgCtx) {
 }
 
 // getter prints the getter for the field.
-func (f *simpleField) getter(g *Generator, mc *msgCtx) {}
-
-// setter prints the setter method of the field.
-func (f *simpleField) setter(g *Generator, mc *msgCtx) {}
+func (f *simpleField) getter(g *Generator, mc *msgCtx) {
+	recv := g.receiverName(mc.goName)
+
+	if f.deprecated != "" {
+		g.P(f.deprecated)
+	}
+	g.P("func (", recv, " *", mc.goName, ") ", f.getterName, "() ", f.goType, " {")
+	g.P("if ", recv, " != nil {")
+	g.P("return ", recv, ".", f.goName)
+	g.P("}")
+	g.P("return ", f.getterDef)
+	g.P("}")
+	g.P()
+}
+
+// setter prints the setter method of the field. Oneof members are flattened into the
+// message, so setting one resets the other members of its oneof to their zero value.
+func (f *simpleField) setter(g *Generator, mc *msgCtx) {
+	recv := g.receiverName(mc.goName)
+
+	if f.deprecated != "" {
+		g.P(f.deprecated)
+	}
+	g.P("func (", recv, " *", mc.goName, ") Set", f.goName, "(v ", f.goType, ") {")
+	g.P(recv, ".", f.goName, " = v")
+	for _, sibling := range oneofSiblings(mc.message, f.protoName) {
+		typ, _ := g.GoType("", mc.message, sibling)
+		g.P(recv, ".", g.fieldGoName(mc.message, sibling), " = ", g.getterDefault(sibling, typ))
+	}
+	g.P("}")
+	g.P()
+}
+
+// oneofSiblings returns the other members of the oneof the named field of message belongs to.
+// Fields outside a oneof, or in the synthetic oneof of a proto3 optional field, have none.
+func oneofSiblings(message *Descriptor, name string) []*descriptor.FieldDescriptorProto {
+	var field *descriptor.FieldDescriptorProto
+	for _, fd := range message.Field {
+		if fd.GetName() == name {
+			field = fd
+		}
+	}
+	if field == nil || field.OneofIndex == nil || field.GetProto3Optional() {
+		return nil
+	}
+
+	var siblings []*descriptor.FieldDescriptorProto
+	for _, fd := range message.Field {
+		if fd != field && fd.OneofIndex != nil && fd.GetOneofIndex() == field.GetOneofIndex() {
+			siblings = append(siblings, fd)
+		}
+	}
+	return siblings
+}
 
 // getProtoDef returns the default value explicitly stated in the proto file, e.g "yoshi" or "5".
 func (f *simpleField) getProtoDef() string {
@@ -0,0 +1,414 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// settingsGetterTest calls the getters of the Settings of TestGoldenGetters on nil and set
+// messages.
+const settingsGetterTest = `package user
+
+import "testing"
+
+func TestSettingsGetters(t *testing.T) {
+	var m *Settings
+	if m.GetTheme() != "" || m.GetRetries() != 0 || m.GetEnabled() || m.GetMode() != Mode_MODE_SLOW || m.GetNick() != "" {
+		t.Errorf("scalar getters of a nil Settings don't return the zero values")
+	}
+	if m.GetTags() != nil || m.GetParent() != nil || m.GetLimits() != nil {
+		t.Errorf("getters of a nil Settings don't return nil slices, messages and maps")
+	}
+
+	parent := &Settings{}
+	m = &Settings{
+		Theme:   "light",
+		Retries: 3,
+		Enabled: true,
+		Mode:    Mode_MODE_FAST,
+		Nick:    "ann",
+		Tags:    []string{"a"},
+		Parent:  parent,
+		Limits:  map[string]int32{"a": 1},
+	}
+	if m.GetTheme() != "light" || m.GetRetries() != 3 || !m.GetEnabled() || m.GetMode() != Mode_MODE_FAST || m.GetNick() != "ann" {
+		t.Errorf("scalar getters don't return the values of the fields")
+	}
+	if len(m.GetTags()) != 1 || m.GetParent() != parent || m.GetLimits()["a"] != 1 {
+		t.Errorf("getters don't return the slices, messages and maps of the fields")
+	}
+}
+`
+
+func TestGoldenGetters(t *testing.T) {
+	limits := goldenMessage("LimitsEntry",
+		goldenField("key", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+		goldenField("value", 2, descriptor.FieldDescriptorProto_TYPE_INT32, ""),
+	)
+	limits.Options = &descriptor.MessageOptions{MapEntry: proto.Bool(true)}
+
+	nick := goldenField("nick", 5, descriptor.FieldDescriptorProto_TYPE_STRING, "")
+	nick.Proto3Optional = proto.Bool(true)
+	nick.OneofIndex = proto.Int32(0)
+
+	settings := goldenMessage("Settings",
+		goldenField("theme", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+		goldenField("retries", 2, descriptor.FieldDescriptorProto_TYPE_INT32, ""),
+		goldenField("enabled", 3, descriptor.FieldDescriptorProto_TYPE_BOOL, ""),
+		goldenField("mode", 4, descriptor.FieldDescriptorProto_TYPE_ENUM, ".user.Mode"),
+		nick,
+		goldenRepeated(goldenField("tags", 6, descriptor.FieldDescriptorProto_TYPE_STRING, "")),
+		goldenField("parent", 7, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".user.Settings"),
+		goldenRepeated(goldenField("limits", 8, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".user.Settings.LimitsEntry")),
+	)
+	settings.NestedType = []*descriptor.DescriptorProto{limits}
+	settings.OneofDecl = []*descriptor.OneofDescriptorProto{{Name: proto.String("_nick")}}
+
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{settings},
+		[]*descriptor.EnumDescriptorProto{goldenEnum("Mode", []string{"MODE_SLOW", "MODE_FAST"}, []int32{0, 1})},
+		nil)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "getters", resp)
+	compileGolden(t, resp, map[string]string{"router/user/getters_test.go": settingsGetterTest})
+}
+
+// contactSetterTest calls the setters of the Contact of TestGoldenSetters.
+const contactSetterTest = `package user
+
+import "testing"
+
+func TestContactSetters(t *testing.T) {
+	m := &Contact{}
+	parent := &Contact{}
+	m.SetName("ann")
+	m.SetParent(parent)
+	if m.Name != "ann" || m.Parent != parent {
+		t.Errorf("setters left %+v", m)
+	}
+
+	// The members of the oneof reset each other.
+	m.SetEmail("ann@example.com")
+	m.SetPhone("555")
+	if m.Email != "" || m.Phone != "555" {
+		t.Errorf("SetPhone left Email = %q, Phone = %q", m.Email, m.Phone)
+	}
+	m.SetEmail("ann@example.com")
+	if m.Email != "ann@example.com" || m.Phone != "" {
+		t.Errorf("SetEmail left Email = %q, Phone = %q", m.Email, m.Phone)
+	}
+}
+`
+
+func TestGoldenSetters(t *testing.T) {
+	contact := func() *descriptor.FileDescriptorProto {
+		email := goldenField("email", 3, descriptor.FieldDescriptorProto_TYPE_STRING, "")
+		email.OneofIndex = proto.Int32(0)
+		phone := goldenField("phone", 4, descriptor.FieldDescriptorProto_TYPE_STRING, "")
+		phone.OneofIndex = proto.Int32(0)
+
+		message := goldenMessage("Contact",
+			goldenField("name", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+			goldenField("parent", 2, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".user.Contact"),
+			email,
+			phone,
+		)
+		message.OneofDecl = []*descriptor.OneofDescriptorProto{{Name: proto.String("method")}}
+		return goldenFile("user/user.proto", []*descriptor.DescriptorProto{message}, nil, nil)
+	}
+
+	resp := generateGolden(t, "", contact())
+	if model := goldenContent(t, resp, "user/user.model.go"); strings.Contains(model, ") SetName(") {
+		t.Errorf("user.model.go has setters without gen_setters=true:\n%s", model)
+	}
+
+	resp = generateGolden(t, "gen_setters=true", contact())
+	checkGolden(t, "setters", resp)
+	compileGolden(t, resp, map[string]string{"router/user/setters_test.go": contactSetterTest})
+}
+
+// jsTypeTest encodes the Counter of TestGoldenJSType, its JS_STRING fields as strings.
+const jsTypeTest = `package user
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJSType(t *testing.T) {
+	in := Counter{Id: 9007199254740993, Count: 2, Total: 3, Ids: []int64{4}}
+	bts, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ` + "`" + `{"id":"9007199254740993","count":2,"total":3,"ids":[4]}` + "`" + `; string(bts) != want {
+		t.Errorf("json.Marshal = %s, want %s", bts, want)
+	}
+
+	var out Counter
+	if err := json.Unmarshal(bts, &out); err != nil || !reflect.DeepEqual(out, in) {
+		t.Errorf("json.Unmarshal = %+v, %v, want %+v", out, err, in)
+	}
+}
+`
+
+func TestGoldenJSType(t *testing.T) {
+	jsType := func(field *descriptor.FieldDescriptorProto, jstype descriptor.FieldOptions_JSType) *descriptor.FieldDescriptorProto {
+		field.Options = &descriptor.FieldOptions{Jstype: jstype.Enum()}
+		return field
+	}
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("Counter",
+				jsType(goldenField("id", 1, descriptor.FieldDescriptorProto_TYPE_INT64, ""), descriptor.FieldOptions_JS_STRING),
+				jsType(goldenField("count", 2, descriptor.FieldDescriptorProto_TYPE_UINT64, ""), descriptor.FieldOptions_JS_NUMBER),
+				goldenField("total", 3, descriptor.FieldDescriptorProto_TYPE_INT64, ""),
+				// encoding/json can't quote the elements of a slice.
+				jsType(goldenRepeated(goldenField("ids", 4, descriptor.FieldDescriptorProto_TYPE_INT64, "")), descriptor.FieldOptions_JS_STRING),
+			),
+		},
+		nil, nil)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "jstype", resp)
+	if model := goldenContent(t, resp, "user/user.model.go"); !strings.Contains(model, `json:"id,omitempty,string"`) {
+		t.Errorf("user.model.go has no string option on the id tag:\n%s", model)
+	}
+	compileGolden(t, resp, map[string]string{"router/user/jstype_test.go": jsTypeTest})
+}
+
+// wrappersFile returns wrappers/wrappers.proto, declaring the well-known wrapper messages
+// in the google.protobuf package, each holding its value field.
+func wrappersFile() *descriptor.FileDescriptorProto {
+	file := goldenFile("wrappers/wrappers.proto", nil, nil, nil)
+	file.Package = proto.String("google.protobuf")
+	for _, w := range []struct {
+		name string
+		typ  descriptor.FieldDescriptorProto_Type
+	}{
+		{"DoubleValue", descriptor.FieldDescriptorProto_TYPE_DOUBLE},
+		{"FloatValue", descriptor.FieldDescriptorProto_TYPE_FLOAT},
+		{"Int64Value", descriptor.FieldDescriptorProto_TYPE_INT64},
+		{"UInt64Value", descriptor.FieldDescriptorProto_TYPE_UINT64},
+		{"Int32Value", descriptor.FieldDescriptorProto_TYPE_INT32},
+		{"UInt32Value", descriptor.FieldDescriptorProto_TYPE_UINT32},
+		{"BoolValue", descriptor.FieldDescriptorProto_TYPE_BOOL},
+		{"StringValue", descriptor.FieldDescriptorProto_TYPE_STRING},
+		{"BytesValue", descriptor.FieldDescriptorProto_TYPE_BYTES},
+	} {
+		file.MessageType = append(file.MessageType, goldenMessage(w.name, goldenField("value", 1, w.typ, "")))
+	}
+	return file
+}
+
+// wrapperFieldsTest decodes the Nullable of TestGoldenWrapperFields, telling unset fields
+// from zero ones.
+const wrapperFieldsTest = `package user
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestWrapperFields(t *testing.T) {
+	var m Nullable
+	if err := json.Unmarshal([]byte(` + "`" + `{"name": "", "count": 0, "ok": false, "blob": "", "ratio": 1.5, "ids": [3]}` + "`" + `), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Name == nil || *m.Name != "" || m.Count == nil || *m.Count != 0 || m.Ok == nil || *m.Ok || m.Blob == nil || len(*m.Blob) != 0 {
+		t.Errorf("zero values decoded as %+v, want them set", m)
+	}
+	if m.Ratio == nil || *m.Ratio != 1.5 || len(m.Ids) != 1 || *m.Ids[0] != 3 {
+		t.Errorf("values decoded as %+v, want ratio 1.5 and ids [3]", m)
+	}
+	if m.Size != nil || m.Total != nil || m.Score != nil || m.Flags != nil {
+		t.Errorf("unset fields decoded as %+v, want them nil", m)
+	}
+
+	var unset Nullable
+	if err := json.Unmarshal([]byte("{}"), &unset); err != nil {
+		t.Fatal(err)
+	}
+	if unset.Blob != nil {
+		t.Errorf("unset blob decoded as %q, want it nil", *unset.Blob)
+	}
+}
+`
+
+func TestGoldenWrapperFields(t *testing.T) {
+	wrapper := func(name string, number int32, typ string) *descriptor.FieldDescriptorProto {
+		return goldenField(name, number, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf."+typ)
+	}
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("Nullable",
+				wrapper("ratio", 1, "DoubleValue"),
+				wrapper("score", 2, "FloatValue"),
+				wrapper("total", 3, "Int64Value"),
+				wrapper("size", 4, "UInt64Value"),
+				wrapper("count", 5, "Int32Value"),
+				wrapper("flags", 6, "UInt32Value"),
+				wrapper("ok", 7, "BoolValue"),
+				wrapper("name", 8, "StringValue"),
+				wrapper("blob", 9, "BytesValue"),
+				goldenRepeated(wrapper("ids", 10, "Int64Value")),
+			),
+		},
+		nil, nil)
+	file.Dependency = []string{"wrappers/wrappers.proto"}
+
+	resp := generateGolden(t, "", wrappersFile(), file)
+	checkGolden(t, "wrapper_fields", resp)
+
+	model := goldenContent(t, resp, "user/user.model.go")
+	for _, want := range []string{"*float64", "*float32", "*int64", "*uint64", "*int32", "*uint32", "*bool", "*string", "*[]byte", "[]*int64"} {
+		if !strings.Contains(model, " "+want+" ") {
+			t.Errorf("user.model.go has no %s field:\n%s", want, model)
+		}
+	}
+	if strings.Contains(model, "import") {
+		t.Errorf("user.model.go imports the package of the wrappers:\n%s", model)
+	}
+	compileGolden(t, resp, map[string]string{"router/user/wrapper_fields_test.go": wrapperFieldsTest})
+}
+
+// dynamicFile returns dynamic/dynamic.proto, declaring the well-known messages holding
+// arbitrary JSON in the google.protobuf package. Their fields do not matter to the generator.
+func dynamicFile() *descriptor.FileDescriptorProto {
+	file := goldenFile("dynamic/dynamic.proto", []*descriptor.DescriptorProto{
+		goldenMessage("Any", goldenField("type_url", 1, descriptor.FieldDescriptorProto_TYPE_STRING, "")),
+		goldenMessage("Value"),
+		goldenMessage("Struct"),
+		goldenMessage("ListValue", goldenRepeated(goldenField("values", 1, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Value"))),
+	}, nil, nil)
+	file.Package = proto.String("google.protobuf")
+	return file
+}
+
+// dynamicFieldsTest decodes the Document of TestGoldenDynamicFields into its singular and
+// repeated dynamic fields.
+const dynamicFieldsTest = `package user
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDynamicFields(t *testing.T) {
+	in := ` + "`" + `{"value":1,"attrs":{"a":true},"items":["x",2],"payload":"p",` +
+	`"values":[1,"y"],"docs":[{"a":1},{"b":2}],"lists":[[1],[]],"payloads":[null,{}]}` + "`" + `
+	var m Document
+	if err := json.Unmarshal([]byte(in), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Value != 1.0 || m.Attrs["a"] != true || len(m.Items) != 2 || m.Payload != "p" {
+		t.Errorf("singular fields decoded as %+v", m)
+	}
+	if len(m.Values) != 2 || m.Values[1] != "y" || len(m.Docs) != 2 || m.Docs[1]["b"] != 2.0 ||
+		len(m.Lists) != 2 || len(m.Lists[0]) != 1 || len(m.Payloads) != 2 || m.Payloads[0] != nil {
+		t.Errorf("repeated fields decoded as %+v", m)
+	}
+}
+`
+
+func TestGoldenDynamicFields(t *testing.T) {
+	dynamic := func(name string, number int32, typ string) *descriptor.FieldDescriptorProto {
+		return goldenField(name, number, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf."+typ)
+	}
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("Document",
+				dynamic("value", 1, "Value"),
+				dynamic("attrs", 2, "Struct"),
+				dynamic("items", 3, "ListValue"),
+				dynamic("payload", 4, "Any"),
+				goldenRepeated(dynamic("values", 5, "Value")),
+				goldenRepeated(dynamic("docs", 6, "Struct")),
+				goldenRepeated(dynamic("lists", 7, "ListValue")),
+				goldenRepeated(dynamic("payloads", 8, "Any")),
+			),
+		},
+		nil, nil)
+	file.Dependency = []string{"dynamic/dynamic.proto"}
+
+	resp := generateGolden(t, "", dynamicFile(), file)
+	checkGolden(t, "dynamic_fields", resp)
+
+	model := goldenContent(t, resp, "user/user.model.go")
+	for _, want := range []string{
+		"Value interface{}", "Attrs map[string]interface{}", "Items []interface{}", "Payload interface{}",
+		"Values []interface{}", "Docs []map[string]interface{}", "Lists [][]interface{}", "Payloads []interface{}",
+	} {
+		if !strings.Contains(strings.Join(strings.Fields(model), " "), want+" ") {
+			t.Errorf("user.model.go has no %s field:\n%s", want, model)
+		}
+	}
+	if strings.Contains(model, "import") {
+		t.Errorf("user.model.go imports the package of the dynamic values:\n%s", model)
+	}
+	compileGolden(t, resp, map[string]string{"router/user/dynamic_fields_test.go": dynamicFieldsTest})
+}
+
+// jsonNumbersTest round-trips the numbers of TestGoldenJSONNumbers without losing precision.
+const jsonNumbersTest = `package user
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestJSONNumbers(t *testing.T) {
+	in := ` + "`" + `{"id":9007199254740993,"price":0.1000000000000000055511151231257827,"ids":[1,2e3],"name":"a","ok":true}` + "`" + `
+	var m Amount
+	if err := json.Unmarshal([]byte(in), &m); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := json.Marshal(m); err != nil || string(out) != in {
+		t.Errorf("json.Marshal = %s, %v, want %s", out, err, in)
+	}
+
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+	w, resp := serve(t, g, "GET", "/v1/users/12345678901234567890", "")
+	if want := ` + "`" + `"userId":12345678901234567890` + "`" + `; resp.Code != 0 || !strings.Contains(w.Body.String(), want) {
+		t.Errorf("GET /v1/users/12345678901234567890 = %s, want %s", w.Body, want)
+	}
+	if _, resp := serve(t, g, "GET", "/v1/users/abc", ""); resp.Code == 0 {
+		t.Errorf("GET /v1/users/abc = %v, want an error", resp)
+	}
+}
+`
+
+func TestGoldenJSONNumbers(t *testing.T) {
+	file := goldenUserFile()
+	file.MessageType = append(file.MessageType, goldenMessage("Amount",
+		goldenField("id", 1, descriptor.FieldDescriptorProto_TYPE_INT64, ""),
+		goldenField("price", 2, descriptor.FieldDescriptorProto_TYPE_DOUBLE, ""),
+		goldenRepeated(goldenField("ids", 3, descriptor.FieldDescriptorProto_TYPE_INT64, "")),
+		goldenField("name", 4, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+		goldenField("ok", 5, descriptor.FieldDescriptorProto_TYPE_BOOL, ""),
+	))
+
+	resp := generateGolden(t, "numbers=json.Number", file)
+	checkGolden(t, "json_numbers", resp)
+
+	model := goldenContent(t, resp, "user/user.model.go")
+	for _, want := range []string{"\tId    json.Number ", "\tPrice json.Number ", "\tIds   []json.Number ", "\tName  string ", "\tOk    bool "} {
+		if !strings.Contains(model, want) {
+			t.Errorf("user.model.go has no field %q:\n%s", want, model)
+		}
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":        serveTest,
+		"router/user/handler_test.go":      userHandlerTest("*gin.Context"),
+		"router/user/json_numbers_test.go": jsonNumbersTest,
+	})
+}
@@ -0,0 +1,90 @@
+package generator
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// generateFuzzFile prints, for every message of the file, a FuzzMsg helper decoding
+// arbitrary bytes as JSON into the message and a FuzzMsgSeed corpus entry for go test -fuzz.
+func (g *Generator) generateFuzzFile(file *FileDescriptor) {
+	g.file = file
+	g.outputImportPath = GoImportPath(file.GetName())
+	g.usedPackages = make(map[GoImportPath]bool)
+	g.packageNames = make(map[GoImportPath]GoPackageName)
+	g.usedPackageNames = make(map[GoPackageName]bool)
+	g.addedImports = make(map[GoImportPath]bool)
+	g.externalImports = make(map[GoImportPath]GoPackageName)
+	for name := range globalPackageNames {
+		g.usedPackageNames[name] = true
+	}
+
+	g.addExternalImport("encoding/json", "")
+
+	for _, desc := range file.desc {
+		if desc.GetOptions().GetMapEntry() {
+			continue
+		}
+
+		goName := CamelCaseSlice(desc.TypeName())
+
+		g.P("// Fuzz", goName, "Seed is a seed corpus entry for Fuzz", goName, ".")
+		g.P("const Fuzz", goName, "Seed = `", g.fuzzSeed(desc), "`")
+		g.P()
+		g.P("// Fuzz", goName, " decodes data as JSON into a ", goName, ", returning nil if it does not decode.")
+		g.P("func Fuzz", goName, "(data []byte) *", goName, " {")
+		g.P("m := &", goName, "{}")
+		g.P("if err := json.Unmarshal(data, m); err != nil {")
+		g.P("return nil")
+		g.P("}")
+		g.P()
+		g.P("return m")
+		g.P("}")
+		g.P()
+	}
+
+	rem := g.Buffer
+	g.Buffer = new(bytes.Buffer)
+	g.generateHeader()
+	g.generateImports("fuzz", false)
+	g.Write(rem.Bytes())
+
+	g.reformat()
+}
+
+// fuzzSeed returns a JSON object holding the zero value of every field of the message.
+// Nested messages are left empty so that recursive types terminate.
+func (g *Generator) fuzzSeed(desc *Descriptor) string {
+	values := make([]string, 0, len(desc.Field))
+	for _, field := range desc.Field {
+		name := g.jsonName(field)
+
+		value := ""
+		switch {
+		case isRepeated(field):
+			if _, ok := g.mapEntry(field); ok {
+				value = "{}"
+				break
+			}
+			value = "[]"
+		default:
+			switch field.GetType() {
+			case descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_GROUP:
+				value = "{}"
+			case descriptor.FieldDescriptorProto_TYPE_STRING, descriptor.FieldDescriptorProto_TYPE_BYTES:
+				value = `""`
+			case descriptor.FieldDescriptorProto_TYPE_BOOL:
+				value = "false"
+			default:
+				value = "0"
+			}
+		}
+
+		values = append(values, strconv.Quote(name)+":"+value)
+	}
+
+	return "{" + strings.Join(values, ",") + "}"
+}
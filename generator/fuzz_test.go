@@ -0,0 +1,55 @@
+package generator
+
+import "testing"
+
+// fuzzTest round-trips User through FuzzUser, seeded with FuzzUserSeed.
+const fuzzTest = `package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func FuzzUserRoundTrip(f *testing.F) {
+	f.Add([]byte(FuzzUserSeed))
+	f.Add([]byte(` + "`" + `{"userId": 1, "userName": "ann", "tags": ["admin"]}` + "`" + `))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := FuzzUser(data)
+		if m == nil {
+			return
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Empty slices decode from the input but are omitted from b.
+		got, err := json.Marshal(FuzzUser(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, b) {
+			t.Errorf("round trip of %s = %s", b, got)
+		}
+	})
+}
+
+func TestFuzzUser(t *testing.T) {
+	if FuzzUser([]byte(FuzzUserSeed)) == nil {
+		t.Errorf("FuzzUserSeed %s doesn't decode", FuzzUserSeed)
+	}
+	if m := FuzzUser([]byte(` + "`" + `{"userId": 1, "userName": "ann"}` + "`" + `)); m == nil || m.UserId != 1 || m.UserName != "ann" {
+		t.Errorf("FuzzUser = %+v, want the decoded user", m)
+	}
+	if m := FuzzUser([]byte("{")); m != nil {
+		t.Errorf("FuzzUser of malformed JSON = %+v, want nil", m)
+	}
+}
+`
+
+func TestGoldenFuzzHelpers(t *testing.T) {
+	resp := generateGolden(t, "fuzz_helpers=true", goldenUserFile())
+	checkGolden(t, "fuzz_helpers", resp)
+	goldenContent(t, resp, "user/user.fuzz.go")
+	compileGolden(t, resp, map[string]string{"router/user/fuzz_test.go": fuzzTest})
+}
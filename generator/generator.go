@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
@@ -14,8 +15,11 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
@@ -25,6 +29,58 @@ import (
 
 var regAnnotation = regexp.MustCompile(`\s?\@tag\s+(.+)`)
 
+// regWildcard matches the {name=**} path segments capturing the rest of the path.
+var regWildcard = regexp.MustCompile(`\{([^}=]+)=\*\*\}`)
+
+// parseAnnotations returns the key:value pairs of the @tag line in a comment.
+// Keys without a value map to the empty string. A value runs up to the next space and
+// may contain colons and commas, as in validate:required,email; values with spaces are
+// double-quoted, as in summary:"Get user by id".
+func parseAnnotations(comment string) map[string]string {
+	customAnnotations := map[string]string{}
+	if res := regAnnotation.FindStringSubmatch(comment); len(res) > 1 {
+		for _, h := range splitAnnotations(res[1]) {
+			key, val := h, ""
+			if i := strings.Index(h, ":"); i >= 0 {
+				key, val = h[:i], h[i+1:]
+			}
+			if uq, err := strconv.Unquote(val); err == nil && strings.HasPrefix(val, `"`) {
+				val = uq
+			}
+
+			customAnnotations[key] = val
+		}
+	}
+	return customAnnotations
+}
+
+// splitAnnotations splits the body of an @tag line on the spaces outside double quotes.
+func splitAnnotations(s string) []string {
+	var parts []string
+	start, quoted := -1, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == ' ' && !quoted:
+			if start >= 0 {
+				parts = append(parts, s[start:i])
+			}
+			start = -1
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		parts = append(parts, s[start:])
+	}
+	return parts
+}
+
 // A GoImportPath is the import path of a Go package. e.g., "google.golang.org/genproto/protobuf".
 type GoImportPath string
 
@@ -64,10 +120,125 @@ type Generator struct {
 	usedPackageNames map[GoPackageName]bool         // Package names used in the current file.
 	addedImports     map[GoImportPath]bool          // Additional imports to emit.
 	typeNameToObject map[string]Object              // Key is a fully-qualified name in input syntax.
-	init             []string                       // Lines to emit in the init function.
 	indent           string
+	returnErrors     bool     // Whether Error and Fail panic with a runError recovered by Run rather than exit.
 	pathType         pathType // How to generate output filenames.
+	groupByPackage   bool     // Whether output files are grouped by proto package, overriding paths.
+	flat             bool     // Whether output files are written without directory, overriding paths.
+	apiSuffix        string   // Suffix of the api file names, ".api.go" unless set by file_suffix.
 	writeOutput      bool
+
+	externalImports map[GoImportPath]GoPackageName // Non-proto packages imported by the current file, with their alias.
+	handlers        map[string]string              // handler.json entries written in this run.
+
+	authContext bool // Whether handlers receive the authenticated user from router.User.
+	errorHelper bool // Whether handlers render errors through the handleError helper instead of router.Error.
+	stdCtx      bool // Whether handlers take a context.Context instead of the gin context.
+	ifaceFile   bool // Whether handler interfaces are written to a separate, gin-free file.
+	adapter     bool // Whether a router-agnostic XxxAdapter is generated per service.
+	inAdapter   bool // Whether the code being printed is an adapter method, whose ctx is a router.HTTPContext.
+
+	inAccessLog bool // Whether the code being printed runs within router.AccessLog, returning its errors.
+
+	markerInterfaces bool // Whether method inputs and outputs implement router.RequestMessage and router.ResponseMessage.
+
+	isZero bool // Whether an IsZero method is generated per message.
+
+	decompressRequest bool // Whether handlers unwrap gzip encoded request bodies.
+
+	bufferedBind bool // Whether request bodies are read into a router.BufferPool buffer and bound from it.
+
+	validateAggregate bool // Whether messages with validate tags get a Validate method reporting every failing field.
+
+	errorKey string // Key of the error text in the entries of structured field errors.
+
+	otel bool // Whether handlers open a span through router.OTelSpan.
+
+	versionHeader string // Header checked against the version:<n> method annotation.
+
+	defaultsFrom string // Message whose [default = ...] values are applied to bound requests.
+
+	mapValues bool // Whether message-valued maps hold values rather than pointers.
+
+	testable bool // Whether CallXxxMethod wrappers running handlers without gin are generated.
+
+	serverBuilder bool // Whether NewXxxServer is generated per service.
+
+	accessLog bool // Whether handlers log an access line labelled Service.Method.
+
+	metrics bool // Whether handlers record their duration and status class through router.Metrics.
+
+	pool bool // Whether request and response messages get sync.Pool backed Get/Put functions.
+
+	enumParse bool // Whether a ParseXxx function is generated per enum.
+
+	lazyEnumMaps bool // Whether the enum name/value maps are built on first use behind XxxName/XxxValue.
+
+	formKey string // Source of form tag names, "proto" or "json".
+
+	strictJSON bool // Whether JSON bodies are bound through router.StrictJSON, rejecting unknown fields.
+
+	jsonCase string // Case of json tag names, "camel", "snake" or "original".
+
+	jsonNumbers bool // Whether numeric scalar fields are json.Number, keeping inbound numbers verbatim.
+
+	middlewareFuncs bool   // Whether middlewares are referenced as functions of middlewarePkg rather than by registered name.
+	middlewarePkg   string // Import path of the package declaring the middleware functions.
+
+	sharedChains bool              // Whether routes sharing a middleware set reuse one router.Chain.
+	chains       map[string]string // Chain variable per middleware set of the current service.
+
+	commentWrap int // Column at which leading proto comments are wrapped, 0 for no wrapping.
+
+	blockComments bool // Whether leading proto comments are emitted as /* */ blocks rather than // lines.
+
+	fuzzHelpers bool // Whether a <name>.fuzz.go file with JSON fuzz helpers is generated.
+
+	genOpenAPI bool // Whether a <name>.openapi.json OpenAPI 3.0 document is generated.
+
+	dryRun bool // Whether only the manifest of the output paths is returned, handler.json left alone.
+
+	inventory bool // Whether rain_manifest.json, listing the outputs with their symbols, is generated.
+
+	contentTypeCheck bool // Whether bound requests are rejected with 415 on an unexpected Content-Type.
+
+	fieldNumberTag bool // Whether fields get an order:"<n>" tag with their proto field number.
+
+	fieldNumbers bool // Whether a Go field name to proto field number map is generated per message.
+
+	fieldMeta bool // Whether a Go field name to router.FieldMeta map is generated per message.
+
+	bodyBuilder bool // Whether New/With builders are generated for POST request bodies.
+
+	checkedConstructors bool // Whether validated messages get a NewXxx constructor running Validate.
+
+	packageBasePath bool // Whether routes are mounted under a base path derived from the proto package.
+
+	routeContext bool // Whether handlers store the matched route template on the gin context.
+
+	pruneStaleHandlers bool // Whether handler.json entries of services no longer generated are removed.
+
+	stripFieldPrefix bool // Whether the message name prefix is stripped from field names.
+
+	fieldErrors bool // Whether bind failures report validation errors per field.
+
+	deprecationLog bool // Whether deprecated methods log once when they are first called.
+
+	receiver string // How receivers of generated methods are named: "m", "short" or "type".
+
+	genSetters bool // Whether a SetXxx method is generated per message field.
+
+	registerAll bool // Whether RegisterAll registers every service of a file at once.
+
+	routeFilter bool // Whether XxxRoute and RegisterXxxHandlerFiltered are generated per service.
+
+	importReference bool // Whether publicly imported types are referenced from their package rather than aliased.
+
+	pbConvert     bool   // Whether to generate conversions to and from the protoc-gen-go structs.
+	pbEnumConvert bool   // Whether to generate conversions to and from the protoc-gen-go enum types.
+	pbImport      string // Import path of the protoc-gen-go package.
+
+	forcedExports map[string]bool // Names of types and fields forced exported, warned about once.
 }
 
 type pathType int
@@ -75,6 +246,8 @@ type pathType int
 const (
 	pathTypeImport pathType = iota
 	pathTypeSourceRelative
+	pathTypePackage // Under the directory of the proto package, e.g. a/b/c for a.b.c.
+	pathTypeFlat    // At the root of the output directory.
 )
 
 // New creates a new generator and allocates the request and response protobufs.
@@ -83,12 +256,16 @@ func New() *Generator {
 	g.Buffer = new(bytes.Buffer)
 	g.Request = new(plugin.CodeGeneratorRequest)
 	g.Response = new(plugin.CodeGeneratorResponse)
+	g.forcedExports = make(map[string]bool)
 	return g
 }
 
 // Error reports a problem, including an error, and exits the program.
 func (g *Generator) Error(err error, msgs ...string) {
 	s := strings.Join(msgs, " ") + ":" + err.Error()
+	if g.returnErrors {
+		panic(runError{errors.New(s)})
+	}
 	log.Print("protoc-gen-rain: error:", s)
 	os.Exit(1)
 }
@@ -96,10 +273,48 @@ func (g *Generator) Error(err error, msgs ...string) {
 // Fail reports a problem and exits the program.
 func (g *Generator) Fail(msgs ...string) {
 	s := strings.Join(msgs, " ")
+	if g.returnErrors {
+		panic(runError{errors.New(s)})
+	}
 	log.Print("protoc-gen-rain: error:", s)
 	os.Exit(1)
 }
 
+// runError carries the problem reported by Error or Fail up to Run.
+type runError struct {
+	err error
+}
+
+// Run generates the files of req in-process and returns the response protoc would read
+// from the plugin. The problems ending the plugin are returned as errors instead.
+func Run(req *plugin.CodeGeneratorRequest) (resp *plugin.CodeGeneratorResponse, err error) {
+	g := New()
+	g.Request = req
+	g.returnErrors = true
+
+	defer func() {
+		if r := recover(); r != nil {
+			re, ok := r.(runError)
+			if !ok {
+				panic(r)
+			}
+			resp, err = nil, re.err
+		}
+	}()
+
+	if len(g.Request.FileToGenerate) == 0 {
+		g.Fail("no files to generate")
+	}
+
+	g.CommandLineParameters(g.Request.GetParameter())
+	g.WrapTypes()
+	g.SetPackageNames()
+	g.BuildTypeNameMap()
+	g.GenerateAllFiles()
+
+	return g.Response, nil
+}
+
 // CommandLineParameters breaks the comma-separated list of key=value pairs
 // in the parameter (a member of the request protobuf) into a key/value map.
 // It then sets file name mappings defined by those entries.
@@ -114,6 +329,8 @@ func (g *Generator) CommandLineParameters(parameter string) {
 	}
 
 	g.ImportMap = make(map[string]string)
+	g.errorKey = "message"
+	g.versionHeader = "Accept-Version"
 	for k, v := range g.Param {
 		switch k {
 		case "import_prefix":
@@ -129,6 +346,176 @@ func (g *Generator) CommandLineParameters(parameter string) {
 			default:
 				g.Fail(fmt.Sprintf(`Unknown path type %q: want "import" or "source_relative".`, v))
 			}
+		case "group_by":
+			switch v {
+			case "file", "package":
+				g.groupByPackage = v == "package"
+			default:
+				g.Fail(fmt.Sprintf(`Unknown group_by %q: want "file" or "package".`, v))
+			}
+		case "flat":
+			g.flat = v == "true"
+		case "file_suffix":
+			g.apiSuffix = v
+		case "stdctx":
+			g.stdCtx = v == "true"
+		case "iface_file":
+			g.ifaceFile = v == "true"
+		case "adapter":
+			g.adapter = v == "true"
+		case "error_helper":
+			g.errorHelper = v == "true"
+		case "auth_context":
+			g.authContext = v == "true"
+		case "marker_interfaces":
+			g.markerInterfaces = v == "true"
+		case "iszero":
+			g.isZero = v == "true"
+		case "buffered_bind":
+			g.bufferedBind = v == "true"
+		case "validate_aggregate":
+			g.validateAggregate = v == "true"
+		case "decompress_request":
+			g.decompressRequest = v == "true"
+		case "error_key":
+			if v == "" || v == "field" {
+				g.Fail(fmt.Sprintf("Invalid error_key %q.", v))
+			}
+			g.errorKey = v
+		case "otel":
+			g.otel = v == "true"
+		case "version_header":
+			g.versionHeader = v
+		case "defaults_from":
+			g.defaultsFrom = v
+		case "map_values":
+			switch v {
+			case "pointer", "value":
+				g.mapValues = v == "value"
+			default:
+				g.Fail(fmt.Sprintf(`Unknown map_values %q: want "pointer" or "value".`, v))
+			}
+		case "testable":
+			g.testable = v == "true"
+		case "server_builder":
+			g.serverBuilder = v == "true"
+		case "access_log":
+			g.accessLog = v == "true"
+		case "metrics":
+			g.metrics = v == "true"
+		case "pool":
+			g.pool = v == "true"
+		case "enum_parse":
+			g.enumParse = v == "true"
+		case "lazy_enum_maps":
+			g.lazyEnumMaps = v == "true"
+		case "form_key":
+			switch v {
+			case "proto", "json":
+				g.formKey = v
+			default:
+				g.Fail(fmt.Sprintf(`Unknown form_key %q: want "proto" or "json".`, v))
+			}
+		case "strict_json":
+			g.strictJSON = v == "true"
+		case "json_case":
+			switch v {
+			case "camel", "snake", "original":
+				g.jsonCase = v
+			default:
+				g.Fail(fmt.Sprintf(`Unknown json_case %q: want "camel", "snake" or "original".`, v))
+			}
+		case "numbers":
+			switch v {
+			case "native", "json.Number":
+				g.jsonNumbers = v == "json.Number"
+			default:
+				g.Fail(fmt.Sprintf(`Unknown numbers %q: want "native" or "json.Number".`, v))
+			}
+		case "middleware_mode":
+			switch v {
+			case "name", "func":
+				g.middlewareFuncs = v == "func"
+			default:
+				g.Fail(fmt.Sprintf(`Unknown middleware_mode %q: want "name" or "func".`, v))
+			}
+		case "middleware_pkg":
+			g.middlewarePkg = v
+		case "shared_chains":
+			g.sharedChains = v == "true"
+		case "comment_wrap":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				g.Fail(fmt.Sprintf("Invalid comment_wrap %q: want a column number.", v))
+			}
+			g.commentWrap = n
+		case "comment_style":
+			switch v {
+			case "line":
+				g.blockComments = false
+			case "block":
+				g.blockComments = true
+			default:
+				g.Fail(fmt.Sprintf(`Unknown comment_style %q: want "line" or "block".`, v))
+			}
+		case "fuzz_helpers":
+			g.fuzzHelpers = v == "true"
+		case "gen_openapi":
+			g.genOpenAPI = v == "true"
+		case "dry_run":
+			g.dryRun = v == "true"
+		case "manifest":
+			g.inventory = v == "true"
+		case "content_type_check":
+			g.contentTypeCheck = v == "true"
+		case "field_number_tag":
+			g.fieldNumberTag = v == "true"
+		case "field_numbers":
+			g.fieldNumbers = v == "true"
+		case "field_meta":
+			g.fieldMeta = v == "true"
+		case "body_builder":
+			g.bodyBuilder = v == "true"
+		case "checked_constructors":
+			g.checkedConstructors = v == "true"
+		case "package_base_path":
+			g.packageBasePath = v == "true"
+		case "route_context":
+			g.routeContext = v == "true"
+		case "prune_handlers":
+			g.pruneStaleHandlers = v == "true"
+		case "strip_field_prefix":
+			g.stripFieldPrefix = v == "true"
+		case "field_errors":
+			g.fieldErrors = v == "true"
+		case "deprecation_log":
+			g.deprecationLog = v == "true"
+		case "receiver":
+			switch v {
+			case "m", "short", "type":
+				g.receiver = v
+			default:
+				g.Fail(fmt.Sprintf(`Unknown receiver %q: want "m", "short" or "type".`, v))
+			}
+		case "import_mode":
+			switch v {
+			case "alias", "reference":
+				g.importReference = v == "reference"
+			default:
+				g.Fail(fmt.Sprintf(`Unknown import_mode %q: want "alias" or "reference".`, v))
+			}
+		case "route_filter":
+			g.routeFilter = v == "true"
+		case "gen_register_all":
+			g.registerAll = v == "true"
+		case "gen_setters":
+			g.genSetters = v == "true"
+		case "pb_convert":
+			g.pbConvert = v == "true"
+		case "pb_enum_convert":
+			g.pbEnumConvert = v == "true"
+		case "pb_import":
+			g.pbImport = v
 		default:
 			if len(k) > 0 && k[0] == 'M' {
 				g.ImportMap[k[1:]] = v
@@ -139,6 +526,114 @@ func (g *Generator) CommandLineParameters(parameter string) {
 	if g.ImportPrefix == "" {
 		g.ImportPrefix = g.Param["repo"] + "/"
 	}
+
+	if g.groupByPackage && g.flat {
+		g.Fail("group_by=package and flat=true both set the output directory, enable only one")
+	}
+
+	if g.groupByPackage {
+		g.pathType = pathTypePackage
+	}
+
+	if g.flat {
+		g.pathType = pathTypeFlat
+	}
+
+	switch {
+	case g.apiSuffix == "":
+		g.apiSuffix = ".api.go"
+	case !strings.HasSuffix(g.apiSuffix, ".go") || !strings.HasPrefix(g.apiSuffix, "."):
+		g.Fail(fmt.Sprintf(`Invalid file_suffix %q: want a suffix like ".gen.go".`, g.apiSuffix))
+	case g.apiSuffix == ".model.go" || g.apiSuffix == ".fuzz.go" || g.apiSuffix == ".iface.go":
+		g.Fail("file_suffix", g.apiSuffix, "is the suffix of another generated file")
+	}
+
+	if g.ifaceFile && !g.stdCtx {
+		g.Fail("iface_file requires stdctx=true, handlers taking a gin context can't be declared without gin")
+	}
+
+	if g.testable && !g.stdCtx {
+		g.Fail("testable requires stdctx=true, CallXxx wrappers have no gin context to pass to the handlers")
+	}
+
+	if g.adapter && !g.stdCtx {
+		g.Fail("adapter requires stdctx=true, adapters call handlers with the context.Context of the request")
+	}
+
+	if g.adapter {
+		switch {
+		case g.decompressRequest:
+			g.Fail("adapter and decompress_request can't be combined, adapters read requests through router.HTTPContext only")
+		case g.contentTypeCheck:
+			g.Fail("adapter and content_type_check can't be combined, adapters read requests through router.HTTPContext only")
+		case g.bufferedBind:
+			g.Fail("adapter and buffered_bind can't be combined, adapters read requests through router.HTTPContext only")
+		case g.strictJSON:
+			g.Fail("adapter and strict_json can't be combined, adapters read requests through router.HTTPContext only")
+		case g.fieldErrors:
+			g.Fail("adapter and field_errors can't be combined, router.HTTPContext renders errors with their message only")
+		case g.accessLog:
+			g.Fail("adapter and access_log can't be combined, adapters don't instrument their routes")
+		case g.metrics:
+			g.Fail("adapter and metrics can't be combined, adapters don't instrument their routes")
+		case g.otel:
+			g.Fail("adapter and otel can't be combined, adapters don't instrument their routes")
+		case g.routeContext:
+			g.Fail("adapter and route_context can't be combined, adapters don't instrument their routes")
+		case g.deprecationLog:
+			g.Fail("adapter and deprecation_log can't be combined, adapters don't instrument their routes")
+		}
+	}
+
+	if g.checkedConstructors && !g.validateAggregate {
+		g.Fail("checked_constructors requires validate_aggregate=true, NewXxx runs the generated Validate method")
+	}
+
+	if g.checkedConstructors && g.bodyBuilder {
+		g.Fail("checked_constructors and body_builder both generate NewXxx, enable only one")
+	}
+
+	if g.middlewareFuncs && g.middlewarePkg == "" {
+		g.Fail("middleware_mode=func requires middleware_pkg to be set")
+	}
+
+	if g.pbConvert && g.jsonNumbers {
+		g.Fail("pb_convert can't be used with numbers=json.Number, json.Number fields have no protoc-gen-go counterpart")
+	}
+
+	if g.pbConvert && g.pbImport == "" {
+		g.Fail("pb_convert requires pb_import to be set")
+	}
+
+	if g.pbEnumConvert && g.pbImport == "" {
+		g.Fail("pb_enum_convert requires pb_import to be set")
+	}
+}
+
+// reservedReceiverNames are the identifiers generated methods use for their own
+// variables and packages; a receiver name must not shadow them.
+var reservedReceiverNames = map[string]bool{
+	"in":  true,
+	"k":   true,
+	"out": true,
+	"pb":  true,
+	"v":   true,
+}
+
+// receiverName returns the receiver name of methods generated for the named type,
+// as configured by the receiver parameter. The default is "m".
+func (g *Generator) receiverName(goName string) string {
+	name := "m"
+	switch g.receiver {
+	case "short":
+		name = strings.ToLower(goName[:1])
+	case "type":
+		name = strings.ToLower(goName[:1]) + goName[1:]
+	}
+	if reservedReceiverNames[name] || isGoKeyword[name] || isGoPredeclaredIdentifier[name] {
+		name += "_"
+	}
+	return name
 }
 
 // DefaultPackageName returns the package name printed for the object.
@@ -179,6 +674,42 @@ func (g *Generator) AddImport(importPath GoImportPath) GoPackageName {
 	return g.GoPackageName(importPath)
 }
 
+// addExternalImport adds a package that is not generated from a .proto file,
+// such as a standard library package, to the current file's import section.
+// An empty alias imports the package under its own name.
+func (g *Generator) addExternalImport(importPath GoImportPath, alias GoPackageName) {
+	g.externalImports[importPath] = alias
+}
+
+// printExternalImports prints the imports recorded by addExternalImport, sorted by path.
+// Standard library packages and third-party packages are printed separately so that
+// they end up in their own import groups.
+func (g *Generator) printExternalImports(std bool) {
+	paths := make([]string, 0, len(g.externalImports))
+	for importPath := range g.externalImports {
+		if isStdImport(importPath) == std {
+			paths = append(paths, string(importPath))
+		}
+	}
+	sort.Strings(paths)
+
+	for _, importPath := range paths {
+		if alias := g.externalImports[GoImportPath(importPath)]; alias != "" {
+			g.P(alias, " ", GoImportPath(importPath))
+		} else {
+			g.P(GoImportPath(importPath))
+		}
+	}
+	if std && len(paths) > 0 {
+		g.P()
+	}
+}
+
+// isStdImport reports whether the import path belongs to the standard library.
+func isStdImport(importPath GoImportPath) bool {
+	return !strings.Contains(strings.SplitN(string(importPath), "/", 2)[0], ".")
+}
+
 var globalPackageNames = map[GoPackageName]bool{}
 
 var isGoPredeclaredIdentifier = map[string]bool{
@@ -233,13 +764,10 @@ func (g *Generator) defaultGoPackage() GoPackageName {
 	return cleanPackageName(p)
 }
 
-// SetPackageNames sets the package name for this run.
-// The package name must agree across all files being generated.
+// SetPackageNames sets the package name of every file being generated.
+// The package name must agree across the files sharing an import path.
 // It also defines unique package names for all imported files.
 func (g *Generator) SetPackageNames() {
-	g.outputImportPath = g.genFiles[0].importPath
-	g.outputImportPath = GoImportPath(g.genFiles[0].GetName())
-
 	defaultPackageNames := make(map[GoImportPath]GoPackageName)
 	for _, f := range g.genFiles {
 		if _, p, ok := f.goPackageOption(); ok {
@@ -272,13 +800,17 @@ func (g *Generator) SetPackageNames() {
 		}
 	}
 
-	// Check that all files have a consistent package name and import path.
-	for _, f := range g.genFiles[1:] {
-		if a, b := g.genFiles[0].importPath, f.importPath; a != b {
-			g.Fail(fmt.Sprintf("inconsistent package import paths: %v, %v", a, b))
+	// Every file is generated on its own, so the files may span several packages.
+	// Check that the files sharing an import path have a consistent package name.
+	packageFiles := make(map[GoImportPath]*FileDescriptor)
+	for _, f := range g.genFiles {
+		first, ok := packageFiles[f.importPath]
+		if !ok {
+			packageFiles[f.importPath] = f
+			continue
 		}
-		if a, b := g.genFiles[0].packageName, f.packageName; a != b {
-			g.Fail(fmt.Sprintf("inconsistent package names: %v, %v", a, b))
+		if a, b := first.packageName, f.packageName; a != b {
+			g.Fail(fmt.Sprintf("inconsistent package names for %v: %v, %v", f.importPath, a, b))
 		}
 	}
 
@@ -471,12 +1003,6 @@ func (g *Generator) P(str ...interface{}) {
 	g.WriteByte('\n')
 }
 
-// addInitf stores the given statement to be printed inside the file's init function.
-// The statement is given as a format specifier and arguments.
-func (g *Generator) addInitf(stmt string, a ...interface{}) {
-	g.init = append(g.init, fmt.Sprintf(stmt, a...))
-}
-
 // In Indents the output one tab stop.
 func (g *Generator) In() { g.indent += "\t" }
 
@@ -497,6 +1023,8 @@ func (g *Generator) GenerateAllFiles() {
 		genFileMap[file] = true
 	}
 
+	g.handlers = make(map[string]string)
+
 	for _, file := range g.allFiles {
 		// model file
 		g.Reset()
@@ -505,7 +1033,7 @@ func (g *Generator) GenerateAllFiles() {
 		if !g.writeOutput {
 			continue
 		}
-		fname := file.goFileName(g.pathType, "model")
+		fname := file.goFileName(g.pathType, ".model.go")
 		g.Response.File = append(g.Response.File, &plugin.CodeGeneratorResponse_File{
 			Name:    proto.String(fname),
 			Content: proto.String(g.String()),
@@ -518,32 +1046,95 @@ func (g *Generator) GenerateAllFiles() {
 		if !g.writeOutput {
 			continue
 		}
-		fname = file.goFileName(g.pathType, "api")
+		fname = file.goFileName(g.pathType, g.apiSuffix)
+		g.Response.File = append(g.Response.File, &plugin.CodeGeneratorResponse_File{
+			Name:    proto.String(fname),
+			Content: proto.String(g.String()),
+		})
+
+		// fuzz file
+		if g.fuzzHelpers {
+			g.Reset()
+			g.generateFuzzFile(file)
+			fname = file.goFileName(g.pathType, ".fuzz.go")
+			g.Response.File = append(g.Response.File, &plugin.CodeGeneratorResponse_File{
+				Name:    proto.String(fname),
+				Content: proto.String(g.String()),
+			})
+		}
+
+		// openapi file
+		if g.genOpenAPI && len(file.FileDescriptorProto.Service) > 0 {
+			g.Reset()
+			g.generateOpenAPIFile(file)
+			fname = file.goFileName(g.pathType, ".openapi.json")
+			g.Response.File = append(g.Response.File, &plugin.CodeGeneratorResponse_File{
+				Name:    proto.String(fname),
+				Content: proto.String(g.String()),
+			})
+		}
+
+		// iface file
+		if !g.ifaceFile || len(file.FileDescriptorProto.Service) == 0 {
+			continue
+		}
+		g.Reset()
+		g.generateIfaceFile(file)
+		fname = file.goFileName(g.pathType, ".iface.go")
 		g.Response.File = append(g.Response.File, &plugin.CodeGeneratorResponse_File{
 			Name:    proto.String(fname),
 			Content: proto.String(g.String()),
 		})
 	}
+
+	if g.inventory {
+		g.generateInventory()
+	}
+
+	if g.dryRun {
+		g.generateManifest()
+		return
+	}
+
+	if g.pruneStaleHandlers {
+		g.pruneHandlers()
+	}
+}
+
+// manifestName is the file returned instead of the outputs with dry_run=true.
+const manifestName = "protoc-gen-rain.manifest"
+
+// generateManifest replaces the generated files of the response by the manifest listing
+// their paths, one per line, for build systems declaring the outputs ahead of the run.
+func (g *Generator) generateManifest() {
+	var b strings.Builder
+	for _, f := range g.Response.File {
+		b.WriteString(f.GetName())
+		b.WriteString("\n")
+	}
+
+	g.Response.File = []*plugin.CodeGeneratorResponse_File{{
+		Name:    proto.String(manifestName),
+		Content: proto.String(b.String()),
+	}}
 }
 
 // Fill the response protocol buffer with the generated output for all the files we're
 // supposed to generate.
 func (g *Generator) generateApiFile(file *FileDescriptor) {
 	g.file = file
+	g.outputImportPath = GoImportPath(file.GetName())
 	g.usedPackages = make(map[GoImportPath]bool)
 	g.packageNames = make(map[GoImportPath]GoPackageName)
 	g.usedPackageNames = make(map[GoPackageName]bool)
 	g.addedImports = make(map[GoImportPath]bool)
+	g.externalImports = make(map[GoImportPath]GoPackageName)
 	for name := range globalPackageNames {
 		g.usedPackageNames[name] = true
 	}
 
 	g.P()
 
-	for _, td := range g.file.imp {
-		g.generateImported(td)
-	}
-
 	hasBinding := false
 	if len(file.FileDescriptorProto.Service) > 0 {
 		for i, service := range file.FileDescriptorProto.Service {
@@ -554,6 +1145,14 @@ func (g *Generator) generateApiFile(file *FileDescriptor) {
 		}
 	}
 
+	if g.registerAll && len(file.FileDescriptorProto.Service) > 0 {
+		g.generateRegisterAll(file)
+	}
+
+	if g.errorHelper && len(file.FileDescriptorProto.Service) > 0 {
+		g.generateErrorHelper(file)
+	}
+
 	rem := g.Buffer
 	g.Buffer = new(bytes.Buffer)
 	g.generateHeader()
@@ -567,6 +1166,91 @@ func (g *Generator) generateApiFile(file *FileDescriptor) {
 	}
 	g.Write(rem.Bytes())
 
+	g.reformat()
+}
+
+// failSharedPackage fails if another generated file of the package of file declares
+// services, decl being declared once per file by the option.
+func (g *Generator) failSharedPackage(file *FileDescriptor, option, decl string) {
+	dir := path.Dir(file.goFileName(g.pathType, g.apiSuffix))
+	for _, other := range g.genFiles {
+		if other != file && len(other.FileDescriptorProto.Service) > 0 && path.Dir(other.goFileName(g.pathType, g.apiSuffix)) == dir {
+			g.Fail(option, file.GetName(), "and", other.GetName(), "both declare services,", decl, "would be declared twice")
+		}
+	}
+}
+
+// generateRegisterAll prints AllHandlers, embedding the handler interface of every service
+// of the file, and RegisterAll registering all of them on g. The names are not derived from
+// the file, so only one file of a package may declare services.
+func (g *Generator) generateRegisterAll(file *FileDescriptor) {
+	g.failSharedPackage(file, "gen_register_all:", "RegisterAll")
+
+	methods := make(map[string]string)
+	for _, service := range file.FileDescriptorProto.Service {
+		for _, method := range service.Method {
+			name := g.methodName(method)
+			if other, ok := methods[name]; ok {
+				g.Fail("gen_register_all:", other, "and", service.GetName(), "both declare", name, "which AllHandlers can't embed twice")
+			}
+			methods[name] = service.GetName()
+		}
+	}
+
+	g.P(`// AllHandlers implements the handlers of every service of `, file.GetName(), `.`)
+	g.P(`type AllHandlers interface {`)
+	for _, service := range file.FileDescriptorProto.Service {
+		g.P(CamelCase(service.GetName()), `Handler`)
+	}
+	g.P(`}`)
+	g.P()
+	g.P(`// RegisterAll registers the routes of every service of `, file.GetName(), ` on g.`)
+	g.P(`func RegisterAll(g *gin.Engine, h AllHandlers) {`)
+	for _, service := range file.FileDescriptorProto.Service {
+		g.P(`Register`, CamelCase(service.GetName()), `Handler(g, h)`)
+	}
+	g.P(`}`)
+	g.P()
+}
+
+// generateIfaceFile fills the buffer with the handler interfaces of the file's services.
+// Unlike the api file it never imports gin, so packages implementing the handlers can
+// depend on it without pulling in the registration code.
+func (g *Generator) generateIfaceFile(file *FileDescriptor) {
+	g.file = file
+	g.outputImportPath = GoImportPath(file.GetName())
+	g.usedPackages = make(map[GoImportPath]bool)
+	g.packageNames = make(map[GoImportPath]GoPackageName)
+	g.usedPackageNames = make(map[GoPackageName]bool)
+	g.addedImports = make(map[GoImportPath]bool)
+	g.externalImports = make(map[GoImportPath]GoPackageName)
+	for name := range globalPackageNames {
+		g.usedPackageNames[name] = true
+	}
+
+	for i, service := range file.FileDescriptorProto.Service {
+		serviceName := strings.ToLower(service.GetName())
+		if pkg := file.GetPackage(); pkg != "" {
+			serviceName = pkg
+		}
+
+		_, methodAnnotations := g.methodAnnotations(i, service)
+
+		g.P()
+		g.generateServiceInterface(serviceName, CamelCase(service.GetName()), service, methodAnnotations)
+	}
+
+	rem := g.Buffer
+	g.Buffer = new(bytes.Buffer)
+	g.generateHeader()
+	g.generateImports("iface", false)
+	g.Write(rem.Bytes())
+
+	g.reformat()
+}
+
+// reformat parses the generated code in the buffer and prints it back in gofmt style.
+func (g *Generator) reformat() {
 	// Reformat generated code and patch annotation locations.
 	fset := token.NewFileSet()
 	original := g.Bytes()
@@ -591,272 +1275,1712 @@ func (g *Generator) generateApiFile(file *FileDescriptor) {
 }
 
 func (g *Generator) generateHandler(k, v string) {
-	p := g.Param["path"] + "/handler.json"
-	bts, err := os.ReadFile(p)
-	if err != nil {
-		g.Fail("handler.json file not found")
+	g.handlers[k] = v
+	if g.dryRun {
+		return
 	}
 
-	m := map[string]string{}
-	if err := json.Unmarshal(bts, &m); err != nil {
-		g.Fail("handler.json file content error")
-	}
+	defer g.lockHandlers()()
 
+	m := g.readHandlers()
 	m[k] = v
-
-	bts, _ = json.Marshal(m)
-	os.WriteFile(p, bts, 0o777)
+	g.writeHandlers(m)
 }
 
-func (g *Generator) generateService(file *FileDescriptor, service *descriptor.ServiceDescriptorProto, index int) bool {
-	path := fmt.Sprintf("6,%d", index)
+// pruneHandlers removes the handler.json entries of the directories generated in this run
+// whose service was not generated. All files of a package must be generated in the same
+// run, otherwise the services of the files left out are pruned as well.
+func (g *Generator) pruneHandlers() {
+	if len(g.handlers) == 0 {
+		return
+	}
 
-	origServName := service.GetName()
-	serviceName := strings.ToLower(service.GetName())
-	if pkg := file.GetPackage(); pkg != "" {
-		serviceName = pkg
+	dirs := make(map[string]bool)
+	for _, v := range g.handlers {
+		dirs[v] = true
 	}
-	servName := CamelCase(origServName)
 
-	g.P()
-	g.P()
+	defer g.lockHandlers()()
 
-	// Client interface.
-	g.P("type ", servName, "Handler interface {")
-	for _, method := range service.Method {
-		g.P(g.generateClientSignature(serviceName, servName, method))
+	m := g.readHandlers()
+	pruned := false
+	for k, v := range m {
+		if _, ok := g.handlers[k]; !ok && dirs[v] {
+			log.Print("protoc-gen-rain: pruning stale handler ", k)
+			delete(m, k)
+			pruned = true
+		}
 	}
-	g.P("}")
-	g.P()
 
-	g.P(`func Register` + servName + `Handler(g *gin.Engine, h ` + servName + `Handler) {`)
+	if pruned {
+		g.writeHandlers(m)
+	}
+}
+
+// handlersLockTimeout bounds the wait for handler.json.lock. A lock older than that was left
+// by a crashed run and is broken.
+const handlersLockTimeout = 30 * time.Second
+
+// handlersDir returns the directory of handler.json, set by the path parameter.
+func (g *Generator) handlersDir() string {
+	dir := g.Param["path"]
+	if dir == "" {
+		g.Fail("handler.json: path parameter not set")
+	}
+	return dir
+}
+
+// lockHandlers takes handler.json.lock, serializing the updates of handler.json by protoc
+// runs in parallel, and returns the function releasing it.
+func (g *Generator) lockHandlers() func() {
+	p := g.handlersDir() + "/handler.json.lock"
+	deadline := time.Now().Add(handlersLockTimeout)
+	for {
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(p) }
+		}
+		if !os.IsExist(err) {
+			g.Fail("handler.json.lock:", err.Error())
+		}
+
+		if fi, err := os.Stat(p); err == nil && time.Since(fi.ModTime()) > handlersLockTimeout {
+			log.Print("protoc-gen-rain: breaking stale ", p)
+			os.Remove(p)
+			continue
+		}
+		if time.Now().After(deadline) {
+			g.Fail("handler.json.lock: timed out waiting for", p)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// readHandlers reads the handler.json registry of generated services. A missing registry
+// is empty; it is created by the next writeHandlers.
+func (g *Generator) readHandlers() map[string]string {
+	m := map[string]string{}
+
+	p := g.handlersDir() + "/handler.json"
+	bts, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return m
+	}
+	if err != nil {
+		g.Fail("handler.json:", err.Error())
+	}
+
+	if err := json.Unmarshal(bts, &m); err != nil {
+		g.Fail("handler.json file content error")
+	}
+
+	return m
+}
+
+// writeHandlers writes the handler.json registry of generated services. The file is
+// replaced atomically so that readers never see it half written. encoding/json sorts
+// the keys, keeping the output stable across runs.
+func (g *Generator) writeHandlers(m map[string]string) {
+	bts, err := json.Marshal(m)
+	if err != nil {
+		g.Fail("handler.json:", err.Error())
+	}
+
+	// The temporary file is created next to handler.json for the rename to stay within
+	// one file system.
+	dir := g.handlersDir()
+	p := dir + "/handler.json"
+	f, err := os.CreateTemp(dir, ".handler.json.*")
+	if err != nil {
+		g.Fail("handler.json:", err.Error())
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(bts); err != nil {
+		f.Close()
+		g.Fail("handler.json:", err.Error())
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		g.Fail("handler.json:", err.Error())
+	}
+	if err := f.Close(); err != nil {
+		g.Fail("handler.json:", err.Error())
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		g.Fail("handler.json:", err.Error())
+	}
+}
+
+func (g *Generator) generateService(file *FileDescriptor, service *descriptor.ServiceDescriptorProto, index int) bool {
+	origServName := service.GetName()
+	serviceName := strings.ToLower(service.GetName())
+	if pkg := file.GetPackage(); pkg != "" {
+		serviceName = pkg
+	}
+	servName := CamelCase(origServName)
+
+	g.P()
+	g.P()
+
+	methodComments, methodAnnotations := g.methodAnnotations(index, service)
+
+	serviceComment, _ := g.makeComments(fmt.Sprintf("6,%d", index))
+	serviceAnnotations := parseAnnotations(serviceComment)
+
+	prefix := g.servicePrefix(file, index)
+
+	if !g.ifaceFile {
+		g.generateServiceInterface(serviceName, servName, service, methodAnnotations)
+	}
+
+	if g.routeFilter {
+		g.generateRouteEnum(servName, service)
+
+		g.P(`func Register` + servName + `Handler(g *gin.Engine, h ` + servName + `Handler) {`)
+		g.P(`Register` + servName + `HandlerFiltered(g, h, func(` + servName + `Route) bool { return true })`)
+		g.P("}")
+		g.P()
+
+		g.P(`// Register` + servName + `HandlerFiltered registers only the routes for which enabled returns true.`)
+		g.P(`func Register` + servName + `HandlerFiltered(g *gin.Engine, h ` + servName + `Handler, enabled func(` + servName + `Route) bool) {`)
+	} else {
+		g.P(`func Register` + servName + `Handler(g *gin.Engine, h ` + servName + `Handler) {`)
+	}
+
+	if g.sharedChains {
+		g.generateChains(methodAnnotations)
+	}
 
 	hasBinding := false
 	for i, method := range service.Method {
-		customAnnotations := map[string]string{}
-		if cs, ok := g.makeComments(fmt.Sprintf("%s,2,%d", path, i)); ok {
-			if g.writeOutput {
-				g.P(cs)
-			}
-
-			if res := regAnnotation.FindStringSubmatch(cs); len(res) > 1 {
-				for _, h := range strings.Split(res[1], " ") {
-					key, val := strings.Trim(h, " "), ""
-					if strings.Contains(key, ":") {
-						arr := strings.Split(key, ":")
-						key, val = arr[0], arr[1]
-					}
-
-					customAnnotations[key] = val
-				}
-			}
+		if methodComments[i] != "" && g.writeOutput {
+			g.P(methodComments[i])
 		}
 
-		binding := g.generateClientMethod(serviceName, servName, method, customAnnotations)
+		if g.routeFilter {
+			g.P(`if enabled(` + servName + `Route_` + g.methodName(method) + `) {`)
+		}
+		binding := g.generateClientMethod(serviceName, servName, prefix, method, methodAnnotations[i])
 		if !hasBinding && binding {
 			hasBinding = true
 		}
+		if g.routeFilter {
+			g.P("}")
+			g.P()
+		}
 	}
 
 	g.P("}")
 	g.P()
 
-	fname := file.goFileName(g.pathType, "api")
+	if val, ok := serviceAnnotations["errors"]; ok {
+		g.generateErrorSet(file, servName, val)
+	}
+
+	if g.serverBuilder {
+		g.generateServerBuilder(servName)
+	}
+
+	if g.testable {
+		g.generateCallWrappers(servName, service, methodAnnotations)
+	}
+
+	if g.adapter {
+		g.generateAdapter(servName, prefix, service, methodAnnotations)
+	}
+
+	fname := file.goFileName(g.pathType, g.apiSuffix)
 	fpath := filepath.Dir(fname)
 	g.generateHandler(fpath+"/"+servName, fpath)
 
 	return hasBinding
 }
 
-var reservedClientName = map[string]bool{}
+// generateErrorSet prints XxxErrors, listing every value of the error enum named by the
+// errors:<enum> service annotation.
+func (g *Generator) generateErrorSet(file *FileDescriptor, servName, name string) {
+	enum, protoName := g.errorEnum(file, name)
+	g.RecordTypeUse(protoName)
+
+	typeName := g.TypeName(enum)
+	qualifier := typeName[:strings.LastIndex(typeName, ".")+1]
+
+	g.P(`// `, servName, `Errors lists the error codes `, servName, ` may respond with.`)
+	g.P(`var `, servName, `Errors = []`, typeName, `{`)
+	for _, e := range enum.Value {
+		g.P(qualifier, enum.prefix(), e.GetName(), `,`)
+	}
+	g.P(`}`)
+	g.P()
+}
+
+// errorEnum returns the enum named by an errors:<enum> service annotation and its fully
+// qualified proto name, looking the name up relative to the file's package first.
+func (g *Generator) errorEnum(file *FileDescriptor, name string) (*EnumDescriptor, string) {
+	for _, typeName := range []string{"." + file.GetPackage() + "." + name, "." + strings.TrimPrefix(name, ".")} {
+		if obj, ok := g.typeNameToObject[typeName]; ok {
+			if enum, ok := obj.(*EnumDescriptor); ok {
+				return enum, typeName
+			}
+			break
+		}
+	}
+	g.Fail("errors:", name, "is not an enum")
+	return nil, ""
+}
+
+// generateCallWrappers prints a CallXxxMethod function per method, running the handler
+// outside of any HTTP request so that tests can exercise it directly. Handlers take a
+// context.Context, testable requiring stdctx=true, and get context.Background().
+func (g *Generator) generateCallWrappers(servName string, service *descriptor.ServiceDescriptorProto, methodAnnotations []map[string]string) {
+	g.addExternalImport("context", "")
+	ctx := "context.Background()"
+
+	for i, method := range service.Method {
+		methName := g.methodName(method)
+
+		in := g.inputTypeName(method)
+		out := g.typeName(method.GetOutputType())
+
+		user := ""
+		if g.needAuthUser(methodAnnotations[i]) {
+			user = "nil, "
+		}
+
+		if method.GetServerStreaming() {
+			g.P(`// Call`, servName, methName, ` calls h.`, methName, ` without gin, sending its output to stream.`)
+			g.P(`func Call`, servName, methName, `(h `, servName, `Handler, in *`, in, `, stream router.Stream[`, out, `]) error {`)
+			g.P(`return h.`, methName, `(`, ctx, `, `, user, `in, stream)`)
+			g.P(`}`)
+			g.P()
+			continue
+		}
+
+		g.P(`// Call`, servName, methName, ` calls h.`, methName, ` without gin and returns its output.`)
+		g.P(`func Call`, servName, methName, `(h `, servName, `Handler, in *`, in, `) (*`, out, `, error) {`)
+		g.P(`out := &`, out, `{}`)
+		g.P(`if err := h.`, methName, `(`, ctx, `, `, user, `in, out); err != nil {`)
+		g.P(`return nil, err`)
+		g.P(`}`)
+		g.P()
+		g.P(`return out, nil`)
+		g.P(`}`)
+		g.P()
+	}
+}
+
+// generateServerBuilder prints NewXxxServer, returning an *http.Server on addr serving
+// the routes of the service from a fresh gin engine.
+func (g *Generator) generateServerBuilder(servName string) {
+	g.addExternalImport("net/http", "")
+
+	g.P(`// New` + servName + `Server returns a server on addr serving the routes of h, ready for ListenAndServe.`)
+	g.P(`func New` + servName + `Server(h ` + servName + `Handler, addr string) *http.Server {`)
+	g.P(`engine := gin.New()`)
+	g.P(`Register` + servName + `Handler(engine, h)`)
+	g.P()
+	g.P(`return &http.Server{`)
+	g.P(`Addr:    addr,`)
+	g.P(`Handler: engine,`)
+	g.P(`}`)
+	g.P(`}`)
+	g.P()
+}
+
+// methodName returns the Go name of the method in the handler interface.
+func (g *Generator) methodName(method *descriptor.MethodDescriptorProto) string {
+	methName := CamelCase(method.GetName())
+	if reservedClientName[methName] {
+		methName += "_"
+	}
+	return methName
+}
+
+// generateRouteEnum prints the XxxRoute type with one constant per method of the service,
+// naming the routes for RegisterXxxHandlerFiltered.
+func (g *Generator) generateRouteEnum(servName string, service *descriptor.ServiceDescriptorProto) {
+	g.P(`// `, servName, `Route identifies a route of `, servName, `Handler.`)
+	g.P(`type `, servName, `Route int`)
+	g.P()
+	g.P(`const (`)
+	for i, method := range service.Method {
+		if i == 0 {
+			g.P(servName, `Route_`, g.methodName(method), ` `, servName, `Route = iota`)
+		} else {
+			g.P(servName, `Route_`, g.methodName(method))
+		}
+	}
+	g.P(`)`)
+	g.P()
+}
+
+// errorCall returns the statement rendering err with the given fallback code.
+func (g *Generator) errorCall(code string) string {
+	if g.inAdapter {
+		return `ctx.Error(` + code + `, err)`
+	}
+	if g.errorHelper {
+		if code == defaultErrorCode() {
+			return `handleError(ctx, err)`
+		}
+		return `handleError(ctx, router.WithCode(err, ` + code + `))`
+	}
+	return `router.Error(ctx, ` + code + `, err)`
+}
+
+// returnErr returns the statement ending the handler once it has rendered err: a bare
+// return, or within router.AccessLog the return of err to it.
+func (g *Generator) returnErr() string {
+	if g.inAccessLog {
+		return `return err`
+	}
+	return `return`
+}
+
+// generateErrorHelper prints handleError, through which all handlers of the file render
+// errors. Errors implementing router.CodedError carry their own code, anything else is
+// rendered with the default error code. The name is not derived from the file, so only one
+// file of a package may declare services.
+func (g *Generator) generateErrorHelper(file *FileDescriptor) {
+	g.failSharedPackage(file, "error_helper:", "handleError")
+
+	g.addExternalImport("errors", "")
+
+	g.P("func handleError(ctx *gin.Context, err error) {")
+	g.P("code := ", defaultErrorCode())
+	g.P("var coded router.CodedError")
+	g.P("if errors.As(err, &coded) {")
+	g.P("code = coded.Code()")
+	g.P("}")
+	g.P()
+	g.P("router.Error(ctx, code, err)")
+	g.P("}")
+	g.P()
+}
+
+// servicePrefix returns the path the routes of the index-th service of file are mounted under:
+// its prefix:<path> annotation, or with package_base_path=true the path of the proto package.
+func (g *Generator) servicePrefix(file *FileDescriptor, index int) string {
+	serviceComment, _ := g.makeComments(fmt.Sprintf("6,%d", index))
+	serviceAnnotations := parseAnnotations(serviceComment)
+
+	prefix := serviceAnnotations["prefix"]
+	if _, ok := serviceAnnotations["prefix"]; !ok && g.packageBasePath && file.GetPackage() != "" {
+		prefix = "/" + strings.ReplaceAll(file.GetPackage(), ".", "/")
+	}
+	return strings.TrimSuffix(prefix, "/")
+}
+
+// httpRule returns the verb and URL template of the google.api.http option of method.
+func (g *Generator) httpRule(method *descriptor.MethodDescriptorProto) (string, string, *annotations.HttpRule) {
+	if method.Options == nil || !proto.HasExtension(method.Options, annotations.E_Http) {
+		g.Fail("option google.api.http not found")
+	}
+	ext, _ := proto.GetExtension(method.Options, annotations.E_Http)
+	opts, ok := ext.(*annotations.HttpRule)
+	if !ok {
+		g.Fail("option google.api.http not found")
+	}
+
+	verb, url := g.httpPattern(method, opts)
+	return verb, url, opts
+}
+
+// httpRules returns the google.api.http rule of the method followed by its additional_bindings.
+func (g *Generator) httpRules(method *descriptor.MethodDescriptorProto) []*annotations.HttpRule {
+	_, _, opts := g.httpRule(method)
+	rules := []*annotations.HttpRule{opts}
+	for _, rule := range opts.AdditionalBindings {
+		if len(rule.AdditionalBindings) > 0 {
+			g.Fail("additional_bindings of method", method.GetName(), "can't be nested")
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// httpPattern returns the verb and the path of rule.
+func (g *Generator) httpPattern(method *descriptor.MethodDescriptorProto, rule *annotations.HttpRule) (string, string) {
+	switch pattern := rule.Pattern.(type) {
+	case *annotations.HttpRule_Get:
+		return "GET", pattern.Get
+	case *annotations.HttpRule_Post:
+		return "POST", pattern.Post
+	case *annotations.HttpRule_Put:
+		return "PUT", pattern.Put
+	case *annotations.HttpRule_Delete:
+		return "DELETE", pattern.Delete
+	case *annotations.HttpRule_Patch:
+		return "PATCH", pattern.Patch
+	case *annotations.HttpRule_Custom:
+		verb := strings.ToUpper(pattern.Custom.GetKind())
+		if verb == "" {
+			g.Fail("custom google.api.http pattern without kind on method", method.GetName())
+		}
+		return verb, pattern.Custom.GetPath()
+	}
+	g.Fail("unsupported google.api.http pattern on method", method.GetName())
+	return "", ""
+}
+
+// noJSONRule reports whether the rule's response_body names a field rather than the
+// default JSON rendering of the output.
+func noJSONRule(rule *annotations.HttpRule) bool {
+	return rule.ResponseBody != "" && rule.ResponseBody != "json"
+}
+
+// methodAnnotations returns the leading comment and the @tag annotations of each method of the service.
+func (g *Generator) methodAnnotations(index int, service *descriptor.ServiceDescriptorProto) ([]string, []map[string]string) {
+	path := fmt.Sprintf("6,%d", index)
+
+	methodComments := make([]string, len(service.Method))
+	methodAnnotations := make([]map[string]string, len(service.Method))
+	for i := range service.Method {
+		cs, _ := g.makeComments(fmt.Sprintf("%s,2,%d", path, i))
+		methodComments[i] = cs
+		methodAnnotations[i] = parseAnnotations(cs)
+	}
+
+	// The bindcheck annotation of the service is the default of its methods: the method's
+	// own bindcheck comes first, then the service's, then the built-in true.
+	serviceComment, _ := g.makeComments(path)
+	if val, ok := parseAnnotations(serviceComment)["bindcheck"]; ok {
+		for _, customAnnotations := range methodAnnotations {
+			if _, ok := customAnnotations["bindcheck"]; !ok {
+				customAnnotations["bindcheck"] = val
+			}
+		}
+	}
+
+	return methodComments, methodAnnotations
+}
+
+// generateServiceInterface prints the handler interface of the service.
+func (g *Generator) generateServiceInterface(serviceName, servName string, service *descriptor.ServiceDescriptorProto, methodAnnotations []map[string]string) {
+	g.P("type ", servName, "Handler interface {")
+	for i, method := range service.Method {
+		sig := g.generateClientSignature(serviceName, servName, method, methodAnnotations[i])
+		if g.ifaceFile && strings.Contains(sig, "router.") {
+			g.addExternalImport(GoImportPath(g.Param["repo"]+"/router"), "")
+		}
+		if method.GetOptions().GetDeprecated() {
+			g.P(deprecationComment)
+		}
+		if isStreamDecode(methodAnnotations[i]) {
+			g.P("// ", CamelCase(method.GetName()), " is called once per element of the JSON array body.")
+		}
+		if method.GetServerStreaming() {
+			g.P("// ", CamelCase(method.GetName()), " sends its outputs to stream, written as server-sent events.")
+		}
+		g.P(sig)
+	}
+	g.P("}")
+	g.P()
+}
+
+var reservedClientName = map[string]bool{}
+
+func (g *Generator) typeName(str string) string {
+	g.RecordTypeUse(str)
+	return g.TypeName(g.ObjectNamed(str))
+}
+
+// inputTypeName returns the Go type of the input of a method. router.Empty stands for the
+// Empty messages of the types and empty packages, whose package isn't imported.
+func (g *Generator) inputTypeName(method *descriptor.MethodDescriptorProto) string {
+	in := g.TypeName(g.ObjectNamed(method.GetInputType()))
+	if in == "types.Empty" || in == "empty.Empty" {
+		return "router.Empty"
+	}
+	g.RecordTypeUse(method.GetInputType())
+	return in
+}
+
+func (g *Generator) generateClientSignature(reqServ, servName string, method *descriptor.MethodDescriptorProto, customAnnotations map[string]string) string {
+	methName := g.methodName(method)
+
+	in := g.inputTypeName(method)
+
+	user := ""
+	if g.needAuthUser(customAnnotations) {
+		user = ", user *router.AuthUser"
+	}
+
+	ctx := "ctx *gin.Context"
+	if g.stdCtx {
+		g.addExternalImport("context", "")
+		ctx = "ctx context.Context"
+	}
+
+	input := ", in *" + in
+	outName := g.typeName(method.GetOutputType())
+	output := ", out *" + outName
+	if method.GetServerStreaming() {
+		output = ", stream router.Stream[" + outName + "]"
+	}
+
+	return fmt.Sprintf("%s(%s%s%s%s) error", methName, ctx, user, input, output)
+}
+
+// handlerContext returns the context expression passed to the handler.
+// With stdctx=true handlers take the request's context.Context instead of the gin context,
+// wrapped by router.Context so that values set by gin middlewares remain reachable.
+func (g *Generator) handlerContext(ginCtx string) string {
+	if g.stdCtx {
+		return "router.Context(ctx)"
+	}
+	return ginCtx
+}
+
+// rawBodyField returns the Go name of the input field named by the rawbody annotation,
+// which must be a bytes field.
+func (g *Generator) rawBodyField(method *descriptor.MethodDescriptorProto, name string) string {
+	message, ok := g.ObjectNamed(method.GetInputType()).(*Descriptor)
+	if !ok {
+		g.Fail("rawbody:", name, "input of", method.GetName(), "is not a message")
+	}
+
+	field := g.fieldByName(message, name)
+	if field == nil {
+		g.Fail("rawbody:", name, "is not a field of", message.GetName())
+	}
+	if field.GetType() != descriptor.FieldDescriptorProto_TYPE_BYTES || isRepeated(field) {
+		g.Fail("rawbody:", name, "must be a bytes field")
+	}
+
+	return g.fieldGoName(message, field)
+}
+
+// fieldByName returns the field of the message whose proto, JSON or Go name is name, or nil.
+func (g *Generator) fieldByName(message *Descriptor, name string) *descriptor.FieldDescriptorProto {
+	for _, field := range message.Field {
+		if field.GetName() == name || field.GetJsonName() == name || g.fieldGoName(message, field) == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// formName returns the form tag name of the field, following form_key.
+func (g *Generator) formName(field *descriptor.FieldDescriptorProto) string {
+	if g.formKey == "json" {
+		return g.jsonName(field)
+	}
+	return field.GetName()
+}
+
+// jsonName returns the json tag name of the field, following json_case: the lowerCamelCase
+// JSON name by default, the proto name converted to snake_case, or the proto name as is.
+func (g *Generator) jsonName(field *descriptor.FieldDescriptorProto) string {
+	switch g.jsonCase {
+	case "snake":
+		return snakeCase(field.GetName())
+	case "original":
+		return field.GetName()
+	}
+	if field.JsonName != nil {
+		return field.GetJsonName()
+	}
+	return field.GetName()
+}
+
+// snakeCase converts a proto name like userID or UserId to user_id. Names already in
+// snake_case are returned unchanged.
+func snakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 && s[i-1] != '_' && (!unicode.IsUpper(rune(s[i-1])) || i+1 < len(s) && unicode.IsLower(rune(s[i+1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// generateEnumQuery prints, for every enum field of the method's input, the conversion of
+// value names in the query to numbers through the generated ParseXxx, ahead of query binding.
+func (g *Generator) generateEnumQuery(method *descriptor.MethodDescriptorProto, bindCheck bool, code string) {
+	message, ok := g.ObjectNamed(method.GetInputType()).(*Descriptor)
+	if !ok {
+		return
+	}
+
+	for _, field := range message.Field {
+		if field.GetType() != descriptor.FieldDescriptorProto_TYPE_ENUM {
+			continue
+		}
+
+		g.RecordTypeUse(field.GetTypeName())
+		typeName := g.TypeName(g.ObjectNamed(field.GetTypeName()))
+		call := `router.EnumQuery(ctx, "` + g.formName(field) + `", ` + enumParseFunc(typeName) + `)`
+
+		if !bindCheck {
+			g.P(`_ = ` + call)
+			continue
+		}
+		g.P(`if err := ` + call + `; err != nil {`)
+		g.P(g.errorCall(code))
+		g.P(g.returnErr())
+		g.P(`}`)
+	}
+}
+
+// enumParseFunc returns the ParseXxx function generated with enum_parse=true for the enum
+// typeName, qualified like it.
+func enumParseFunc(typeName string) string {
+	i := strings.LastIndex(typeName, ".") + 1
+	return typeName[:i] + "Parse" + typeName[i:]
+}
+
+// fieldGoName returns the name of the struct field generated for the field.
+func (g *Generator) fieldGoName(message *Descriptor, field *descriptor.FieldDescriptorProto) string {
+	names, _ := g.fieldGoNames(message)
+	for i, f := range message.Field {
+		if f == field {
+			return names[i]
+		}
+	}
+	g.Fail("field", field.GetName(), "is not a field of", message.GetName())
+	return ""
+}
+
+// fieldGoNames returns the names of the struct fields and of the getters generated for the
+// fields of the message, in order.
+func (g *Generator) fieldGoNames(message *Descriptor) (names, getters []string) {
+	return allocFieldNames(message, g.stripFieldPrefix)
+}
+
+// allocFieldNames returns the field and getter names of the fields of the message, in order,
+// stripped of the message name prefix if strip is set. Names colliding with a generated
+// method or an earlier field get a _ suffix, e.g. String_ and GetString_ for a field named
+// string, as protoc-gen-go does.
+func allocFieldNames(message *Descriptor, strip bool) (names, getters []string) {
+	usedNames := make(map[string]bool)
+	for _, n := range methodNames {
+		usedNames[n] = true
+	}
+
+	// allocNames finds a conflict-free variation of the given strings,
+	// consistently mutating their suffixes.
+	// It returns the same number of strings.
+	allocNames := func(ns ...string) []string {
+	Loop:
+		for {
+			for _, n := range ns {
+				if usedNames[n] {
+					for i := range ns {
+						ns[i] += "_"
+					}
+					continue Loop
+				}
+			}
+			for _, n := range ns {
+				usedNames[n] = true
+			}
+			return ns
+		}
+	}
+
+	for _, field := range message.Field {
+		base := exported(CamelCase(field.GetName()))
+		if strip {
+			base = stripNamePrefix(base, CamelCase(message.GetName()))
+		}
+		ns := allocNames(base, "Get"+base)
+		names = append(names, ns[0])
+		getters = append(getters, ns[1])
+	}
+	return names, getters
+}
+
+// generateWildcards prints the assignment of the {name=**} segments of url, registered as
+// gin catch-all parameters, to the input fields of the same name, without gin's leading slash.
+func (g *Generator) generateWildcards(method *descriptor.MethodDescriptorProto, url string) {
+	matches := regWildcard.FindAllStringSubmatch(url, -1)
+	if len(matches) == 0 {
+		return
+	}
+
+	message, ok := g.ObjectNamed(method.GetInputType()).(*Descriptor)
+	if !ok {
+		g.Fail("wildcard: input of", method.GetName(), "is not a message")
+	}
+
+	g.addExternalImport("strings", "")
+
+	for _, m := range matches {
+		field := g.fieldByName(message, m[1])
+		if field == nil || field.GetType() != descriptor.FieldDescriptorProto_TYPE_STRING || isRepeated(field) {
+			g.Fail("wildcard:", m[1], "is not a string field of", message.GetName())
+		}
+		g.P(`input.` + g.fieldGoName(message, field) + ` = strings.TrimPrefix(ctx.Param("` + m[1] + `"), "/")`)
+	}
+}
+
+// generateRawBody prints the code reading the raw request body. The body is put back
+// on the request so that binding parses the buffered bytes. With buffered_bind the body
+// is copied out of the pooled buffer, which is reused once the handler returns.
+func (g *Generator) generateRawBody(code string, buffered bool) {
+	if buffered {
+		g.P(`body := append([]byte(nil), buf.Bytes()...)`)
+		g.P()
+		return
+	}
+
+	g.addExternalImport("bytes", "")
+	g.addExternalImport("io", "")
+
+	g.P(`var body []byte`)
+	g.P(`if raw, err := ctx.GetRawData(); err == nil {`)
+	g.P(`body = raw`)
+	g.P(`} else {`)
+	g.P(g.errorCall(code))
+	g.P(g.returnErr())
+	g.P(`}`)
+	g.P(`ctx.Request.Body = io.NopCloser(bytes.NewReader(body))`)
+	g.P()
+}
+
+// generateBufferedRead prints the reading of the request body into a buffer of
+// router.BufferPool, handed back when the handler returns. The body is put back on the
+// request so that the form bindings parse the buffered bytes.
+func (g *Generator) generateBufferedRead(code string) {
+	g.addExternalImport("bytes", "")
+	g.addExternalImport("io", "")
+
+	g.P(`buf := router.BufferPool.Get().(*bytes.Buffer)`)
+	g.P(`buf.Reset()`)
+	g.P(`defer router.BufferPool.Put(buf)`)
+	g.P(`if _, err := buf.ReadFrom(ctx.Request.Body); err != nil {`)
+	g.P(g.errorCall(code))
+	g.P(g.returnErr())
+	g.P(`}`)
+	g.P(`ctx.Request.Body = io.NopCloser(bytes.NewReader(buf.Bytes()))`)
+	g.P()
+}
+
+// generateDecompress prints the unwrapping of gzip request bodies, announced by the
+// Content-Encoding header, so that binding reads the decompressed body. The request no
+// longer claims the encoding nor the compressed length, and reading more than
+// router.MaxDecompressedBody bytes fails.
+func (g *Generator) generateDecompress(code string) {
+	g.addExternalImport("compress/gzip", "")
+	g.addExternalImport("net/http", "")
+
+	g.P(`if ctx.GetHeader("Content-Encoding") == "gzip" {`)
+	g.P(`if zr, err := gzip.NewReader(ctx.Request.Body); err == nil {`)
+	g.P(`ctx.Request.Header.Del("Content-Encoding")`)
+	g.P(`ctx.Request.ContentLength = -1`)
+	g.P(`ctx.Request.Body = http.MaxBytesReader(ctx.Writer, zr, router.MaxDecompressedBody)`)
+	g.P(`} else {`)
+	g.P(g.errorCall(code))
+	g.P(g.returnErr())
+	g.P(`}`)
+	g.P(`}`)
+	g.P()
+}
+
+// generateFieldErrors prints the bind error branch reporting validation failures per field.
+// The fields are named after their JSON tags, resolved by router.JSONFieldName.
+func (g *Generator) generateFieldErrors(code string) {
+	g.addExternalImport("errors", "")
+	g.addExternalImport("github.com/go-playground/validator/v10", "")
+
+	g.P(`var verrs validator.ValidationErrors`)
+	g.P(`if errors.As(err, &verrs) {`)
+	g.P(`fieldErrs := make(router.FieldErrors, 0, len(verrs))`)
+	g.P(`for _, fe := range verrs {`)
+	g.P(`fieldErrs = append(fieldErrs, map[string]string{"field": router.JSONFieldName(&input, fe.StructField()), ` + strconv.Quote(g.errorKey) + `: fe.Error()})`)
+	g.P(`}`)
+	g.P(`router.FieldError(ctx, ` + code + `, fieldErrs)`)
+	g.P(g.returnErr())
+	g.P(`}`)
+	g.P()
+}
+
+// hasValidateTags reports whether a field of the input of method is annotated validate:<rules>.
+func (g *Generator) hasValidateTags(method *descriptor.MethodDescriptorProto) bool {
+	message, ok := g.ObjectNamed(method.GetInputType()).(*Descriptor)
+	return ok && isValidated(message)
+}
+
+// isValidated reports whether a field of message is annotated validate:<rules>.
+func isValidated(message *Descriptor) bool {
+	for i := range message.Field {
+		loc, ok := message.file.comments[fmt.Sprintf("%s,%d,%d", message.path, messageFieldPath, i)]
+		if ok && parseAnnotations(commentText(loc))["validate"] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// generateHeaderFields prints the assignment of the request headers named by the
+// header:<name> annotations of the input fields, converted like path variables.
+// Absent headers leave the bound value untouched.
+func (g *Generator) generateHeaderFields(method *descriptor.MethodDescriptorProto, code string) {
+	message, ok := g.ObjectNamed(method.GetInputType()).(*Descriptor)
+	if !ok {
+		return
+	}
+
+	getHeader := "ctx.GetHeader"
+	if g.inAdapter {
+		getHeader = "ctx.Header"
+	}
+
+	assigned := false
+	for i, field := range message.Field {
+		loc, ok := message.file.comments[fmt.Sprintf("%s,%d,%d", message.path, messageFieldPath, i)]
+		if !ok {
+			continue
+		}
+		name, ok := parseAnnotations(commentText(loc))["header"]
+		if !ok {
+			continue
+		}
+
+		if name == "" {
+			g.Fail("header: field", field.GetName(), "names no header")
+		}
+		switch field.GetType() {
+		case descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_GROUP, descriptor.FieldDescriptorProto_TYPE_BYTES:
+			g.Fail("header:", name, "is bound to", field.GetName(), "which is not a string, number, bool or enum field")
+		}
+		if isRepeated(field) {
+			g.Fail("header:", name, "is bound to", field.GetName(), "which is repeated")
+		}
+
+		typ, _ := g.GoType("", message, field)
+		g.P(`if header := ` + getHeader + `(` + strconv.Quote(name) + `); header != "" {`)
+		g.generatePathParamAssign(field, `input.`+g.fieldGoName(message, field), typ, "header", code)
+		g.P(`}`)
+		assigned = true
+	}
+	if assigned {
+		g.P()
+	}
+}
+
+// generateMaxLen prints the rejection with 400 of the requests whose input has a string,
+// bytes, repeated or map field longer than its maxlen:<n> annotation. Strings are measured
+// in bytes. The checks run before the validate tags, cheaply catching oversized fields.
+func (g *Generator) generateMaxLen(method *descriptor.MethodDescriptorProto) {
+	message, ok := g.ObjectNamed(method.GetInputType()).(*Descriptor)
+	if !ok {
+		return
+	}
+
+	checked := false
+	for i, field := range message.Field {
+		loc, ok := message.file.comments[fmt.Sprintf("%s,%d,%d", message.path, messageFieldPath, i)]
+		if !ok {
+			continue
+		}
+		val, ok := parseAnnotations(commentText(loc))["maxlen"]
+		if !ok {
+			continue
+		}
+
+		if n, err := strconv.Atoi(val); err != nil || n <= 0 {
+			g.Fail("maxlen:", val, "of", field.GetName(), "is not a positive length")
+		}
+		if t := field.GetType(); !isRepeated(field) && t != descriptor.FieldDescriptorProto_TYPE_STRING && t != descriptor.FieldDescriptorProto_TYPE_BYTES {
+			g.Fail("maxlen:", field.GetName(), "must be a string, bytes, repeated or map field")
+		}
+
+		g.addExternalImport("errors", "")
+
+		jsonName := g.jsonName(field)
+		msg := strconv.Quote(jsonName + " is longer than " + val)
+
+		cond := `len(input.` + g.fieldGoName(message, field) + `) > ` + val
+		if typ, _ := g.GoType("", message, field); strings.HasPrefix(typ, "*") {
+			cond = `input.` + g.fieldGoName(message, field) + ` != nil && len(*input.` + g.fieldGoName(message, field) + `) > ` + val
+		}
+		g.P(`if ` + cond + ` {`)
+		g.P(`err := errors.New(` + msg + `)`)
+		if g.fieldErrors {
+			g.P(`router.FieldError(ctx, 400, router.FieldErrors{{"field": ` + strconv.Quote(jsonName) + `, ` + strconv.Quote(g.errorKey) + `: err.Error()}})`)
+		} else {
+			g.P(g.errorCall("400"))
+		}
+		g.P(g.returnErr())
+		g.P(`}`)
+		checked = true
+	}
+	if checked {
+		g.P()
+	}
+}
+
+// generateValidate checks the validate tags of the bound input with router.Validate.
+// gin only enforces binding tags while binding, so validate tags are checked whatever
+// the bindcheck annotation of the method: bindcheck:false ignores decoding errors only.
+// With validate_aggregate=true the input's Validate method reports every failing field.
+// Adapters render the error through router.HTTPContext, which has no field errors.
+func (g *Generator) generateValidate(code string) {
+	if g.inAdapter {
+		check := `router.Validate(&input)`
+		if g.validateAggregate {
+			check = `input.Validate()`
+		}
+		g.P(`if err := ` + check + `; err != nil {`)
+		g.P(g.errorCall(code))
+		g.P(g.returnErr())
+		g.P(`}`)
+		g.P()
+		return
+	}
+
+	if g.validateAggregate {
+		g.addExternalImport("errors", "")
+
+		g.P(`if err := input.Validate(); err != nil {`)
+		g.P(`var verrs router.ValidationErrors`)
+		g.P(`if errors.As(err, &verrs) {`)
+		g.P(`fieldErrs := make(router.FieldErrors, 0, len(verrs))`)
+		g.P(`for _, ve := range verrs {`)
+		g.P(`fieldErrs = append(fieldErrs, map[string]string{"field": ve.Field, ` + strconv.Quote(g.errorKey) + `: ve.Message})`)
+		g.P(`}`)
+		g.P(`router.FieldError(ctx, ` + code + `, fieldErrs)`)
+		g.P(g.returnErr())
+		g.P(`}`)
+		g.P()
+		g.P(g.errorCall(code))
+		g.P(g.returnErr())
+		g.P(`}`)
+		g.P()
+		return
+	}
+
+	g.P(`if err := router.Validate(&input); err != nil {`)
+	if g.fieldErrors {
+		g.generateFieldErrors(code)
+	}
+	g.P(g.errorCall(code))
+	g.P(g.returnErr())
+	g.P(`}`)
+	g.P()
+}
+
+// needAuthUser reports whether the authenticated user is passed to the handler of a method.
+// With auth_context=true every method gets it, unless annotated with auth:none.
+func (g *Generator) needAuthUser(customAnnotations map[string]string) bool {
+	return g.authContext && customAnnotations["auth"] != "none"
+}
+
+// generateClientMethod prints the route registration of the method. Its path is mounted under prefix.
+// Each of its additional_bindings is registered with its own verb and path, binding the input
+// the way that verb does, then calling the serve<Method> closure the bindings share.
+func (g *Generator) generateClientMethod(reqServ, servName, prefix string, method *descriptor.MethodDescriptorProto, customAnnotations map[string]string) bool {
+	gec := g.errorCode(method, customAnnotations)
+
+	origMethName := method.GetName()
+	methName := g.methodName(method)
+
+	if method.GetClientStreaming() {
+		g.Fail(origMethName, "is client-streaming, only unary and server-streaming methods are supported")
+	}
+	streaming := method.GetServerStreaming()
+
+	needBind := true
+
+	inType := g.inputTypeName(method)
+	if inType == "router.Empty" {
+		needBind = false
+	} else {
+		for _, desc := range g.file.desc {
+			if desc.GetOptions().GetMapEntry() {
+				continue
+			}
+
+			if goTypeName := CamelCaseSlice(desc.TypeName()); goTypeName == inType {
+				if len(desc.Field) == 0 {
+					needBind = false
+				}
+
+				break
+			}
+		}
+	}
+
+	outType := g.typeName(method.GetOutputType())
+	if strings.HasPrefix(outType, reqServ+".") {
+		outType = strings.TrimPrefix(outType, reqServ+".")
+	}
+
+	middlewares := []string{}
+	if val, ok := customAnnotations["middleware"]; ok {
+		middlewares = strings.Split(val, ",")
+	}
+
+	bindCheck := true
+	if val, ok := customAnnotations["bindcheck"]; ok && strings.EqualFold(val, "false") {
+		bindCheck = false
+	}
+
+	binding := "json"
+	if val, ok := customAnnotations["binding"]; ok {
+		binding = val
+	}
+
+	rawField := ""
+	if val, ok := customAnnotations["rawbody"]; ok {
+		rawField = g.rawBodyField(method, val)
+	}
+
+	strictJSON := g.strictJSON
+	if val, ok := customAnnotations["strictjson"]; ok {
+		strictJSON = strings.EqualFold(val, "true")
+		if strictJSON && strings.ToLower(binding) != "json" {
+			g.Fail("strictjson:", origMethName, "is bound with", binding, "binding, unknown fields are only rejected from JSON bodies")
+		}
+	}
+
+	logDeprecated := g.deprecationLog && method.GetOptions().GetDeprecated()
+	if logDeprecated {
+		g.addExternalImport("log", "")
+		g.addExternalImport("sync", "")
+		g.P(`var deprecated` + methName + ` sync.Once`)
+	}
+
+	rules := g.httpRules(method)
+	verbs := make([]string, len(rules))
+	for i, opts := range rules {
+		verbs[i], _ = g.httpPattern(method, opts)
+	}
+
+	// serve prints the part of the handler following the binding of the input, up to the
+	// rendering of the output.
+	serve := func(noJSON bool) {
+		if isStreamDecode(customAnnotations) {
+			g.generateStreamDecode(methName, inType, outType, gec, customAnnotations)
+			return
+		}
+
+		if needBind {
+			g.generateHeaderFields(method, gec)
+			g.generateMaxLen(method)
+			if d, ok := g.ObjectNamed(method.GetInputType()).(*Descriptor); ok && len(g.defaultFields(d)) > 0 {
+				g.P(`input.ApplyDefaults()`)
+			}
+			g.P()
+			if g.hasValidateTags(method) {
+				g.generateValidate(gec)
+			}
+		}
+
+		if val, ok := customAnnotations["cursor"]; ok {
+			g.generateCursor(method, val)
+		}
+
+		user := ""
+		if g.needAuthUser(customAnnotations) {
+			g.P(`user := router.User(ctx)`)
+			g.P()
+			user = "user, "
+		}
+
+		if streaming {
+			if _, ok := customAnnotations["retry"]; ok {
+				g.Fail("retry:", origMethName, "is server-streaming, its messages can't be sent again")
+			}
+			g.generateServerStream(methName, outType, user, gec)
+			return
+		}
+
+		if strings.EqualFold(customAnnotations["idempotent"], "true") {
+			// The response is rendered within router.Idempotent, which records it to replay
+			// it to the requests repeating the Idempotency-Key.
+			g.P(`router.Idempotent(ctx, ctx.GetHeader("Idempotency-Key"), func() {`)
+			inAccessLog := g.inAccessLog
+			g.inAccessLog = false
+			defer func() {
+				g.P(`})`)
+				g.inAccessLog = inAccessLog
+			}()
+		}
+
+		retry := func(call string) string { return call }
+		if val, ok := customAnnotations["retry"]; ok {
+			retry = func(call string) string { return g.retryCall(origMethName, verbs, val, outType, call) }
+		}
+
+		cacheControl := ""
+		if val, ok := customAnnotations["cachecontrol"]; ok {
+			for _, verb := range verbs {
+				if verb != "GET" && verb != "HEAD" {
+					g.Fail("cachecontrol:", origMethName, "is a", verb, "method, only GET and HEAD responses are cacheable")
+				}
+			}
+			cacheControl = `ctx.Header("Cache-Control", ` + strconv.Quote(val) + `)`
+		}
+
+		if noJSON {
+			if cacheControl != "" {
+				g.P(cacheControl)
+			}
+			g.P(`_ = `, retry(`h.`+methName+`(`+g.handlerContext("ctx")+`, `+user+`&input, &output)`))
+			return
+		}
+		g.P(`err := `, retry(`h.`+methName+`(`+g.handlerContext("ctx.Copy()")+`, `+user+`&input, &output)`))
+		g.P(`if err != nil {`)
+		g.P(g.errorCall(gec))
+		g.P(g.returnErr())
+		g.P(`}`)
+		g.P()
+		if cacheControl != "" {
+			g.P(cacheControl)
+		}
+		if verbs[0] == "HEAD" {
+			// HEAD responses carry the headers only; the output is left to the handler.
+			g.P(g.headCall(customAnnotations))
+		} else {
+			g.P(g.jsonCall(customAnnotations))
+		}
+	}
+
+	// The handler of a method with additional_bindings is printed once, as serve<Method>,
+	// called by the route of every binding once it has bound the input.
+	shared := len(rules) > 1
+	serveName := "serve" + methName
+	if shared {
+		for i, opts := range rules {
+			if noJSONRule(opts) != noJSONRule(rules[0]) {
+				g.Fail("additional_bindings of", origMethName, "must all have the response_body of its HTTP rule")
+			}
+			if (verbs[i] == "HEAD") != (verbs[0] == "HEAD") {
+				g.Fail("additional_bindings of", origMethName, "can't mix HEAD and other verbs, HEAD responses have no body")
+			}
+		}
+
+		g.P(`// `, serveName, ` serves `, servName, `.`, methName, ` once the route of one of its bindings has bound the input.`)
+		switch {
+		case isStreamDecode(customAnnotations):
+			g.P(serveName, ` := func(ctx *gin.Context) {`)
+		case streaming:
+			g.P(serveName, ` := func(ctx *gin.Context, input `, inType, `) {`)
+		default:
+			g.P(serveName, ` := func(ctx *gin.Context, input `, inType, `) {`)
+			g.P(`output := `, outType, `{}`)
+			g.P()
+		}
+		serve(noJSONRule(rules[0]))
+		g.P(`}`)
+		g.P()
+	}
+
+	usesBinding := false
+	for _, opts := range rules {
+		verb, url := g.httpPattern(method, opts)
+
+		// Read-only verbs carry no body and are bound from the query.
+		isGet := readOnlyVerbs[verb]
+		url = prefix + url
+
+		if val, ok := customAnnotations["trailingslash"]; ok {
+			g.generateTrailingSlash(origMethName, verb, url, val)
+		}
+
+		g.P(`// `, servName, `.`, methName, ` handles `, verb, ` `, url)
+		g.generateRoute(verb, url, middlewares)
+
+		if g.accessLog {
+			g.P(`router.AccessLog(ctx, "` + servName + `.` + methName + `", func() error {`)
+			g.inAccessLog = true
+		}
+
+		if g.metrics {
+			g.P(`defer router.Metrics(ctx, "` + servName + `", "` + methName + `")()`)
+			g.P()
+		}
+
+		if g.otel {
+			g.P(`defer router.OTelSpan(ctx, "` + servName + `/` + methName + `", map[string]string{`)
+			g.P(`"http.method": "` + verb + `",`)
+			g.P(`"http.route":  "` + url + `",`)
+			g.P(`})()`)
+			g.P()
+		}
+
+		if g.routeContext {
+			g.P(`router.SetRouteTemplate(ctx, "` + url + `")`)
+			g.P()
+		}
+
+		if logDeprecated {
+			g.P(`deprecated` + methName + `.Do(func() {`)
+			g.P(`log.Print("` + servName + `.` + methName + ` is deprecated")`)
+			g.P(`})`)
+			g.P()
+		}
+
+		if val, ok := customAnnotations["version"]; ok {
+			g.generateVersionCheck(val)
+		}
+
+		if val, ok := customAnnotations["tenant"]; ok {
+			g.generateTenant(origMethName, url, val)
+		}
+
+		switch {
+		case isStreamDecode(customAnnotations):
+			if streaming {
+				g.Fail("stream_decode:", origMethName, "can't be server-streaming")
+			}
+			if isGet {
+				g.Fail("stream_decode:", origMethName, "must be bound from a request body")
+			}
+			if g.decompressRequest {
+				g.generateDecompress(gec)
+			}
+			if shared {
+				g.P(serveName + `(ctx)`)
+			}
+		case needBind:
+			bindingMth := ""
+			bindingType := ""
+			switch strings.ToLower(binding) {
+			case "form":
+				bindingMth = "ShouldBindWith"
+				bindingType = "Form"
+			case "query":
+				bindingMth = "ShouldBindWith"
+				bindingType = "Query"
+			case "formpost":
+				bindingMth = "ShouldBindWith"
+				bindingType = "FormPost"
+			case "formmultipart":
+				bindingMth = "ShouldBindWith"
+				bindingType = "FormMultipart"
+			default:
+				bindingMth = "ShouldBindBodyWith"
+				bindingType = "JSON"
+			}
+
+			if g.contentTypeCheck && !isGet {
+				g.generateContentTypeCheck(bindingType)
+			}
+
+			if streaming || shared {
+				g.P(`input := ` + inType + `{}`)
+			} else {
+				g.P(`input, output := ` + inType + "{}, " + outType + "{}")
+			}
+			g.P()
+			if g.decompressRequest && !isGet {
+				g.generateDecompress(gec)
+			}
+			buffered := g.bufferedBind && !isGet
+			if buffered {
+				g.generateBufferedRead(gec)
+			}
+			if rawField != "" {
+				g.generateRawBody(gec, buffered)
+			}
+			if g.enumParse && (isGet || bindingType == "Query") {
+				g.generateEnumQuery(method, bindCheck, gec)
+			}
+			binder := `binding.` + bindingType
+			if strictJSON && bindingType == "JSON" {
+				binder = `router.StrictJSON`
+			}
+			bindCall := `ctx.` + bindingMth + `(&input, ` + binder + `)`
+			if isGet {
+				bindCall = `ctx.ShouldBindQuery(&input)`
+			} else if buffered && bindingType == "JSON" {
+				bindCall = binder + `.BindBody(buf.Bytes(), &input)`
+			}
+			if strings.Contains(bindCall, "binding.") {
+				usesBinding = true
+			}
+			if !bindCheck {
+				g.P(`_ = ` + bindCall)
+			} else {
+				g.P(`if err := ` + bindCall + `; err != nil {`)
+				if g.fieldErrors {
+					g.generateFieldErrors(gec)
+				}
+				g.P(g.errorCall(gec))
+				g.P(g.returnErr())
+				g.P(`}`)
+			}
+			if rawField != "" {
+				g.P(`input.` + rawField + ` = body`)
+			}
+			g.generateWildcards(method, url)
+			g.generatePathParams(method, url, gec)
+			if shared {
+				g.P()
+				g.P(serveName + `(ctx, input)`)
+			}
+		case shared:
+			g.P(serveName + `(ctx, ` + inType + `{})`)
+		default:
+			g.P(`input := ` + inType + `{}`)
+			if !streaming {
+				g.P(`var output ` + outType)
+			}
+			g.P()
+		}
+
+		if !shared {
+			serve(noJSONRule(opts))
+		}
+		if g.accessLog {
+			g.P(`return nil`)
+			g.P(`})`)
+			g.inAccessLog = false
+		}
+		g.P("})")
+		g.P()
+	}
+
+	return usesBinding
+}
+
+// errorCode returns the status errors of a method are rendered with: its errcode:<code>
+// annotation, else GEN_ERROR_CODE, else 500.
+func (g *Generator) errorCode(method *descriptor.MethodDescriptorProto, customAnnotations map[string]string) string {
+	if val, ok := customAnnotations["errcode"]; ok {
+		if code, err := strconv.Atoi(val); err != nil || code < 100 || code > 599 {
+			g.Fail("errcode:", val, "of", method.GetName(), "is not an HTTP status code")
+		}
+		return val
+	}
+
+	return defaultErrorCode()
+}
+
+// defaultErrorCode returns the status errors are rendered with when neither the method nor
+// the error says otherwise: GEN_ERROR_CODE, else 500.
+func defaultErrorCode() string {
+	if gec := os.Getenv("GEN_ERROR_CODE"); gec != "" {
+		return gec
+	}
+	return "500"
+}
+
+// generateVersionCheck rejects requests asking for another version than the method's with 406.
+// Requests without the version header get the method, taken as the latest version.
+func (g *Generator) generateVersionCheck(version string) {
+	g.addExternalImport("fmt", "")
+
+	g.P(`if v := ctx.GetHeader(` + strconv.Quote(g.versionHeader) + `); v != "" && v != ` + strconv.Quote(version) + ` {`)
+	g.P(`err := fmt.Errorf("unsupported version: %s", v)`)
+	g.P(g.errorCall("406"))
+	g.P(g.returnErr())
+	g.P(`}`)
+	g.P()
+}
+
+// generateTrailingSlash registers the form of the route path with or without its trailing
+// slash through router.TrailingSlash, redirected, stripped or rejected as the
+// trailingslash:redirect|strip|strict annotation of the method says.
+func (g *Generator) generateTrailingSlash(methName, verb, url, mode string) {
+	switch mode {
+	case "redirect", "strip", "strict":
+	default:
+		g.Fail("trailingslash:", mode, "of", methName, `is not one of "redirect", "strip" or "strict"`)
+	}
+	if url == "/" || regWildcard.MatchString(url) {
+		g.Fail("trailingslash:", methName, "has no trailing slash to normalize in", url)
+	}
+
+	g.P(`router.TrailingSlash(g, "` + verb + `", "` + g.ginPath(url) + `", "` + mode + `")`)
+	g.P()
+}
+
+// generateTenant stores the tenant of the request with router.WithTenant, read from the
+// header or the path variable named by the tenant:header:<name> or tenant:path:<name>
+// annotation. Requests without a tenant are rejected with 400, unless :optional is appended.
+func (g *Generator) generateTenant(methName, url, val string) {
+	parts := strings.Split(val, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[1] == "" || len(parts) == 3 && parts[2] != "optional" {
+		g.Fail("tenant:", val, "of", methName, "is not header:<name> or path:<name>, optionally followed by :optional")
+	}
+
+	source, name := parts[0], parts[1]
+	value := ""
+	switch source {
+	case "header":
+		value = `ctx.GetHeader(` + strconv.Quote(name) + `)`
+	case "path":
+		found := false
+		for _, m := range regPathParam.FindAllStringSubmatch(url, -1) {
+			found = found || m[1] == name && (m[2] == "" || m[2] == "*")
+		}
+		if !found {
+			g.Fail("tenant:", val, "of", methName, "names no {"+name+"} variable of", url)
+		}
+		value = `ctx.Param(` + strconv.Quote(name) + `)`
+	default:
+		g.Fail("tenant:", val, "of", methName, "must be read from a header or the path")
+	}
 
-func (g *Generator) typeName(str string) string {
-	g.RecordTypeUse(str)
-	return g.TypeName(g.ObjectNamed(str))
-}
+	g.P(`if tenant := ` + value + `; tenant != "" {`)
+	g.P(`router.WithTenant(ctx, tenant)`)
+	if len(parts) == 2 {
+		g.addExternalImport("errors", "")
 
-func (g *Generator) generateClientSignature(reqServ, servName string, method *descriptor.MethodDescriptorProto) string {
-	origMethName := method.GetName()
-	methName := CamelCase(origMethName)
-	if reservedClientName[methName] {
-		methName += "_"
+		g.P(`} else {`)
+		g.P(`err := errors.New(` + strconv.Quote("missing tenant: "+name+" "+source) + `)`)
+		g.P(g.errorCall("400"))
+		g.P(g.returnErr())
 	}
+	g.P(`}`)
+	g.P()
+}
 
-	g.RecordTypeUse(method.GetInputType())
+// generateContentTypeCheck rejects requests whose body is not of the content type the
+// binding expects with 415. Requests without a Content-Type are let through as bodyless.
+func (g *Generator) generateContentTypeCheck(bindingType string) {
+	var allowed []string
+	switch bindingType {
+	case "Query":
+		return
+	case "Form":
+		allowed = []string{"application/x-www-form-urlencoded", "multipart/form-data"}
+	case "FormPost":
+		allowed = []string{"application/x-www-form-urlencoded"}
+	case "FormMultipart":
+		allowed = []string{"multipart/form-data"}
+	default:
+		allowed = []string{"application/json"}
+	}
 
-	in := g.typeName(method.GetInputType())
+	g.addExternalImport("fmt", "")
 
-	if in == "types.Empty" || in == "empty.Empty" {
-		in = "router.Empty"
+	cond := `ct != ""`
+	for _, t := range allowed {
+		cond += ` && ct != "` + t + `"`
 	}
 
-	input := ", in *" + in
-	outName := g.typeName(method.GetOutputType())
-	output := ", out *" + outName
+	g.P(`if ct := ctx.ContentType(); ` + cond + ` {`)
+	g.P(`err := fmt.Errorf("unsupported content type: %s", ct)`)
+	g.P(g.errorCall("415"))
+	g.P(g.returnErr())
+	g.P(`}`)
+	g.P()
+}
 
-	return fmt.Sprintf("%s(ctx *gin.Context%s%s) error", methName, input, output)
+// readOnlyVerbs lists the HTTP methods whose input is bound from the query rather than the body.
+var readOnlyVerbs = map[string]bool{
+	"GET":     true,
+	"DELETE":  true,
+	"HEAD":    true,
+	"OPTIONS": true,
 }
 
-func (g *Generator) generateClientMethod(reqServ, servName string, method *descriptor.MethodDescriptorProto, customAnnotations map[string]string) bool {
-	gec := os.Getenv("GEN_ERROR_CODE")
-	if gec == "" {
-		gec = "500"
-	}
+// ginVerbs lists the HTTP methods gin has a registration shortcut for.
+var ginVerbs = map[string]bool{
+	"GET":     true,
+	"POST":    true,
+	"PUT":     true,
+	"DELETE":  true,
+	"PATCH":   true,
+	"HEAD":    true,
+	"OPTIONS": true,
+}
 
-	origMethName := method.GetName()
-	methName := CamelCase(origMethName)
-	if reservedClientName[methName] {
-		methName += "_"
+// generateRoute prints the opening of the route registration, behind the named middlewares if any.
+func (g *Generator) generateRoute(httpMethod, url string, middlewares []string) {
+	url = regWildcard.ReplaceAllString(g.ginPath(url), "*$1")
+	switch {
+	case len(middlewares) == 0 && ginVerbs[httpMethod]:
+		g.P(`g.` + httpMethod + `("` + url + `", func(ctx *gin.Context) {`)
+	case len(middlewares) == 0:
+		g.P(`g.Handle("` + httpMethod + `", "` + url + `", func(ctx *gin.Context) {`)
+	case g.sharedChains:
+		g.P(`router.HandleChain(g, "` + httpMethod + `", "` + url + `", ` + g.chains[strings.Join(middlewares, ",")] + `, func(ctx *gin.Context) {`)
+	case g.middlewareFuncs:
+		g.P(`router.HandleChain(g, "` + httpMethod + `", "` + url + `", ` + g.middlewareChain(middlewares) + `, func(ctx *gin.Context) {`)
+	default:
+		g.P(`router.Handle(g, "` + httpMethod + `", "` + url + `", []string{"` + strings.Join(middlewares, `","`) + `"}, func(ctx *gin.Context) {`)
 	}
+}
 
-	needBind := true
+// middlewareChainAlias is the name under which middleware_pkg is imported.
+const middlewareChainAlias = "mw"
 
-	inType := g.typeName(method.GetInputType())
-	if inType == "types.Empty" || inType == "empty.Empty" {
-		inType = "router.Empty"
-		needBind = false
-	} else {
-		for _, desc := range g.file.desc {
-			if desc.GetOptions().GetMapEntry() {
-				continue
-			}
+// middlewareChain returns the gin.HandlersChain literal referencing the named middlewares as
+// the functions of middleware_pkg, e.g. rate_limit as mw.RateLimit, so that a missing
+// middleware fails to compile rather than at request time.
+func (g *Generator) middlewareChain(middlewares []string) string {
+	g.addExternalImport(GoImportPath(g.middlewarePkg), middlewareChainAlias)
 
-			if goTypeName := CamelCaseSlice(desc.TypeName()); goTypeName == inType {
-				if len(desc.Field) == 0 {
-					needBind = false
-				}
+	funcs := make([]string, len(middlewares))
+	for i, name := range middlewares {
+		funcs[i] = middlewareChainAlias + "." + CamelCase(strings.TrimSpace(name))
+	}
+	return `gin.HandlersChain{` + strings.Join(funcs, ", ") + `}`
+}
 
-				break
-			}
+// generateChains prints one router.Chain per distinct middleware set of the service's methods
+// and records the variable holding it for generateRoute.
+func (g *Generator) generateChains(methodAnnotations []map[string]string) {
+	g.chains = make(map[string]string)
+	for _, customAnnotations := range methodAnnotations {
+		val, ok := customAnnotations["middleware"]
+		if !ok {
+			continue
+		}
+		if _, ok := g.chains[val]; ok {
+			continue
+		}
+
+		name := "chain" + strconv.Itoa(len(g.chains)+1)
+		g.chains[val] = name
+		if g.middlewareFuncs {
+			g.P(name + ` := ` + g.middlewareChain(strings.Split(val, ",")))
+			continue
 		}
+		g.P(name + ` := router.Chain("` + strings.Join(strings.Split(val, ","), `", "`) + `")`)
 	}
+	if len(g.chains) > 0 {
+		g.P()
+	}
+}
 
-	outType := g.typeName(method.GetOutputType())
-	if strings.HasPrefix(outType, reqServ+".") {
-		outType = strings.TrimPrefix(outType, reqServ+".")
+// generateCursor prints the decoding of the input's page_token into a cursorType with
+// router.DecodeCursor, stored on the request for the handler to read with router.Cursor.
+func (g *Generator) generateCursor(method *descriptor.MethodDescriptorProto, cursorType string) {
+	message, ok := g.ObjectNamed(method.GetInputType()).(*Descriptor)
+	if !ok {
+		g.Fail("cursor: input of", method.GetName(), "is not a message")
+	}
+	field := g.fieldByName(message, "page_token")
+	if field == nil || field.GetType() != descriptor.FieldDescriptorProto_TYPE_STRING || isRepeated(field) {
+		g.Fail("cursor:", message.GetName(), "has no string page_token field")
 	}
 
-	isGet := false
-	noJSON := false
+	g.P(`if cursor, err := router.DecodeCursor[` + cursorType + `](input.` + g.fieldGoName(message, field) + `); err == nil {`)
+	g.P(`router.SetCursor(ctx, cursor)`)
+	g.P(`} else {`)
+	g.P(g.errorCall("400"))
+	g.P(g.returnErr())
+	g.P(`}`)
+	g.P()
+}
 
-	middlewares := []string{}
-	if val, ok := customAnnotations["middleware"]; ok {
-		middlewares = strings.Split(val, ",")
+// jsonCall returns the statement rendering the output on success, with the status
+// of a status:<code> annotation or router.JSON's 200.
+func (g *Generator) jsonCall(customAnnotations map[string]string) string {
+	val, ok := g.successStatus(customAnnotations)
+	if !ok {
+		return `router.JSON(ctx, &output)`
 	}
+	return `router.JSONStatus(ctx, ` + val + `, &output)`
+}
 
-	bindCheck := true
-	if val, ok := customAnnotations["bindcheck"]; ok && strings.EqualFold(val, "false") {
-		bindCheck = false
+// headCall returns the call answering a HEAD request: the status alone, without a body.
+func (g *Generator) headCall(customAnnotations map[string]string) string {
+	if val, ok := g.successStatus(customAnnotations); ok {
+		return `ctx.Status(` + val + `)`
 	}
+	return `ctx.Status(200)`
+}
 
-	binding := "json"
-	if val, ok := customAnnotations["binding"]; ok {
-		binding = val
+// successStatus returns the status:<code> annotation of a method, if any.
+func (g *Generator) successStatus(customAnnotations map[string]string) (string, bool) {
+	val, ok := customAnnotations["status"]
+	if !ok {
+		return "", false
 	}
 
-	if method.Options != nil && proto.HasExtension(method.Options, annotations.E_Http) {
-		ext, _ := proto.GetExtension(method.Options, annotations.E_Http)
-		if opts, ok := ext.(*annotations.HttpRule); ok {
-			if getapi, ok := opts.Pattern.(*annotations.HttpRule_Get); ok {
-				isGet = true
-				url := getapi.Get
-
-				if len(middlewares) > 0 {
-					g.P(`router.Handle(g, "GET", "` + url + `", []string{"` + strings.Join(middlewares, `","`) + `"}, func(ctx *gin.Context) {`)
-				} else {
-					g.P(`g.GET("` + url + `", func(ctx *gin.Context) {`)
-				}
-			}
+	if code, err := strconv.Atoi(val); err != nil || code < 200 || code > 299 {
+		g.Fail("status:", val, "is not a success status code")
+	}
+	return val, true
+}
 
-			if postapi, ok := opts.Pattern.(*annotations.HttpRule_Post); ok {
-				url := postapi.Post
+// isStreamDecode reports whether a method is annotated stream_decode:true.
+func isStreamDecode(customAnnotations map[string]string) bool {
+	return strings.EqualFold(customAnnotations["stream_decode"], "true")
+}
 
-				if len(middlewares) > 0 {
-					g.P(`router.Handle(g, "POST", "` + url + `", []string{"` + strings.Join(middlewares, `","`) + `"}, func(ctx *gin.Context) {`)
-				} else {
-					g.P(`g.POST("` + url + `", func(ctx *gin.Context) {`)
-				}
-			}
+// generateStreamDecode prints the rest of a handler decoding the body as a JSON array with
+// router.StreamDecode and calling the handler per element, all sharing one output.
+func (g *Generator) generateStreamDecode(methName, inType, outType, code string, customAnnotations map[string]string) {
+	g.P(`var output ` + outType)
+	g.P()
 
-			if opts.ResponseBody != "" && opts.ResponseBody != "json" {
-				noJSON = true
-			}
-		}
-	} else {
-		g.Fail("option google.api.http not found")
+	user := ""
+	if g.needAuthUser(customAnnotations) {
+		g.P(`user := router.User(ctx)`)
+		g.P()
+		user = "user, "
 	}
 
-	if needBind {
-		bindingMth := ""
-		bindingType := ""
-		switch strings.ToLower(binding) {
-		case "form":
-			bindingMth = "ShouldBindWith"
-			bindingType = "Form"
-		case "query":
-			bindingMth = "ShouldBindWith"
-			bindingType = "Query"
-		case "formpost":
-			bindingMth = "ShouldBindWith"
-			bindingType = "FormPost"
-		case "formmultipart":
-			bindingMth = "ShouldBindWith"
-			bindingType = "FormMultipart"
-		default:
-			bindingMth = "ShouldBindBodyWith"
-			bindingType = "JSON"
-		}
+	g.P(`err := router.StreamDecode(ctx, func(input *` + inType + `) error {`)
+	g.P(`return h.` + methName + `(` + g.handlerContext("ctx.Copy()") + `, ` + user + `input, &output)`)
+	g.P(`})`)
+	g.P(`if err != nil {`)
+	g.P(g.errorCall(code))
+	g.P(g.returnErr())
+	g.P(`}`)
+	g.P()
+	g.P(g.jsonCall(customAnnotations))
+}
 
-		g.P(`input, output := ` + inType + "{}, " + outType + "{}")
-		g.P()
-		if !bindCheck {
-			if isGet {
-				g.P(`_ = ctx.ShouldBindQuery(&input)`)
-			} else {
-				g.P(`_ = ctx.` + bindingMth + `(&input, binding.` + bindingType + `)`)
-			}
-		} else {
-			if isGet {
-				g.P(`if err := ctx.ShouldBindQuery(&input); err != nil {`)
-			} else {
-				g.P(`if err := ctx.` + bindingMth + `(&input, binding.` + bindingType + `); err != nil {`)
-			}
-			g.P(`router.Error(ctx, ` + gec + `, err)`)
-			g.P(`return`)
-			g.P(`}`)
+// idempotentVerbs lists the HTTP methods whose requests can be repeated without further effect.
+var idempotentVerbs = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"OPTIONS": true,
+	"PUT":     true,
+	"DELETE":  true,
+}
+
+// retryCall wraps the handler call in router.Retry for methods annotated retry:<attempts>,
+// clearing the output before every attempt and giving up once the request is canceled.
+// Only idempotent verbs may be retried.
+func (g *Generator) retryCall(methName string, verbs []string, val, outType, call string) string {
+	if n, err := strconv.Atoi(val); err != nil || n < 1 {
+		g.Fail("retry:", val, "of", methName, "is not a number of attempts")
+	}
+	for _, verb := range verbs {
+		if !idempotentVerbs[verb] {
+			g.Fail("retry:", methName, "is a", verb, "method, only idempotent verbs can be retried")
 		}
-		g.P()
-	} else {
-		g.P(`input := ` + inType + `{}`)
-		g.P(`var output ` + outType)
-		g.P()
 	}
 
-	if noJSON {
-		g.P(`_ = h.` + methName + `(ctx, &input, &output)`)
-	} else {
-		g.P(`err := h.` + methName + `(ctx.Copy(), &input, &output)`)
-		g.P(`if err != nil {`)
-		g.P(`router.Error(ctx, ` + gec + `, err)`)
-		g.P(`return`)
-		g.P(`}`)
-		g.P()
-		g.P(`router.JSON(ctx, &output)`)
-	}
-	g.P("})")
-	g.P()
+	return "router.Retry(ctx.Request.Context(), " + val + ", func() error {\n" +
+		"output = " + outType + "{}\n" +
+		"return " + call + "\n" +
+		"})"
+}
 
-	return needBind
+// generateServerStream prints the call of a server-streaming handler, sending its messages
+// as server-sent events through router.SSE. An error returned before the first message is
+// rendered as usual, one returned afterwards ends the stream with an error event.
+func (g *Generator) generateServerStream(methName, outType, user, code string) {
+	g.P(`if err := h.` + methName + `(` + g.handlerContext("ctx.Copy()") + `, ` + user + `&input, router.SSE[` + outType + `](ctx)); err != nil {`)
+	g.P(`if ctx.Writer.Written() {`)
+	g.P(`router.SSEError(ctx, ` + code + `, err)`)
+	g.P(g.returnErr())
+	g.P(`}`)
+	g.P(g.errorCall(code))
+	g.P(g.returnErr())
+	g.P(`}`)
 }
 
 // Fill the response protocol buffer with the generated output for all the files we're
 // supposed to generateModelFile.
 func (g *Generator) generateModelFile(file *FileDescriptor) {
 	g.file = file
+	g.outputImportPath = GoImportPath(file.GetName())
 	g.usedPackages = make(map[GoImportPath]bool)
 	g.packageNames = make(map[GoImportPath]GoPackageName)
 	g.usedPackageNames = make(map[GoPackageName]bool)
 	g.addedImports = make(map[GoImportPath]bool)
+	g.externalImports = make(map[GoImportPath]GoPackageName)
 	for name := range globalPackageNames {
 		g.usedPackageNames[name] = true
 	}
@@ -893,27 +3017,7 @@ func (g *Generator) generateModelFile(file *FileDescriptor) {
 	}
 	g.Write(rem.Bytes())
 
-	// Reformat generated code and patch annotation locations.
-	fset := token.NewFileSet()
-	original := g.Bytes()
-	fileAST, err := parser.ParseFile(fset, "", original, parser.ParseComments)
-	if err != nil {
-		// Print out the bad code with line numbers.
-		// This should never happen in practice, but it can while changing generated code,
-		// so consider this a debugging aid.
-		var src bytes.Buffer
-		s := bufio.NewScanner(bytes.NewReader(original))
-		for line := 1; s.Scan(); line++ {
-			fmt.Fprintf(&src, "%5d\t%s\n", line, s.Bytes())
-		}
-		g.Fail("bad Go source code was generated:", err.Error(), "\n"+src.String())
-	}
-	ast.SortImports(fset, fileAST)
-	g.Reset()
-	err = (&printer.Config{Mode: printer.TabIndent | printer.UseSpaces, Tabwidth: 8}).Fprint(g, fset, fileAST)
-	if err != nil {
-		g.Fail("generated Go source code could not be reformatted:", err.Error())
-	}
+	g.reformat()
 }
 
 // Generate the header, including package definition
@@ -951,21 +3055,74 @@ func (g *Generator) PrintComments(path string) bool {
 	return false
 }
 
-// makeComments generates the comment string for the field, no "\n" at the end
+// makeComments generates the comment string for the field, no "\n" at the end.
+// The trailing comment, written on the line of the declaration, follows the leading one.
+// With comment_style=block the lines are enclosed in a single /* */ comment.
 func (g *Generator) makeComments(path string) (string, bool) {
 	loc, ok := g.file.comments[path]
 	if !ok {
 		return "", false
 	}
 	w := new(bytes.Buffer)
-	nl := ""
-	for _, line := range strings.Split(strings.TrimSuffix(loc.GetLeadingComments(), "\n"), "\n") {
-		fmt.Fprintf(w, "%s//%s", nl, line)
-		nl = "\n"
+	marker, nl := "//", ""
+	if g.blockComments {
+		marker, nl = "", "\n"
+		w.WriteString("/*")
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(commentText(loc), "\n"), "\n") {
+		for _, l := range wrapComment(line, g.commentWrap) {
+			if g.blockComments {
+				l = strings.Replace(l, "*/", "* /", -1)
+			}
+			fmt.Fprintf(w, "%s%s%s", nl, marker, l)
+			nl = "\n"
+		}
+	}
+	if g.blockComments {
+		w.WriteString("\n*/")
 	}
 	return w.String(), true
 }
 
+// commentText returns the leading comment of loc followed by its trailing comment.
+func commentText(loc *descriptor.SourceCodeInfo_Location) string {
+	return loc.GetLeadingComments() + loc.GetTrailingComments()
+}
+
+// wrapComment splits a comment line so that, with its // marker, no part is longer than width
+// unless a single word is. Text inside backquotes is kept together. Annotation lines and
+// indented (code) lines are returned unchanged, as is everything when width is 0.
+func wrapComment(line string, width int) []string {
+	text := strings.TrimLeft(line, " ")
+	lead := line[:len(line)-len(text)]
+	if width <= 0 || len("//"+line) <= width || len(lead) > 1 || strings.HasPrefix(text, "\t") || strings.Contains(text, "@tag") {
+		return []string{line}
+	}
+
+	var words []string
+	for _, word := range strings.Fields(text) {
+		if n := len(words); n > 0 && strings.Count(words[n-1], "`")%2 == 1 {
+			words[n-1] += " " + word
+			continue
+		}
+		words = append(words, word)
+	}
+
+	var lines []string
+	cur := ""
+	for _, word := range words {
+		if cur != "" && len("//"+lead+cur+" "+word) > width {
+			lines = append(lines, lead+cur)
+			cur = ""
+		}
+		if cur != "" {
+			cur += " "
+		}
+		cur += word
+	}
+	return append(lines, lead+cur)
+}
+
 func (g *Generator) fileByName(filename string) *FileDescriptor {
 	return g.allFilesByName[filename]
 }
@@ -1017,7 +3174,7 @@ func (g *Generator) generateImports(typ string, hasBinding bool) {
 	// do, which is tricky when there's a plugin, just import it and
 	// reference it later. The same argument applies to the fmt and math packages.
 
-	if typ == "model" {
+	if typ == "model" || typ == "iface" || typ == "fuzz" {
 		g.generateModelImports(imports)
 	} else {
 		g.generateApiImports(imports, hasBinding)
@@ -1025,84 +3182,344 @@ func (g *Generator) generateImports(typ string, hasBinding bool) {
 }
 
 func (g *Generator) generateModelImports(imports map[GoPackageName]GoPackageName) {
-	if len(imports) == 0 {
+	if len(imports) == 0 && len(g.externalImports) == 0 {
+		return
+	}
+
+	g.P("import (")
+	g.printExternalImports(true)
+	g.printExternalImports(false)
+	g.printProtoImports(imports)
+	g.P(")")
+	g.P()
+	g.P()
+}
+
+// printProtoImports prints the imports of the packages generated for other proto files,
+// sorted by path so that the output doesn't depend on map iteration order.
+func (g *Generator) printProtoImports(imports map[GoPackageName]GoPackageName) {
+	paths := make([]string, 0, len(imports))
+	for importPath := range imports {
+		paths = append(paths, g.ImportPrefix+string(importPath))
+	}
+	sort.Strings(paths)
+
+	for _, importPath := range paths {
+		g.P(`"` + importPath + `"`)
+	}
+}
+
+func (g *Generator) generateApiImports(imports map[GoPackageName]GoPackageName, hasBinding bool) {
+	g.P("import (")
+	g.printExternalImports(true)
+	g.P(`"github.com/gin-gonic/gin"`)
+	if hasBinding {
+		g.P(`"github.com/gin-gonic/gin/binding"`)
+	}
+	g.P()
+	g.P(`"`, g.Param["repo"], `/router"`)
+	g.printExternalImports(false)
+	g.printProtoImports(imports)
+	g.P(")")
+	g.P()
+	g.P()
+}
+
+func (g *Generator) generateImported(id *ImportedDescriptor) {
+	df := id.o.File()
+	filename := *df.Name
+
+	if df.importPath == g.file.importPath && *df.Package == *g.file.Package {
 		return
 	}
 
-	g.P("import (")
-	for importPath := range imports {
-		g.P(`"` + g.ImportPrefix + string(importPath) + `"`)
-	}
+	// With import_mode=reference the types are used from their own package, without alias.
+	if g.importReference {
+		return
+	}
+
+	// Imports are keyed by proto file name, as in DefaultPackageName.
+	importPath := GoImportPath(filename)
+	g.usedPackages[importPath] = true
+
+	for _, sym := range df.exported[id.o] {
+		sym.GenerateAlias(g, filename, g.GoPackageName(importPath))
+	}
+
+	g.P()
+}
+
+// Generate the enum definitions for this EnumDescriptor.
+func (g *Generator) generateEnum(enum *EnumDescriptor) {
+	// The full type name
+	typeName := enum.TypeName()
+	// The full type name, CamelCased.
+	ccTypeName := CamelCaseSlice(typeName)
+	ccPrefix := enum.prefix()
+	g.warnExported(CamelCase(strings.Join(typeName, "_")))
+
+	deprecatedEnum := ""
+	if enum.GetOptions().GetDeprecated() {
+		deprecatedEnum = deprecationComment
+	}
+	g.PrintComments(enum.path)
+	g.P("type ", Annotate(enum.file, enum.path, ccTypeName), " int32", deprecatedEnum)
+	g.file.addExport(enum, enumSymbol{ccTypeName, enum.proto3(), g.lazyEnumMaps})
+	codes := make(map[string]string) // external code per constant name
+	g.P("const (")
+	for i, e := range enum.Value {
+		etorPath := fmt.Sprintf("%s,%d,%d", enum.path, enumValuePath, i)
+		g.PrintComments(etorPath)
+		if cs, ok := g.makeComments(etorPath); ok {
+			if code, ok := parseAnnotations(cs)["code"]; ok {
+				codes[ccPrefix+e.GetName()] = code
+			}
+		}
+
+		deprecatedValue := ""
+		if e.GetOptions().GetDeprecated() {
+			deprecatedValue = deprecationComment
+		}
+
+		name := ccPrefix + *e.Name
+		g.P(Annotate(enum.file, etorPath, name), " ", ccTypeName, " = ", e.Number, " ", deprecatedValue)
+		g.file.addExport(enum, constOrVarSymbol{name, "const", ccTypeName})
+	}
+	g.P(")")
+	g.P()
+
+	if g.lazyEnumMaps {
+		g.generateLazyEnumMaps(enum, ccTypeName)
+	} else {
+		g.P("var ", ccTypeName, "_name = map[int32]string{")
+		g.generateEnumNames(enum)
+		g.P("}")
+		g.P()
+		g.P("var ", ccTypeName, "_value = map[string]int32{")
+		g.generateEnumValues(enum)
+		g.P("}")
+		g.P()
+	}
+
+	if cs, ok := g.makeComments(enum.path); ok && parseAnnotations(cs)["flags"] == "true" {
+		g.generateEnumFlags(enum, ccTypeName)
+	}
+
+	if g.enumParse {
+		g.generateEnumParse(ccTypeName)
+	}
+
+	if len(codes) > 0 {
+		g.generateEnumCodes(enum, ccTypeName, codes)
+	}
+
+	if g.pbEnumConvert {
+		g.generatePBEnumConvert(ccTypeName)
+	}
+}
+
+// generateEnumNames prints the entries of the number to name map of an enum.
+func (g *Generator) generateEnumNames(enum *EnumDescriptor) {
+	generated := make(map[int32]bool) // avoid duplicate values
+	for _, e := range enum.Value {
+		duplicate := ""
+		if _, present := generated[*e.Number]; present {
+			duplicate = "// Duplicate value: "
+		}
+		g.P(duplicate, e.Number, ": ", strconv.Quote(*e.Name), ",")
+		generated[*e.Number] = true
+	}
+}
+
+// generateEnumValues prints the entries of the name to number map of an enum.
+func (g *Generator) generateEnumValues(enum *EnumDescriptor) {
+	for _, e := range enum.Value {
+		g.P(strconv.Quote(*e.Name), ": ", e.Number, ",")
+	}
+}
+
+// generateLazyEnumMaps prints, with lazy_enum_maps=true, the XxxName and XxxValue accessors
+// standing for the Xxx_name and Xxx_value maps, built by the first call to either.
+func (g *Generator) generateLazyEnumMaps(enum *EnumDescriptor, ccTypeName string) {
+	for _, desc := range g.file.desc {
+		if name := CamelCaseSlice(desc.TypeName()); name == ccTypeName+"Name" || name == ccTypeName+"Value" {
+			g.Fail("lazy_enum_maps: accessors of", ccTypeName, "collide with message", name)
+		}
+	}
+
+	g.addExternalImport("sync", "")
+
+	once, names, values := "lazy"+ccTypeName+"_once", "lazy"+ccTypeName+"_name", "lazy"+ccTypeName+"_value"
+
+	g.P("var (")
+	g.P(once, " sync.Once")
+	g.P(names, " map[int32]string")
+	g.P(values, " map[string]int32")
 	g.P(")")
 	g.P()
+	g.P("func init", ccTypeName, "Maps() {")
+	g.P(names, " = map[int32]string{")
+	g.generateEnumNames(enum)
+	g.P("}")
+	g.P(values, " = map[string]int32{")
+	g.generateEnumValues(enum)
+	g.P("}")
+	g.P("}")
+	g.P()
+	g.P("// ", ccTypeName, "Name returns the proto name of the ", ccTypeName, " numbered v, or \"\" if there is none.")
+	g.P("func ", ccTypeName, "Name(v int32) string {")
+	g.P(once, ".Do(init", ccTypeName, "Maps)")
+	g.P("return ", names, "[v]")
+	g.P("}")
+	g.P()
+	g.P("// ", ccTypeName, "Value returns the number of the ", ccTypeName, " named s, and whether there is one.")
+	g.P("func ", ccTypeName, "Value(s string) (int32, bool) {")
+	g.P(once, ".Do(init", ccTypeName, "Maps)")
+	g.P("v, ok := ", values, "[s]")
+	g.P("return v, ok")
+	g.P("}")
 	g.P()
 }
 
-func (g *Generator) generateApiImports(imports map[GoPackageName]GoPackageName, hasBinding bool) {
-	g.P("import (")
-	g.P(`"github.com/gin-gonic/gin"`)
-	if hasBinding {
-		g.P(`"github.com/gin-gonic/gin/binding"`)
+// enumValueLookup returns the comma-ok expression looking up the number of the value of the
+// enum typeName named by the expression s.
+func (g *Generator) enumValueLookup(typeName, s string) string {
+	if g.lazyEnumMaps {
+		return typeName + "Value(" + s + ")"
+	}
+	return typeName + "_value[" + s + "]"
+}
+
+// generateEnumCodes prints the maps between the values of an enum and the external codes
+// given by code:<code> annotations on them, with the ToCode and FromCode methods using them.
+func (g *Generator) generateEnumCodes(enum *EnumDescriptor, ccTypeName string, codes map[string]string) {
+	g.addExternalImport("fmt", "")
+
+	ccPrefix := enum.prefix()
+	recv := g.receiverName(ccTypeName)
+
+	g.P("var ", ccTypeName, "_code = map[", ccTypeName, "]string{")
+	seen := make(map[int32]bool)
+	for _, e := range enum.Value {
+		code, ok := codes[ccPrefix+e.GetName()]
+		if !ok || seen[e.GetNumber()] {
+			continue
+		}
+		seen[e.GetNumber()] = true
+		g.P(ccPrefix+e.GetName(), ": ", strconv.Quote(code), ",")
 	}
+	g.P("}")
 	g.P()
-	g.P(`"`, g.Param["repo"], `/router"`)
-	for importPath := range imports {
-		g.P(`"` + g.ImportPrefix + string(importPath) + `"`)
+	g.P("var ", ccTypeName, "_fromCode = map[string]", ccTypeName, "{")
+	used := make(map[string]bool)
+	for _, e := range enum.Value {
+		code, ok := codes[ccPrefix+e.GetName()]
+		if !ok {
+			continue
+		}
+		if used[code] {
+			g.Fail("code:", code, "is used by several values of", ccTypeName)
+		}
+		used[code] = true
+		g.P(strconv.Quote(code), ": ", ccPrefix+e.GetName(), ",")
 	}
-	g.P(")")
+	g.P("}")
+	g.P()
+	g.P("// ToCode returns the external code of the value, or \"\" if it has none.")
+	g.P("func (", recv, " ", ccTypeName, ") ToCode() string {")
+	g.P("return ", ccTypeName, "_code[", recv, "]")
+	g.P("}")
 	g.P()
+	g.P("// FromCode sets the value to the one with the external code.")
+	g.P("func (", recv, " *", ccTypeName, ") FromCode(code string) error {")
+	g.P("v, ok := ", ccTypeName, "_fromCode[code]")
+	g.P("if !ok {")
+	g.P(`return fmt.Errorf("unknown `, ccTypeName, ` code %q", code)`)
+	g.P("}")
+	g.P()
+	g.P("*", recv, " = v")
+	g.P("return nil")
+	g.P("}")
 	g.P()
 }
 
-func (g *Generator) generateImported(id *ImportedDescriptor) {
-	df := id.o.File()
-	filename := *df.Name
-
-	if df.importPath == g.file.importPath && *df.Package == *g.file.Package {
-		return
-	}
-
-	g.usedPackages[df.importPath] = true
-
-	for _, sym := range df.exported[id.o] {
-		sym.GenerateAlias(g, filename, g.GoPackageName(df.importPath))
-	}
+// generateEnumParse prints ParseXxx, converting the proto name of a value back to the enum
+// through Xxx_value, or XxxValue with lazy_enum_maps=true. The names are matched exactly.
+func (g *Generator) generateEnumParse(ccTypeName string) {
+	g.addExternalImport("fmt", "")
 
+	g.P("// Parse", ccTypeName, " returns the ", ccTypeName, " value named s.")
+	g.P("func Parse", ccTypeName, "(s string) (", ccTypeName, ", error) {")
+	g.P("if v, ok := ", g.enumValueLookup(ccTypeName, "s"), "; ok {")
+	g.P("return ", ccTypeName, "(v), nil")
+	g.P("}")
+	g.P()
+	g.P(`return 0, fmt.Errorf("unknown `, ccTypeName, ` %q", s)`)
+	g.P("}")
 	g.P()
 }
 
-// Generate the enum definitions for this EnumDescriptor.
-func (g *Generator) generateEnum(enum *EnumDescriptor) {
-	// The full type name
-	typeName := enum.TypeName()
-	// The full type name, CamelCased.
-	ccTypeName := CamelCaseSlice(typeName)
+// generateEnumFlags prints the helpers of an enum annotated with flags:true, whose values
+// are bits of a mask. Every value must be zero or a distinct power of two.
+func (g *Generator) generateEnumFlags(enum *EnumDescriptor, ccTypeName string) {
 	ccPrefix := enum.prefix()
-
-	deprecatedEnum := ""
-	if enum.GetOptions().GetDeprecated() {
-		deprecatedEnum = deprecationComment
+	seen := make(map[int32]bool)
+	for _, e := range enum.Value {
+		n := e.GetNumber()
+		if n < 0 || n&(n-1) != 0 || seen[n] {
+			g.Fail("flags:", ccTypeName, "value", e.GetName(), "is not a distinct power of two")
+		}
+		seen[n] = true
 	}
-	g.PrintComments(enum.path)
-	g.P("type ", Annotate(enum.file, enum.path, ccTypeName), " int32", deprecatedEnum)
-	g.file.addExport(enum, enumSymbol{ccTypeName, enum.proto3()})
-	g.P("const (")
-	for i, e := range enum.Value {
-		etorPath := fmt.Sprintf("%s,%d,%d", enum.path, enumValuePath, i)
-		g.PrintComments(etorPath)
 
-		deprecatedValue := ""
-		if e.GetOptions().GetDeprecated() {
-			deprecatedValue = deprecationComment
-		}
+	g.addExternalImport("strconv", "")
+	g.addExternalImport("strings", "")
 
-		name := ccPrefix + *e.Name
-		g.P(Annotate(enum.file, etorPath, name), " ", ccTypeName, " = ", e.Number, " ", deprecatedValue)
-		g.file.addExport(enum, constOrVarSymbol{name, "const", ccTypeName})
+	recv := g.receiverName(ccTypeName)
+
+	g.P("// Has reports whether all flags of v are set.")
+	g.P("func (", recv, " ", ccTypeName, ") Has(v ", ccTypeName, ") bool {")
+	g.P("return ", recv, "&v == v")
+	g.P("}")
+	g.P()
+	g.P("// Set returns the mask with the flags of v set.")
+	g.P("func (", recv, " ", ccTypeName, ") Set(v ", ccTypeName, ") ", ccTypeName, " {")
+	g.P("return ", recv, " | v")
+	g.P("}")
+	g.P()
+	g.P("// Clear returns the mask with the flags of v cleared.")
+	g.P("func (", recv, " ", ccTypeName, ") Clear(v ", ccTypeName, ") ", ccTypeName, " {")
+	g.P("return ", recv, " &^ v")
+	g.P("}")
+	g.P()
+	g.P("// String returns the names of the set flags joined by |.")
+	g.P("func (", recv, " ", ccTypeName, ") String() string {")
+	for _, e := range enum.Value {
+		if e.GetNumber() == 0 {
+			g.P("if ", recv, " == 0 {")
+			g.P("return ", strconv.Quote(e.GetName()))
+			g.P("}")
+			g.P()
+		}
 	}
-	g.P(")")
+	g.P("names := []string{}")
+	g.P("rest := ", recv)
+	for _, e := range enum.Value {
+		if e.GetNumber() == 0 {
+			continue
+		}
+		name := ccPrefix + e.GetName()
+		g.P("if ", recv, "&", name, " != 0 {")
+		g.P("names = append(names, ", strconv.Quote(e.GetName()), ")")
+		g.P("rest &^= ", name)
+		g.P("}")
+	}
+	g.P("if rest != 0 || len(names) == 0 {")
+	g.P("names = append(names, strconv.Itoa(int(rest)))")
+	g.P("}")
+	g.P("return strings.Join(names, \"|\")")
+	g.P("}")
 	g.P()
-	g.generateEnumRegistration(enum)
 }
 
 // TypeName is the printed name appropriate for an item. If the object is in the current file,
@@ -1142,23 +3559,27 @@ func (g *Generator) GoType(serviceName string, message *Descriptor, field *descr
 		desc := g.ObjectNamed(field.GetTypeName())
 		typ, wire = "*"+g.TypeName(desc), "group"
 	case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
-		desc := g.ObjectNamed(field.GetTypeName())
-
-		typName := "*" + g.TypeName(desc)
-
-		if typName == "*anypb.Any" || typName == "*any.Any" || typName == "*_struct.Value" || typName == "*struct.Values" {
-			typName = "interface{}"
-		}
-
-		if typName == "*struct.Struct" || typName == "*_struct.Struct" {
-			typName = "map[string]interface{}"
+		// Wrappers are nullable scalars, already pointers.
+		if wrapper, ok := wrapperTypes[field.GetTypeName()]; ok {
+			typ, wire = wrapper, "bytes"
+			if isRepeated(field) {
+				typ = "[]" + typ
+			}
+			return
 		}
 
-		if typName == "*struct.ListValue" || typName == "*_struct.ListValue" {
-			typName = "[]interface{}"
+		// Dynamic values are the Go types encoding/json decodes them to, whatever the
+		// name of their Go package. Repeated fields are slices of them.
+		if dynamic, ok := dynamicTypes[field.GetTypeName()]; ok {
+			typ, wire = dynamic, "bytes"
+			if isRepeated(field) {
+				typ = "[]" + typ
+			}
+			return
 		}
 
-		typ, wire = typName, "bytes"
+		desc := g.ObjectNamed(field.GetTypeName())
+		typ, wire = "*"+g.TypeName(desc), "bytes"
 	case descriptor.FieldDescriptorProto_TYPE_BYTES:
 		typ, wire = "[]byte", "bytes"
 	case descriptor.FieldDescriptorProto_TYPE_ENUM:
@@ -1175,6 +3596,10 @@ func (g *Generator) GoType(serviceName string, message *Descriptor, field *descr
 	default:
 		g.Fail("unknown type for", field.GetName())
 	}
+	if g.jsonNumbers && isNumber(field) {
+		// The literal of the number is kept, parsed by the handler as needed.
+		typ = "json.Number"
+	}
 	if isRepeated(field) {
 		typ = "[]" + typ
 	} else if message != nil && message.proto3() {
@@ -1187,7 +3612,36 @@ func (g *Generator) GoType(serviceName string, message *Descriptor, field *descr
 	return
 }
 
+// dynamicTypes maps the well-known messages holding arbitrary JSON to the Go types standing for them.
+var dynamicTypes = map[string]string{
+	".google.protobuf.Any":       "interface{}",
+	".google.protobuf.Value":     "interface{}",
+	".google.protobuf.Struct":    "map[string]interface{}",
+	".google.protobuf.ListValue": "[]interface{}",
+}
+
+// wrapperTypes maps the well-known wrapper messages to the Go types of the nullable scalars
+// they stand for, so that handlers can tell unset values from zero ones.
+var wrapperTypes = map[string]string{
+	".google.protobuf.DoubleValue": "*float64",
+	".google.protobuf.FloatValue":  "*float32",
+	".google.protobuf.Int64Value":  "*int64",
+	".google.protobuf.UInt64Value": "*uint64",
+	".google.protobuf.Int32Value":  "*int32",
+	".google.protobuf.UInt32Value": "*uint32",
+	".google.protobuf.BoolValue":   "*bool",
+	".google.protobuf.StringValue": "*string",
+	".google.protobuf.BytesValue":  "*[]byte",
+}
+
 func (g *Generator) RecordTypeUse(t string) {
+	if _, ok := wrapperTypes[t]; ok {
+		// Wrappers are generated as builtin types, their package is not imported.
+		return
+	}
+	if _, ok := dynamicTypes[t]; ok {
+		return
+	}
 	if _, ok := g.typeNameToObject[t]; !ok {
 		return
 	}
@@ -1257,6 +3711,17 @@ func (g *Generator) generateMessageStruct(mc *msgCtx, topLevelFields []topLevelF
 	g.P("}")
 }
 
+// warnExported logs a warning when the CamelCased name of a generated type or field is
+// not an exported identifier and is forced exported, once per name.
+func (g *Generator) warnExported(name string) {
+	fixed := exported(name)
+	if fixed == name || g.forcedExports[name] {
+		return
+	}
+	g.forcedExports[name] = true
+	log.Print("protoc-gen-rain: warning: ", name, " would be unexported, generating ", fixed)
+}
+
 // Generate the type, methods and default constant definitions for this Descriptor.
 func (g *Generator) generateMessage(message *Descriptor, serviceName string) {
 	topLevelFields := []topLevelField{}
@@ -1264,34 +3729,16 @@ func (g *Generator) generateMessage(message *Descriptor, serviceName string) {
 	typeName := message.TypeName()
 	// The full type name, CamelCased.
 	goTypeName := CamelCaseSlice(typeName)
+	g.warnExported(CamelCase(strings.Join(typeName, "_")))
 
-	usedNames := make(map[string]bool)
-	for _, n := range methodNames {
-		usedNames[n] = true
-	}
-
-	// allocNames finds a conflict-free variation of the given strings,
-	// consistently mutating their suffixes.
-	// It returns the same number of strings.
-	allocNames := func(ns ...string) []string {
-	Loop:
-		for {
-			for _, n := range ns {
-				if usedNames[n] {
-					for i := range ns {
-						ns[i] += "_"
-					}
-					continue Loop
-				}
-			}
-			for _, n := range ns {
-				usedNames[n] = true
-			}
-			return ns
-		}
+	fieldNames, getterNames := g.fieldGoNames(message)
+	for _, field := range message.Field {
+		g.warnExported(CamelCase(field.GetName()))
 	}
 
 	mapFieldTypes := make(map[*descriptor.FieldDescriptorProto]string) // keep track of the map fields to be added later
+	transforms := make(map[string]string)                              // transform name per Go field name
+	fieldOptions := make(map[string]map[string]string)                 // @tag annotations per Go field name
 
 	// Build a structure more suitable for generating the text in one pass
 	for i, field := range message.Field {
@@ -1301,36 +3748,30 @@ func (g *Generator) generateMessage(message *Descriptor, serviceName string) {
 			commentStr += "\n"
 		}
 
-		customAnnotations := map[string]string{}
-		if res := regAnnotation.FindStringSubmatch(commentStr); len(res) > 1 {
-			for _, h := range strings.Split(res[1], " ") {
-				key, val := strings.Trim(h, " "), ""
-				if strings.Contains(key, ":") {
-					arr := strings.Split(key, ":")
-					key, val = arr[0], arr[1]
-				}
-
-				customAnnotations[key] = val
-			}
-		}
+		customAnnotations := parseAnnotations(commentStr)
 
-		base := CamelCase(*field.Name)
-		ns := allocNames(base, "Get"+base)
-		fieldName, fieldGetterName := ns[0], ns[1]
+		fieldName, fieldGetterName := fieldNames[i], getterNames[i]
+		fieldOptions[fieldName] = customAnnotations
 		typename, _ := g.GoType(serviceName, message, field)
 
-		jsonName := *field.Name
-		if field.JsonName != nil {
-			jsonName = *field.JsonName
-		}
+		jsonName := g.jsonName(field)
 
-		formName := jsonName
+		formName := g.formName(field)
 
 		if val, ok := customAnnotations["omitempty"]; !ok || strings.EqualFold(val, "true") {
 			jsonName += ",omitempty"
 		}
+		if isJSString(field) {
+			jsonName += ",string"
+		}
 
 		tag := fmt.Sprintf("json:%q form:%q", jsonName, formName)
+		if val := customAnnotations["validate"]; val != "" {
+			tag += fmt.Sprintf(" validate:%q", val)
+		}
+		if g.fieldNumberTag {
+			tag += fmt.Sprintf(" order:\"%d\"", field.GetNumber())
+		}
 
 		if *field.Type == descriptor.FieldDescriptorProto_TYPE_MESSAGE {
 			desc := g.ObjectNamed(field.GetTypeName())
@@ -1349,6 +3790,9 @@ func (g *Generator) generateMessage(message *Descriptor, serviceName string) {
 					valType = strings.TrimPrefix(valType, "*")
 					g.RecordTypeUse(valField.GetTypeName())
 				case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+					if g.mapValues && !isWellKnownType(valField) {
+						valType = strings.TrimPrefix(valType, "*")
+					}
 					g.RecordTypeUse(valField.GetTypeName())
 				default:
 					valType = strings.TrimPrefix(valType, "*")
@@ -1358,6 +3802,9 @@ func (g *Generator) generateMessage(message *Descriptor, serviceName string) {
 				mapFieldTypes[field] = typename // record for the getter generation
 			}
 		}
+		if strings.Contains(typename, "json.Number") {
+			g.addExternalImport("encoding/json", "")
+		}
 
 		fieldDeprecated := ""
 		if field.GetOptions().GetDeprecated() {
@@ -1376,9 +3823,20 @@ func (g *Generator) generateMessage(message *Descriptor, serviceName string) {
 			protoTypeName: field.GetTypeName(),
 			protoType:     *field.Type,
 			deprecated:    fieldDeprecated,
+			getterDef:     g.getterDefault(field, typename),
 			protoDef:      field.GetDefaultValue(),
 			comment:       commentStr,
 		}
+		if val, ok := customAnnotations["transform"]; ok {
+			if !knownTransforms[val] {
+				g.Fail("transform:", val, "of field", field.GetName(), "is not a known transform")
+			}
+			if field.GetType() != descriptor.FieldDescriptorProto_TYPE_STRING || isRepeated(field) {
+				g.Fail("transform:", field.GetName(), "must be a string field")
+			}
+			transforms[fieldName] = val
+		}
+
 		var pf topLevelField = &rf
 
 		topLevelFields = append(topLevelFields, pf)
@@ -1392,17 +3850,253 @@ func (g *Generator) generateMessage(message *Descriptor, serviceName string) {
 
 	g.generateMessageStruct(mc, topLevelFields)
 	g.P()
+
+	for _, pf := range topLevelFields {
+		pf.getter(g, mc)
+	}
+
+	if g.genSetters {
+		for _, pf := range topLevelFields {
+			pf.setter(g, mc)
+		}
+	}
+
+	if len(transforms) > 0 {
+		g.generateTransforms(mc, topLevelFields, transforms)
+	}
+
+	if g.isZero {
+		g.generateIsZero(mc, topLevelFields)
+	}
+
+	// The well-known messages have no counterpart in pb_import: the messages using them
+	// convert them to the protoc-gen-go well-known types instead.
+	if g.pbConvert && mc.message.File().GetPackage() != "google.protobuf" {
+		g.generatePBConvert(mc, topLevelFields)
+	}
+
+	if g.fieldNumbers {
+		g.P("// ", mc.goName, "_fieldNumbers maps the Go field names of ", mc.goName, " to their proto field numbers.")
+		g.P("var ", mc.goName, "_fieldNumbers = map[string]int32{")
+		for i, field := range message.Field {
+			if f, ok := topLevelFields[i].(*simpleField); ok {
+				g.P(strconv.Quote(f.goName), ": ", strconv.Itoa(int(field.GetNumber())), ",")
+			}
+		}
+		g.P("}")
+		g.P()
+	}
+
+	if g.fieldMeta {
+		g.generateFieldMeta(mc, topLevelFields, fieldOptions)
+	}
+
+	isInput, isOutput := g.methodRoles(message)
+
+	if g.pool && (isInput || isOutput) {
+		g.generatePool(mc)
+	}
+
+	if isInput {
+		if fields := g.defaultFields(message); len(fields) > 0 {
+			g.generateDefaults(mc, fields)
+		}
+	}
+
+	if g.markerInterfaces {
+		g.generateMarkers(mc, isInput, isOutput)
+	}
+
+	if g.validateAggregate && isValidated(message) {
+		g.addExternalImport(GoImportPath(g.Param["repo"]+"/router"), "")
+
+		recv := g.receiverName(mc.goName)
+		g.P("// Validate checks the validate tags of ", mc.goName, ", returning a router.ValidationErrors")
+		g.P("// listing every failing field.")
+		g.P("func (", recv, " *", mc.goName, ") Validate() error {")
+		g.P("return router.ValidateAll(", recv, ")")
+		g.P("}")
+		g.P()
+
+		if g.checkedConstructors {
+			g.generateCheckedConstructor(mc, topLevelFields, fieldOptions)
+		}
+	}
+
+	if g.bodyBuilder && g.isPostBody(message) {
+		g.generateBodyBuilder(mc, topLevelFields)
+	}
 }
 
-func (g *Generator) generateEnumRegistration(enum *EnumDescriptor) {
-	// // We always print the full (proto-world) package name here.
-	pkg := enum.File().GetPackage()
-	if pkg != "" {
-		pkg += "."
+// isJSString reports whether the field is a singular 64-bit integer with [jstype = JS_STRING],
+// encoded as a JSON string so that JavaScript clients don't lose precision. encoding/json
+// has no string option for the elements of repeated fields, which keep the numeric encoding.
+func isJSString(field *descriptor.FieldDescriptorProto) bool {
+	if field.GetOptions().GetJstype() != descriptor.FieldOptions_JS_STRING || isRepeated(field) {
+		return false
 	}
-	// The full type name
-	typeName := enum.TypeName()
-	// The full type name, CamelCased.
-	ccTypeName := CamelCaseSlice(typeName)
-	g.addInitf("%s.RegisterEnum(%q, %[3]s_name, %[3]s_value)", g.Pkg["proto"], pkg+ccTypeName, ccTypeName)
+
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_INT64, descriptor.FieldDescriptorProto_TYPE_UINT64,
+		descriptor.FieldDescriptorProto_TYPE_SINT64, descriptor.FieldDescriptorProto_TYPE_FIXED64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return true
+	}
+	return false
+}
+
+// knownTransforms lists the names accepted by the transform field annotation.
+var knownTransforms = map[string]bool{
+	"encrypt": true,
+	"hash":    true,
+}
+
+// generateTransforms prints ApplyTransforms, converting the fields annotated transform:<name>
+// to their storage representation through router.Transform.
+func (g *Generator) generateTransforms(mc *msgCtx, topLevelFields []topLevelField, transforms map[string]string) {
+	g.addExternalImport(GoImportPath(g.Param["repo"]+"/router"), "")
+
+	recv := g.receiverName(mc.goName)
+
+	g.P("// ApplyTransforms converts the fields of ", mc.goName, " to their storage representation.")
+	g.P("func (", recv, " *", mc.goName, ") ApplyTransforms() error {")
+	g.P("var err error")
+	for _, pf := range topLevelFields {
+		f, ok := pf.(*simpleField)
+		if !ok || transforms[f.goName] == "" {
+			continue
+		}
+		g.P("if ", recv, ".", f.goName, ", err = router.Transform(", strconv.Quote(transforms[f.goName]), ", ", recv, ".", f.goName, "); err != nil {")
+		g.P("return err")
+		g.P("}")
+	}
+	g.P()
+	g.P("return nil")
+	g.P("}")
+	g.P()
+}
+
+// methodRoles reports whether the message is the input or the output of a method of one
+// of the files being generated.
+func (g *Generator) methodRoles(message *Descriptor) (isInput, isOutput bool) {
+	for _, f := range g.genFiles {
+		for _, service := range f.Service {
+			for _, method := range service.Method {
+				if g.ObjectNamed(method.GetInputType()) == Object(message) {
+					isInput = true
+				}
+				if g.ObjectNamed(method.GetOutputType()) == Object(message) {
+					isOutput = true
+				}
+			}
+		}
+	}
+	return isInput, isOutput
+}
+
+// generateMarkers prints the IsRequest and IsResponse marker methods implementing
+// router.RequestMessage and router.ResponseMessage on method inputs and outputs.
+func (g *Generator) generateMarkers(mc *msgCtx, isInput, isOutput bool) {
+	if isInput {
+		g.P("// IsRequest marks ", mc.goName, " as a router.RequestMessage.")
+		g.P("func (*", mc.goName, ") IsRequest() {}")
+		g.P()
+	}
+	if isOutput {
+		g.P("// IsResponse marks ", mc.goName, " as a router.ResponseMessage.")
+		g.P("func (*", mc.goName, ") IsResponse() {}")
+		g.P()
+	}
+}
+
+// generateFieldMeta prints the Msg_fieldMeta map describing each field of the message
+// for runtime tooling. Options holds the @tag annotations of the field's comment.
+func (g *Generator) generateFieldMeta(mc *msgCtx, topLevelFields []topLevelField, options map[string]map[string]string) {
+	g.addExternalImport(GoImportPath(g.Param["repo"]+"/router"), "")
+
+	g.P("// ", mc.goName, "_fieldMeta describes the fields of ", mc.goName, " by Go field name.")
+	g.P("var ", mc.goName, "_fieldMeta = map[string]router.FieldMeta{")
+	for i, field := range mc.message.Field {
+		f, ok := topLevelFields[i].(*simpleField)
+		if !ok {
+			continue
+		}
+
+		typ := strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_"))
+		if field.GetTypeName() != "" {
+			typ = strings.TrimPrefix(field.GetTypeName(), ".")
+		}
+
+		g.P(strconv.Quote(f.goName), ": {")
+		g.P("Name: ", strconv.Quote(field.GetName()), ",")
+		g.P("Number: ", strconv.Itoa(int(field.GetNumber())), ",")
+		g.P("Type: ", strconv.Quote(typ), ",")
+		if opts := options[f.goName]; len(opts) > 0 {
+			keys := make([]string, 0, len(opts))
+			for k := range opts {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			g.P("Options: map[string]string{")
+			for _, k := range keys {
+				g.P(strconv.Quote(k), ": ", strconv.Quote(opts[k]), ",")
+			}
+			g.P("},")
+		}
+		g.P("},")
+	}
+	g.P("}")
+	g.P()
+}
+
+// getterDefault returns the value returned by the getter of the field on a nil message:
+// the default declared in the proto file for proto2 scalars, the zero value otherwise.
+func (g *Generator) getterDefault(field *descriptor.FieldDescriptorProto, goType string) string {
+	if isRepeated(field) || strings.HasPrefix(goType, "*") || strings.HasPrefix(goType, "[]") ||
+		strings.HasPrefix(goType, "map[") || strings.Contains(goType, "interface{}") {
+		return "nil"
+	}
+
+	def := field.GetDefaultValue()
+	if goType == "json.Number" {
+		return strconv.Quote(def)
+	}
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		return strconv.Quote(def)
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		if def == "true" {
+			return "true"
+		}
+		return "false"
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		if def != "" {
+			typeName := g.TypeName(g.ObjectNamed(field.GetTypeName()))
+			enum := g.ObjectNamed(field.GetTypeName()).(*EnumDescriptor)
+			return typeName[:strings.LastIndex(typeName, ".")+1] + enum.prefix() + def
+		}
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT, descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		if _, err := strconv.ParseFloat(def, 64); err == nil {
+			return def
+		}
+	default:
+		if _, err := strconv.ParseInt(def, 10, 64); err == nil {
+			return def
+		}
+		if _, err := strconv.ParseUint(def, 10, 64); err == nil {
+			return def
+		}
+	}
+	return "0"
+}
+
+// stripNamePrefix removes prefix from the CamelCased name when it is followed by another word,
+// so that UserId becomes Id while Username is kept.
+func stripNamePrefix(name, prefix string) string {
+	rest := strings.TrimPrefix(name, prefix)
+	if rest == name || rest == "" || !unicode.IsUpper(rune(rest[0])) {
+		return name
+	}
+	return rest
 }
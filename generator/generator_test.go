@@ -0,0 +1,4202 @@
+package generator
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+	"google.golang.org/genproto/googleapis/api/annotations"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		req  *plugin.CodeGeneratorRequest
+		want string
+	}{
+		{&plugin.CodeGeneratorRequest{}, "no files to generate"},
+		{generateRequest(t, "map_values=ref", goldenUserFile()), `Unknown map_values "ref"`},
+		{&plugin.CodeGeneratorRequest{FileToGenerate: []string{"missing.proto"}}, "missing.proto"},
+	}
+	for _, tt := range tests {
+		if resp, err := Run(tt.req); resp != nil || err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Run(%v) = %v, %v, want an error containing %q", tt.req, resp, err, tt.want)
+		}
+	}
+
+	// A failed run leaves nothing behind for the next one.
+	resp, err := Run(generateRequest(t, "", goldenUserFile()))
+	if err != nil || len(resp.File) == 0 || resp.Error != nil {
+		t.Errorf("Run = %v, %v, want the generated files", resp, err)
+	}
+}
+
+// rawBodyTest serves the handler of TestGoldenRawBody, checking the raw body reaches the
+// reset field, generated as Reset_, while the event field is still bound from it.
+const rawBodyTest = `package user
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type hookHandler struct{}
+
+func (hookHandler) Receive(ctx *gin.Context, in *Hook, out *Hook) error {
+	*out = *in
+	return nil
+}
+
+func TestRawBody(t *testing.T) {
+	g := gin.New()
+	RegisterHookServiceHandler(g, hookHandler{})
+
+	body := ` + "`" + `{"event": "push"}` + "`" + `
+	_, resp := serve(t, g, "POST", "/v1/hooks", body)
+	out, ok := resp.Data.(map[string]any)
+	if resp.Code != 0 || !ok || out["event"] != "push" {
+		t.Fatalf("POST /v1/hooks = %v, want event push", resp)
+	}
+	if raw, _ := out["reset"].(string); raw != base64.StdEncoding.EncodeToString([]byte(body)) {
+		t.Errorf("POST /v1/hooks = %v, want the raw body in reset", resp)
+	}
+}
+`
+
+func TestGoldenRawBody(t *testing.T) {
+	// reset collides with the Reset method: its field is Reset_, wherever it is named.
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("Hook",
+				goldenField("event", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+				goldenField("reset", 2, descriptor.FieldDescriptorProto_TYPE_BYTES, ""),
+			),
+		},
+		nil,
+		[]*descriptor.ServiceDescriptorProto{
+			goldenService("HookService",
+				goldenMethod("Receive", ".user.Hook", ".user.Hook", "POST", "/v1/hooks"),
+			),
+		})
+	goldenComment(file, " @tag rawbody:reset\n", 6, 0, 2, 0)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "rawbody", resp)
+
+	if model := goldenContent(t, resp, "user/user.model.go"); !strings.Contains(model, "Reset_ []byte") {
+		t.Errorf("user.model.go has no Reset_ field:\n%s", model)
+	}
+	if api := goldenContent(t, resp, "user/user.api.go"); !strings.Contains(api, "input.Reset_ = body") {
+		t.Errorf("user.api.go doesn't assign the body to Reset_:\n%s", api)
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go": serveTest,
+		"router/user/hook_test.go":  rawBodyTest,
+	})
+}
+
+func TestRawBodyNotBytes(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag rawbody:user_name\n", 6, 0, 2, 1)
+
+	if out := generateError(t, generateRequest(t, "", file)); !strings.Contains(out, "rawbody: user_name must be a bytes field") {
+		t.Errorf("generating a rawbody string field failed with %q, want a bytes field error", out)
+	}
+}
+
+// enumMapTest fills the map of TestGoldenEnumMapValue with enum values of the status package.
+const enumMapTest = `package user
+
+import (
+	"testing"
+
+	"example.com/app/router/status"
+)
+
+func TestEnumMapValue(t *testing.T) {
+	p := Profile{States: map[string]status.Status{"a": status.Status_STATUS_ACTIVE}}
+	if p.States["a"] != status.Status_STATUS_ACTIVE {
+		t.Errorf("States = %v, want a mapped to STATUS_ACTIVE", p.States)
+	}
+}
+`
+
+func TestGoldenEnumMapValue(t *testing.T) {
+	statusFile := goldenFile("status/status.proto", nil,
+		[]*descriptor.EnumDescriptorProto{
+			goldenEnum("Status", []string{"STATUS_UNKNOWN", "STATUS_ACTIVE"}, []int32{0, 1}),
+		},
+		nil)
+
+	entry := goldenMessage("StatesEntry",
+		goldenField("key", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+		goldenField("value", 2, descriptor.FieldDescriptorProto_TYPE_ENUM, ".status.Status"),
+	)
+	entry.Options = &descriptor.MessageOptions{MapEntry: proto.Bool(true)}
+	profile := goldenMessage("Profile",
+		goldenRepeated(goldenField("states", 1, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".user.Profile.StatesEntry")),
+	)
+	profile.NestedType = []*descriptor.DescriptorProto{entry}
+	userFile := goldenFile("user/user.proto", []*descriptor.DescriptorProto{profile}, nil, nil)
+	userFile.Dependency = []string{"status/status.proto"}
+
+	resp := generateGolden(t, "", statusFile, userFile)
+	checkGolden(t, "enum_map_value", resp)
+
+	model := goldenContent(t, resp, "user/user.model.go")
+	for _, want := range []string{`"example.com/app/router/status"`, "States map[string]status.Status"} {
+		if !strings.Contains(model, want) {
+			t.Errorf("user.model.go has no %s:\n%s", want, model)
+		}
+	}
+	compileGolden(t, resp, map[string]string{"router/user/map_test.go": enumMapTest})
+}
+
+func TestGoFileNameMixedCase(t *testing.T) {
+	file := &FileDescriptor{FileDescriptorProto: &descriptor.FileDescriptorProto{
+		Name:    proto.String("myApp/V1/api.proto"),
+		Package: proto.String("myApp.V1"),
+		Options: &descriptor.FileOptions{GoPackage: proto.String("example.com/myApp/V1;v1")},
+	}}
+
+	tests := []struct {
+		pathType pathType
+		want     string
+	}{
+		{pathTypeImport, "example.com/myApp/V1/api.model.go"},
+		{pathTypeSourceRelative, "myApp/V1/api.model.go"},
+		{pathTypePackage, "myApp/V1/api.model.go"},
+		{pathTypeFlat, "api.model.go"},
+	}
+	for _, tt := range tests {
+		if got := file.goFileName(tt.pathType, ".model.go"); got != tt.want {
+			t.Errorf("goFileName(%v) = %q, want %q keeping the casing of the package", tt.pathType, got, tt.want)
+		}
+	}
+}
+
+// contentTypeTest checks CreateUser rejects bodies that aren't JSON with 415.
+const contentTypeTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestContentTypeCheck(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+
+	body := ` + "`" + `{"userId": 1}` + "`" + `
+	if _, resp := serve(t, g, "POST", "/v1/users", body, "Content-Type", "text/plain"); resp.Code != 415 {
+		t.Errorf("POST /v1/users as text/plain = %v, want code 415", resp)
+	}
+	if _, resp := serve(t, g, "POST", "/v1/users", body, "Content-Type", "application/json; charset=utf-8"); resp.Code != 0 {
+		t.Errorf("POST /v1/users as JSON with a charset = %v, want success", resp)
+	}
+	if _, resp := serve(t, g, "GET", "/v1/ping", "", "Content-Type", "text/plain"); resp.Code != 0 {
+		t.Errorf("GET /v1/ping = %v, want query bound methods left unchecked", resp)
+	}
+}
+`
+
+func TestGoldenContentTypeCheck(t *testing.T) {
+	resp := generateGolden(t, "content_type_check=true", goldenUserFile())
+	checkGolden(t, "content_type_check", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":        serveTest,
+		"router/user/handler_test.go":      userHandlerTest("*gin.Context"),
+		"router/user/content_type_test.go": contentTypeTest,
+	})
+}
+
+// enumQueryTest serves the handler of TestGoldenEnumQuery, binding the status enum from
+// the query by name or number.
+const enumQueryTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type itemHandler struct{}
+
+func (itemHandler) ListItems(ctx *gin.Context, in *ListItemsReq, out *ListItemsReq) error {
+	*out = *in
+	return nil
+}
+
+func (itemHandler) CreateItem(ctx *gin.Context, in *ListItemsReq, out *ListItemsReq) error {
+	*out = *in
+	return nil
+}
+
+func TestEnumQuery(t *testing.T) {
+	g := gin.New()
+	RegisterItemServiceHandler(g, itemHandler{})
+
+	for _, tt := range []struct {
+		target string
+		code   int
+	}{
+		{"/v1/items?status=STATUS_ACTIVE", 0},
+		{"/v1/items?status=1", 0},
+		{"/v1/items?status=ACTIVE", 500},
+	} {
+		_, resp := serve(t, g, "GET", tt.target, "")
+		if resp.Code != tt.code {
+			t.Errorf("GET %s = %v, want code %d", tt.target, resp, tt.code)
+			continue
+		}
+		if out, _ := resp.Data.(map[string]any); tt.code == 0 && out["status"] != float64(1) {
+			t.Errorf("GET %s = %v, want STATUS_ACTIVE bound from the query", tt.target, resp)
+		}
+	}
+}
+`
+
+func TestGoldenEnumQuery(t *testing.T) {
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("ListItemsReq",
+				goldenField("status", 1, descriptor.FieldDescriptorProto_TYPE_ENUM, ".user.Status"),
+			),
+		},
+		[]*descriptor.EnumDescriptorProto{
+			goldenEnum("Status", []string{"STATUS_UNKNOWN", "STATUS_ACTIVE"}, []int32{0, 1}),
+		},
+		[]*descriptor.ServiceDescriptorProto{
+			goldenService("ItemService",
+				goldenMethod("ListItems", ".user.ListItemsReq", ".user.ListItemsReq", "GET", "/v1/items"),
+				goldenMethod("CreateItem", ".user.ListItemsReq", ".user.ListItemsReq", "POST", "/v1/items"),
+			),
+		})
+
+	resp := generateGolden(t, "enum_parse=true", file)
+	checkGolden(t, "enum_query", resp)
+
+	if api := goldenContent(t, resp, "user/user.api.go"); !strings.Contains(api, `router.EnumQuery(ctx, "status", ParseStatus)`) {
+		t.Errorf("user.api.go doesn't convert the status names of the query:\n%s", api)
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go": serveTest,
+		"router/user/enum_test.go":  enumQueryTest,
+	})
+}
+
+// cursorTest pages through ListUsers of TestGoldenCursor with the tokens it returns.
+const cursorTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/app/router/router"
+)
+
+type PageCursor struct {
+	After int64 ` + "`json:\"after\"`" + `
+}
+
+type pageHandler struct{}
+
+func (pageHandler) ListUsers(ctx *gin.Context, in *ListUsersReq, out *ListUsersResp) error {
+	var after int64
+	if cursor := router.Cursor[PageCursor](ctx); cursor != nil {
+		after = cursor.After
+	}
+	out.After = after
+	token, err := router.EncodeCursor(PageCursor{After: after + 10})
+	out.NextPageToken = token
+	return err
+}
+
+func (h pageHandler) SearchUsers(ctx *gin.Context, in *ListUsersReq, out *ListUsersResp) error {
+	return h.ListUsers(ctx, in, out)
+}
+
+func TestCursor(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, pageHandler{})
+
+	token := ""
+	for _, want := range []float64{0, 10, 20} {
+		_, resp := serve(t, g, "GET", "/v1/users?page_token="+token, "")
+		out, _ := resp.Data.(map[string]any)
+		if after, _ := out["after"].(float64); resp.Code != 0 || after != want {
+			t.Fatalf("GET /v1/users?page_token=%s = %v, want after %v", token, resp, want)
+		}
+		token, _ = out["nextPageToken"].(string)
+	}
+
+	if _, resp := serve(t, g, "GET", "/v1/users?page_token=!!", ""); resp.Code != 400 {
+		t.Errorf("GET /v1/users with an invalid token = %v, want code 400", resp)
+	}
+}
+`
+
+func TestGoldenCursor(t *testing.T) {
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("ListUsersReq",
+				goldenField("page_token", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+			),
+			goldenMessage("ListUsersResp",
+				goldenField("after", 1, descriptor.FieldDescriptorProto_TYPE_INT64, ""),
+				goldenField("next_page_token", 2, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+			),
+		},
+		nil,
+		[]*descriptor.ServiceDescriptorProto{
+			goldenService("UserService",
+				goldenMethod("ListUsers", ".user.ListUsersReq", ".user.ListUsersResp", "GET", "/v1/users"),
+				goldenMethod("SearchUsers", ".user.ListUsersReq", ".user.ListUsersResp", "POST", "/v1/users/search"),
+			),
+		})
+	goldenComment(file, " @tag cursor:PageCursor\n", 6, 0, 2, 0)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "cursor", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":  serveTest,
+		"router/user/cursor_test.go": cursorTest,
+	})
+}
+
+// routeFilterTest registers UserService without its CreateUser route.
+const routeFilterTest = `package user
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRegisterFiltered(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandlerFiltered(g, userHandler{}, func(r UserServiceRoute) bool {
+		return r != UserServiceRoute_CreateUser
+	})
+
+	if w, _ := serve(t, g, "POST", "/v1/users", ` + "`" + `{"userId": 1}` + "`" + `); w.Code != http.StatusNotFound {
+		t.Errorf("POST /v1/users = %d, want the disabled route not registered", w.Code)
+	}
+	if _, resp := serve(t, g, "GET", "/v1/ping", ""); resp.Code != 0 {
+		t.Errorf("GET /v1/ping = %v, want success", resp)
+	}
+
+	g = gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+	if _, resp := serve(t, g, "POST", "/v1/users", ` + "`" + `{"userId": 1}` + "`" + `); resp.Code != 0 {
+		t.Errorf("POST /v1/users = %v, want RegisterUserServiceHandler to register every route", resp)
+	}
+}
+`
+
+func TestGoldenRouteFilter(t *testing.T) {
+	resp := generateGolden(t, "route_filter=true", goldenUserFile())
+	checkGolden(t, "route_filter", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":        serveTest,
+		"router/user/handler_test.go":      userHandlerTest("*gin.Context"),
+		"router/user/route_filter_test.go": routeFilterTest,
+	})
+}
+
+// errorSetTest checks UserServiceErrors lists the values of the errs.ErrorCode enum.
+const errorSetTest = `package user
+
+import (
+	"reflect"
+	"testing"
+
+	"example.com/app/router/errs"
+)
+
+func TestErrorSet(t *testing.T) {
+	want := []errs.ErrorCode{errs.ErrorCode_ERROR_CODE_UNKNOWN, errs.ErrorCode_ERROR_CODE_NOT_FOUND}
+	if !reflect.DeepEqual(UserServiceErrors, want) {
+		t.Errorf("UserServiceErrors = %v, want %v", UserServiceErrors, want)
+	}
+}
+`
+
+func TestGoldenErrorSet(t *testing.T) {
+	errs := goldenFile("errs/errs.proto", nil,
+		[]*descriptor.EnumDescriptorProto{
+			goldenEnum("ErrorCode", []string{"ERROR_CODE_UNKNOWN", "ERROR_CODE_NOT_FOUND"}, []int32{0, 1}),
+		}, nil)
+	file := goldenUserFile()
+	file.Dependency = []string{"errs/errs.proto"}
+	goldenComment(file, " @tag errors:errs.ErrorCode\n", 6, 0)
+
+	resp := generateGolden(t, "", errs, file)
+	checkGolden(t, "error_set", resp)
+
+	if api := goldenContent(t, resp, "user/user.api.go"); !strings.Contains(api, `"example.com/app/router/errs"`) {
+		t.Errorf("user.api.go doesn't import the errs package:\n%s", api)
+	}
+	compileGolden(t, resp, map[string]string{"router/user/error_set_test.go": errorSetTest})
+}
+
+func TestErrorSetNotEnum(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag errors:User\n", 6, 0)
+
+	if out := generateError(t, generateRequest(t, "", file)); !strings.Contains(out, "errors: User is not an enum") {
+		t.Errorf("generating errors:User failed with %q, want a not an enum error", out)
+	}
+}
+
+// callTest runs the handler of TestGoldenCallWrappers through the generated CallXxx
+// wrappers, without gin.
+const callTest = `package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type callHandler struct{}
+
+func (callHandler) GetUser(ctx context.Context, in *GetUserReq, out *User) error {
+	if ctx == nil {
+		return errors.New("no context")
+	}
+	if in.UserId == 0 {
+		return errors.New("no user")
+	}
+	out.UserId = in.UserId
+	return nil
+}
+
+func (callHandler) CreateUser(ctx context.Context, in *User, out *User) error {
+	*out = *in
+	return nil
+}
+
+func (callHandler) Ping(ctx context.Context, in *Empty, out *Empty) error {
+	return nil
+}
+
+func TestCallWrappers(t *testing.T) {
+	out, err := CallUserServiceGetUser(callHandler{}, &GetUserReq{UserId: 7})
+	if err != nil || out.UserId != 7 {
+		t.Errorf("CallUserServiceGetUser = %v, %v, want UserId 7", out, err)
+	}
+	if out, err := CallUserServiceGetUser(callHandler{}, &GetUserReq{}); err == nil || out != nil {
+		t.Errorf("CallUserServiceGetUser = %v, %v, want the error of the handler", out, err)
+	}
+	if out, err := CallUserServiceCreateUser(callHandler{}, &User{UserName: "ann"}); err != nil || out.UserName != "ann" {
+		t.Errorf("CallUserServiceCreateUser = %v, %v, want UserName ann", out, err)
+	}
+	if _, err := CallUserServicePing(callHandler{}, &Empty{}); err != nil {
+		t.Errorf("CallUserServicePing: %v", err)
+	}
+}
+`
+
+func TestGoldenCallWrappers(t *testing.T) {
+	resp := generateGolden(t, "stdctx=true,testable=true", goldenUserFile())
+	checkGolden(t, "call_wrappers", resp)
+	compileGolden(t, resp, map[string]string{"router/user/call_test.go": callTest})
+
+	if out := generateError(t, generateRequest(t, "testable=true", goldenUserFile())); !strings.Contains(out, "testable requires stdctx=true") {
+		t.Errorf("generating testable=true without stdctx failed with %q, want a stdctx error", out)
+	}
+}
+
+// customVerbTest serves the REPORT and OPTIONS methods of TestGoldenCustomVerb.
+const customVerbTest = `package user
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/app/router/router"
+)
+
+type customHandler struct{ userHandler }
+
+func (customHandler) ReportUser(ctx *gin.Context, in *User, out *User) error {
+	*out = *in
+	return nil
+}
+
+func (customHandler) ProbeUser(ctx *gin.Context, in *User, out *User) error {
+	*out = *in
+	return nil
+}
+
+func TestCustomVerbs(t *testing.T) {
+	router.RegisterMiddleware("trace", func(ctx *gin.Context) { ctx.Header("X-Trace", "1") })
+	g := gin.New()
+	RegisterUserServiceHandler(g, customHandler{})
+
+	_, resp := serve(t, g, "REPORT", "/v1/reports", ` + "`" + `{"userName": "ann"}` + "`" + `)
+	if out, _ := resp.Data.(map[string]any); resp.Code != 0 || out["userName"] != "ann" {
+		t.Errorf("REPORT /v1/reports = %v, want the body bound", resp)
+	}
+	if w, _ := serve(t, g, "report", "/v1/reports", ` + "`" + `{"userName": "ann"}` + "`" + `); w.Code != http.StatusNotFound {
+		t.Errorf("report /v1/reports = %d, want the route registered under the upper-cased kind only", w.Code)
+	}
+
+	w, resp := serve(t, g, "OPTIONS", "/v1/probe?user_name=ann", "")
+	if out, _ := resp.Data.(map[string]any); resp.Code != 0 || out["userName"] != "ann" || w.Header().Get("X-Trace") != "1" {
+		t.Errorf("OPTIONS /v1/probe = %v, want the query bound through the trace middleware", resp)
+	}
+}
+`
+
+func TestGoldenCustomVerb(t *testing.T) {
+	custom := func(name, kind, path, body string) *descriptor.MethodDescriptorProto {
+		method := goldenMethod(name, ".user.User", ".user.User", "GET", path)
+		rule := &annotations.HttpRule{Pattern: &annotations.HttpRule_Custom{Custom: &annotations.CustomHttpPattern{Kind: kind, Path: path}}, Body: body}
+		if err := proto.SetExtension(method.Options, annotations.E_Http, rule); err != nil {
+			t.Fatal(err)
+		}
+		return method
+	}
+	file := goldenUserFile()
+	file.Service[0].Method = append(file.Service[0].Method,
+		custom("ReportUser", "report", "/v1/reports", "*"),
+		custom("ProbeUser", "OPTIONS", "/v1/probe", ""),
+	)
+	goldenComment(file, " @tag middleware:trace\n", 6, 0, 2, 4)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "custom_verb", resp)
+
+	// gin has no shortcut for custom verbs: they are registered with g.Handle, or
+	// router.Handle with middlewares, under the upper-cased kind.
+	api := goldenContent(t, resp, "user/user.api.go")
+	for _, want := range []string{
+		`g.Handle("REPORT", "/v1/reports", func(ctx *gin.Context) {`,
+		`router.Handle(g, "OPTIONS", "/v1/probe", []string{"trace"}, func(ctx *gin.Context) {`,
+	} {
+		if !strings.Contains(api, want) {
+			t.Errorf("user.api.go has no %s:\n%s", want, api)
+		}
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":       serveTest,
+		"router/user/handler_test.go":     userHandlerTest("*gin.Context"),
+		"router/user/custom_verb_test.go": customVerbTest,
+	})
+}
+
+// versionTest checks the HEADER of the requests to CreateUser, annotated version:2.
+const versionTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVersion(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+
+	body := ` + "`" + `{"userId": 1}` + "`" + `
+	for _, header := range [][]string{{"HEADER", "2"}, nil} {
+		if _, resp := serve(t, g, "POST", "/v1/users", body, header...); resp.Code != 0 {
+			t.Errorf("POST /v1/users with %v = %v, want success", header, resp)
+		}
+	}
+	// The version is checked before the malformed body is bound.
+	if _, resp := serve(t, g, "POST", "/v1/users", "{", "HEADER", "1"); resp.Code != 406 {
+		t.Errorf("POST /v1/users with version 1 = %v, want code 406", resp)
+	}
+	if _, resp := serve(t, g, "GET", "/v1/ping", "", "HEADER", "1"); resp.Code != 0 {
+		t.Errorf("GET /v1/ping with version 1 = %v, want methods without a version left unchecked", resp)
+	}
+}
+`
+
+func TestGoldenVersion(t *testing.T) {
+	tests := []struct {
+		param, golden, header string
+	}{
+		{"", "version", "Accept-Version"},
+		{"version_header=X-Api-Version", "version_header", "X-Api-Version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			file := goldenUserFile()
+			goldenComment(file, " @tag version:2\n", 6, 0, 2, 1)
+
+			resp := generateGolden(t, tt.param, file)
+			checkGolden(t, tt.golden, resp)
+			compileGolden(t, resp, map[string]string{
+				"router/user/serve_test.go":   serveTest,
+				"router/user/handler_test.go": userHandlerTest("*gin.Context"),
+				"router/user/version_test.go": strings.ReplaceAll(versionTest, "HEADER", tt.header),
+			})
+		})
+	}
+
+	// The version and its header are quoted into the generated code whatever they hold.
+	file := goldenUserFile()
+	goldenComment(file, " @tag version:2\"\\\n", 6, 0, 2, 1)
+	resp := generateGolden(t, `version_header=X-V"\`, file)
+	want := `if v := ctx.GetHeader("X-V\"\\"); v != "" && v != "2\"\\" {`
+	if api := goldenContent(t, resp, "user/user.api.go"); !strings.Contains(api, want) {
+		t.Errorf("user.api.go has no %s:\n%s", want, api)
+	}
+	compileGolden(t, resp, nil)
+}
+
+// maxLenTest checks the fields longer than their maxlen annotation are rejected with 400.
+const maxLenTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMaxLen(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+
+	tests := []struct {
+		body, msg string
+	}{
+		{` + "`" + `{"userName": "annie", "tags": ["a", "b"]}` + "`" + `, ""},
+		{` + "`" + `{"userName": "annabel"}` + "`" + `, "userName is longer than 5"},
+		{` + "`" + `{"tags": ["a", "b", "c"]}` + "`" + `, "tags is longer than 2"},
+	}
+	for _, tt := range tests {
+		_, resp := serve(t, g, "POST", "/v1/users", tt.body)
+		if tt.msg == "" && resp.Code != 0 {
+			t.Errorf("POST /v1/users %s = %v, want success", tt.body, resp)
+		}
+		if tt.msg != "" && (resp.Code != 400 || resp.Msg != tt.msg) {
+			t.Errorf("POST /v1/users %s = %v, want 400 %q", tt.body, resp, tt.msg)
+		}
+	}
+}
+`
+
+func TestGoldenMaxLen(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag maxlen:5\n", 4, 1, 2, 1)
+	goldenComment(file, " @tag maxlen:2\n", 4, 1, 2, 2)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "maxlen", resp)
+	if api := goldenContent(t, resp, "user/user.api.go"); !strings.Contains(api, "if len(input.UserName) > 5 {") {
+		t.Errorf("user.api.go has no length check of UserName:\n%s", api)
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":   serveTest,
+		"router/user/handler_test.go": userHandlerTest("*gin.Context"),
+		"router/user/maxlen_test.go":  maxLenTest,
+	})
+
+	file = goldenUserFile()
+	goldenComment(file, " @tag maxlen:5\n", 4, 1, 2, 0)
+	if out := generateError(t, generateRequest(t, "", file)); !strings.Contains(out, "must be a string, bytes, repeated or map field") {
+		t.Errorf("generating maxlen on an int64 field failed with %q, want a field type error", out)
+	}
+}
+
+// tenantTest resolves the tenants of TestGoldenTenant: GetUser from its user_id path
+// variable, CreateUser from the required X-Tenant header and Ping from the optional one.
+const tenantTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/app/router/router"
+)
+
+// tenantHandler records the tenant of every request it handles.
+type tenantHandler struct {
+	tenants *[]string
+}
+
+func (h tenantHandler) GetUser(ctx *gin.Context, in *GetUserReq, out *User) error {
+	*h.tenants = append(*h.tenants, router.Tenant(ctx))
+	return nil
+}
+
+func (h tenantHandler) CreateUser(ctx *gin.Context, in *User, out *User) error {
+	*h.tenants = append(*h.tenants, router.Tenant(ctx))
+	return nil
+}
+
+func (h tenantHandler) Ping(ctx *gin.Context, in *Empty, out *Empty) error {
+	*h.tenants = append(*h.tenants, router.Tenant(ctx))
+	return nil
+}
+
+func TestTenant(t *testing.T) {
+	tests := []struct {
+		method, target string
+		header         []string
+		tenant         string
+		code           int
+	}{
+		{"GET", "/v1/users/7", nil, "7", 0},
+		{"POST", "/v1/users", []string{"X-Tenant", "acme"}, "acme", 0},
+		{"POST", "/v1/users", nil, "", 400},
+		{"GET", "/v1/ping", []string{"X-Tenant", "acme"}, "acme", 0},
+		{"GET", "/v1/ping", nil, "", 0},
+	}
+	for _, tt := range tests {
+		var tenants []string
+		g := gin.New()
+		RegisterUserServiceHandler(g, tenantHandler{&tenants})
+
+		body := ""
+		if tt.method == "POST" {
+			body = "{}"
+		}
+		_, resp := serve(t, g, tt.method, tt.target, body, tt.header...)
+		if resp.Code != tt.code {
+			t.Errorf("%s %s with %v = %v, want code %d", tt.method, tt.target, tt.header, resp, tt.code)
+		}
+		switch {
+		case tt.code == 0 && (len(tenants) != 1 || tenants[0] != tt.tenant):
+			t.Errorf("%s %s with %v resolved tenants %q, want %q", tt.method, tt.target, tt.header, tenants, tt.tenant)
+		case tt.code != 0 && len(tenants) != 0:
+			t.Errorf("%s %s with %v called the handler without a tenant", tt.method, tt.target, tt.header)
+		}
+	}
+}
+`
+
+func TestGoldenTenant(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag tenant:path:user_id\n", 6, 0, 2, 0)
+	goldenComment(file, " @tag tenant:header:X-Tenant\n", 6, 0, 2, 1)
+	goldenComment(file, " @tag tenant:header:X-Tenant:optional\n", 6, 0, 2, 2)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "tenant", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":  serveTest,
+		"router/user/tenant_test.go": tenantTest,
+	})
+
+	// Header names are quoted into the generated code whatever they hold.
+	file = goldenUserFile()
+	goldenComment(file, " @tag tenant:header:X-T\"en\\ant\n", 6, 0, 2, 0)
+	resp = generateGolden(t, "", file)
+	api := goldenContent(t, resp, "user/user.api.go")
+	for _, want := range []string{
+		`if tenant := ctx.GetHeader("X-T\"en\\ant"); tenant != "" {`,
+		`err := errors.New("missing tenant: X-T\"en\\ant header")`,
+	} {
+		if !strings.Contains(api, want) {
+			t.Errorf("user.api.go has no %s:\n%s", want, api)
+		}
+	}
+	compileGolden(t, resp, nil)
+
+	for annotation, want := range map[string]string{
+		"tenant:path:tenant_id": "names no {tenant_id} variable",
+		"tenant:query:tenant":   "must be read from a header or the path",
+		"tenant:header":         "is not header:<name> or path:<name>",
+	} {
+		file := goldenUserFile()
+		goldenComment(file, " @tag "+annotation+"\n", 6, 0, 2, 0)
+		if out := generateError(t, generateRequest(t, "", file)); !strings.Contains(out, want) {
+			t.Errorf("generating %s failed with %q, want an error containing %q", annotation, out, want)
+		}
+	}
+}
+
+func TestGoldenEnumMaps(t *testing.T) {
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("User", goldenField("status", 1, descriptor.FieldDescriptorProto_TYPE_ENUM, ".user.Status")),
+		},
+		[]*descriptor.EnumDescriptorProto{
+			// STATUS_ENABLED is an alias: Status_name keeps STATUS_ACTIVE for 1.
+			goldenEnum("Status", []string{"STATUS_UNKNOWN", "STATUS_ACTIVE", "STATUS_ENABLED"}, []int32{0, 1, 1}),
+		},
+		nil)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "enum_maps", resp)
+	compileGolden(t, resp, nil)
+}
+
+func TestSplitAnnotations(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"a:b", []string{"a:b"}},
+		{"a:b c:d", []string{"a:b", "c:d"}},
+		{"  a:b   c:d  ", []string{"a:b", "c:d"}},
+		{`summary:"Get user by id" c:d`, []string{`summary:"Get user by id"`, "c:d"}},
+		{`summary:"a \"quoted\" word" c:d`, []string{`summary:"a \"quoted\" word"`, "c:d"}},
+		{`summary:"back\\slash" c:d`, []string{`summary:"back\\slash"`, "c:d"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := splitAnnotations(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitAnnotations(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseAnnotations(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		want    map[string]string
+	}{
+		{
+			name:    "no tag",
+			comment: " Get a user.\n",
+			want:    map[string]string{},
+		},
+		{
+			name:    "single key",
+			comment: " @tag middleware:auth\n",
+			want:    map[string]string{"middleware": "auth"},
+		},
+		{
+			name:    "multiple keys",
+			comment: " Get a user.\n @tag a:b c:d\n",
+			want:    map[string]string{"a": "b", "c": "d"},
+		},
+		{
+			name:    "key without value",
+			comment: " @tag deprecated c:d\n",
+			want:    map[string]string{"deprecated": "", "c": "d"},
+		},
+		{
+			name:    "commas",
+			comment: " @tag middleware:auth,ratelimit validate:required,email\n",
+			want:    map[string]string{"middleware": "auth,ratelimit", "validate": "required,email"},
+		},
+		{
+			name:    "colons",
+			comment: " @tag binding:application/json:v2\n",
+			want:    map[string]string{"binding": "application/json:v2"},
+		},
+		{
+			name:    "quoted spaces",
+			comment: ` @tag summary:"Get user by id" c:d` + "\n",
+			want:    map[string]string{"summary": "Get user by id", "c": "d"},
+		},
+		{
+			name:    "quoted colons and commas",
+			comment: ` @tag summary:"at 10:30, or later" c:d` + "\n",
+			want:    map[string]string{"summary": "at 10:30, or later", "c": "d"},
+		},
+		{
+			name:    "escaped quotes",
+			comment: ` @tag summary:"the \"best\" user" c:d` + "\n",
+			want:    map[string]string{"summary": `the "best" user`, "c": "d"},
+		},
+		{
+			name:    "unterminated quote",
+			comment: ` @tag summary:"Get user` + "\n",
+			want:    map[string]string{"summary": `"Get user`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseAnnotations(tt.comment); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAnnotations(%q) = %q, want %q", tt.comment, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGoldenAuthContext(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag auth:none\n", 6, 0, 2, 2)
+
+	resp := generateGolden(t, "auth_context=true", file)
+	checkGolden(t, "auth_context", resp)
+
+	api := goldenContent(t, resp, "user/user.api.go")
+	for _, want := range []string{
+		"GetUser(ctx *gin.Context, user *router.AuthUser, in *GetUserReq, out *User) error",
+		"Ping(ctx *gin.Context, in *Empty, out *Empty) error",
+		"user := router.User(ctx)",
+		"h.GetUser(ctx.Copy(), user, &input, &output)",
+	} {
+		if !strings.Contains(api, want) {
+			t.Errorf("user.api.go has no %q", want)
+		}
+	}
+	compileGolden(t, resp, nil)
+}
+
+// errorHelperTest checks handleError renders the code carried by the error, else the code
+// of the check that failed, else the default code.
+const errorHelperTest = `package user
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type notFound struct{}
+
+func (notFound) Error() string { return "not found" }
+func (notFound) Code() int     { return 404 }
+
+// errorHandler fails GetUser with err.
+type errorHandler struct {
+	userHandler
+	err error
+}
+
+func (h errorHandler) GetUser(ctx *gin.Context, in *GetUserReq, out *User) error {
+	return h.err
+}
+
+func TestErrorHelper(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		code int
+	}{
+		{notFound{}, 404},
+		{errors.New("failed"), 500},
+	} {
+		g := gin.New()
+		RegisterUserServiceHandler(g, errorHandler{err: tt.err})
+		if _, resp := serve(t, g, "GET", "/v1/users/1", ""); resp.Code != tt.code || resp.Msg != tt.err.Error() {
+			t.Errorf("GET /v1/users/1 failing with %v = %v, want code %d", tt.err, resp, tt.code)
+		}
+	}
+
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+	if _, resp := serve(t, g, "POST", "/v1/users", ` + "`" + `{"userName": "annabel"}` + "`" + `); resp.Code != 400 {
+		t.Errorf("POST /v1/users with a long userName = %v, want the maxlen code 400", resp)
+	}
+}
+`
+
+func TestGoldenErrorHelper(t *testing.T) {
+	file := goldenUserFile()
+	file.Service = append(file.Service, goldenService("AdminService",
+		goldenMethod("DeleteUser", ".user.GetUserReq", ".user.Empty", "DELETE", "/v1/users/{user_id}"),
+	))
+	goldenComment(file, " @tag maxlen:5\n", 4, 1, 2, 1)
+
+	resp := generateGolden(t, "error_helper=true", file)
+	checkGolden(t, "error_helper", resp)
+
+	api := goldenContent(t, resp, "user/user.api.go")
+	if n := strings.Count(api, "func handleError(ctx *gin.Context, err error) {"); n != 1 {
+		t.Errorf("user.api.go declares handleError %d times, want once for both services", n)
+	}
+	if strings.Contains(api, "router.Error(ctx, 500, err)") {
+		t.Error("user.api.go handlers call router.Error rather than handleError")
+	}
+	for _, want := range []string{"handleError(ctx, err)", "handleError(ctx, router.WithCode(err, 400))"} {
+		if !strings.Contains(api, want) {
+			t.Errorf("user.api.go has no %s:\n%s", want, api)
+		}
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":        serveTest,
+		"router/user/handler_test.go":      userHandlerTest("*gin.Context"),
+		"router/user/error_helper_test.go": errorHelperTest,
+	})
+
+	// handleError is not derived from the file name: two files of a package can't declare it.
+	other := goldenUserFile()
+	other.Name = proto.String("user/admin.proto")
+	other.MessageType = nil
+	other.Service = []*descriptor.ServiceDescriptorProto{goldenService("AdminService",
+		goldenMethod("DeleteUser", ".user.GetUserReq", ".user.Empty", "DELETE", "/v1/users/{user_id}"),
+	)}
+	if _, err := Run(generateRequest(t, "error_helper=true", goldenUserFile(), other)); err == nil || !strings.Contains(err.Error(), "handleError would be declared twice") {
+		t.Errorf("Run = %v, want an error for two files declaring handleError", err)
+	}
+}
+
+func TestGoldenIfaceFile(t *testing.T) {
+	resp := generateGolden(t, "stdctx=true,iface_file=true", goldenUserFile())
+	checkGolden(t, "iface_file", resp)
+
+	iface := goldenContent(t, resp, "user/user.iface.go")
+	if strings.Contains(iface, "gin") {
+		t.Errorf("user.iface.go imports gin:\n%s", iface)
+	}
+	if !strings.Contains(iface, "type UserServiceHandler interface {") {
+		t.Error("user.iface.go doesn't declare UserServiceHandler")
+	}
+	if strings.Contains(goldenContent(t, resp, "user/user.api.go"), "type UserServiceHandler interface {") {
+		t.Error("user.api.go declares UserServiceHandler as well")
+	}
+	compileGolden(t, resp, nil)
+}
+
+func TestGoldenMultiplePackages(t *testing.T) {
+	billing := goldenFile("billing/billing.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("Invoice",
+				goldenField("id", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+				goldenField("owner", 2, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".user.User"),
+			),
+		},
+		nil,
+		[]*descriptor.ServiceDescriptorProto{
+			goldenService("Billing",
+				goldenMethod("GetInvoice", ".billing.Invoice", ".billing.Invoice", "GET", "/v1/invoices/{id}"),
+			),
+		})
+	billing.Dependency = []string{"user/user.proto"}
+
+	resp := generateGolden(t, "", goldenUserFile(), billing)
+	checkGolden(t, "multiple_packages", resp)
+
+	if model := goldenContent(t, resp, "billing/billing.model.go"); !strings.Contains(model, "package billing") || !strings.Contains(model, "*user.User") {
+		t.Errorf("billing.model.go isn't generated in its own package:\n%s", model)
+	}
+	if model := goldenContent(t, resp, "user/user.model.go"); !strings.Contains(model, "package user") {
+		t.Errorf("user.model.go isn't generated in its own package:\n%s", model)
+	}
+	compileGolden(t, resp, nil)
+}
+
+func TestMultiplePackagesInconsistentName(t *testing.T) {
+	a := goldenFile("user/a.proto", []*descriptor.DescriptorProto{goldenMessage("A")}, nil, nil)
+	b := goldenFile("user/b.proto", []*descriptor.DescriptorProto{goldenMessage("B")}, nil, nil)
+	b.Options.GoPackage = proto.String(goldenRepo + "/user;other")
+
+	_, err := Run(generateRequest(t, "", a, b))
+	if err == nil || !strings.Contains(err.Error(), "inconsistent package names") {
+		t.Fatalf("Run error = %v, want inconsistent package names", err)
+	}
+}
+
+func TestGoldenStripFieldPrefix(t *testing.T) {
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("User",
+				goldenField("user_id", 1, descriptor.FieldDescriptorProto_TYPE_INT64, ""),
+				goldenField("user_name", 2, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+				goldenField("id", 3, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+				goldenField("username", 4, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+			),
+		},
+		nil, nil)
+
+	resp := generateGolden(t, "strip_field_prefix=true", file)
+	checkGolden(t, "strip_field_prefix", resp)
+
+	model := goldenContent(t, resp, "user/user.model.go")
+	for _, want := range []string{
+		"Id       int64  `json:\"userId,omitempty\" form:\"user_id\"`",
+		"Name     string `json:\"userName,omitempty\" form:\"user_name\"`",
+		"Id_      string `json:\"id,omitempty\" form:\"id\"`",
+	} {
+		if !strings.Contains(model, want) {
+			t.Errorf("user.model.go has no %s", want)
+		}
+	}
+	compileGolden(t, resp, nil)
+}
+
+// bindingsTest serves GetUser of TestGoldenAdditionalBindings from its HTTP rule and its
+// two additional bindings, which share the header, validate and rendering code.
+const bindingsTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type bindingsHandler struct{}
+
+func (bindingsHandler) GetUser(ctx *gin.Context, in *GetUserReq, out *User) error {
+	out.UserId, out.UserName = in.UserId, in.Trace
+	return nil
+}
+
+func (bindingsHandler) CreateUser(ctx *gin.Context, in *User, out *User) error {
+	return nil
+}
+
+func (bindingsHandler) Ping(ctx *gin.Context, in *Empty, out *Empty) error {
+	return nil
+}
+
+func TestAdditionalBindings(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, bindingsHandler{})
+
+	for _, tt := range []struct {
+		method, target, body string
+		code                 int
+		userID               float64
+	}{
+		{"GET", "/v1/users/7", "", 0, 7},
+		{"POST", "/v1/lookups", ` + "`" + `{"userId":8}` + "`" + `, 0, 8},
+		{"POST", "/v1/users/9/lookup", ` + "`" + `{}` + "`" + `, 0, 9},
+		{"GET", "/v1/users/0", "", 500, 0},
+		{"POST", "/v1/lookups", ` + "`" + `{"userId":0}` + "`" + `, 500, 0},
+		{"POST", "/v1/users/0/lookup", ` + "`" + `{}` + "`" + `, 500, 0},
+	} {
+		_, resp := serve(t, g, tt.method, tt.target, tt.body, "X-Trace", "abc")
+		if resp.Code != tt.code {
+			t.Errorf("%s %s = %v, want code %d", tt.method, tt.target, resp, tt.code)
+			continue
+		}
+		if out, _ := resp.Data.(map[string]any); tt.code == 0 && (out["userId"] != tt.userID || out["userName"] != "abc") {
+			t.Errorf("%s %s = %v, want userId %v and userName abc", tt.method, tt.target, resp, tt.userID)
+		}
+	}
+}
+`
+
+func TestGoldenAdditionalBindings(t *testing.T) {
+	file := goldenUserFile()
+	req := file.MessageType[0]
+	req.Field = append(req.Field, goldenField("trace", 2, descriptor.FieldDescriptorProto_TYPE_STRING, ""))
+	goldenComment(file, " @tag validate:gt=0\n", 4, 0, 2, 0)
+	goldenComment(file, " @tag header:X-Trace\n", 4, 0, 2, 1)
+	goldenBindings(file.Service[0].Method[0],
+		&annotations.HttpRule{Pattern: &annotations.HttpRule_Post{Post: "/v1/lookups"}, Body: "*"},
+		&annotations.HttpRule{Pattern: &annotations.HttpRule_Post{Post: "/v1/users/{user_id}/lookup"}, Body: "*"},
+	)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "additional_bindings", resp)
+
+	api := goldenContent(t, resp, "user/user.api.go")
+	if n := strings.Count(api, "h.GetUser("); n != 1 {
+		t.Errorf("user.api.go calls h.GetUser %d times, want once from serveGetUser:\n%s", n, api)
+	}
+	if n := strings.Count(api, "serveGetUser(ctx, input)"); n != 3 {
+		t.Errorf("user.api.go calls serveGetUser %d times, want once per binding:\n%s", n, api)
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":    serveTest,
+		"router/user/bindings_test.go": bindingsTest,
+	})
+}
+
+// watchTest serves the server-streaming Watch of TestGoldenStreamEmpty, taking a
+// google.protobuf.Empty bound as a router.Empty, and reads its events.
+const watchTest = `package user
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/app/router/router"
+)
+
+// watchHandler sends n events, then fails with err if not nil.
+type watchHandler struct {
+	n   int
+	err error
+}
+
+func (h watchHandler) Watch(ctx *gin.Context, in *router.Empty, stream router.Stream[Event]) error {
+	for i := 1; i <= h.n; i++ {
+		if err := stream.Send(&Event{Seq: int32(i)}); err != nil {
+			return err
+		}
+	}
+	return h.err
+}
+
+func TestWatch(t *testing.T) {
+	for _, tt := range []struct {
+		h        watchHandler
+		wantBody string
+	}{
+		{watchHandler{n: 2}, "event:message\ndata:{\"seq\":1}\n\nevent:message\ndata:{\"seq\":2}\n\n"},
+		{watchHandler{n: 1, err: errors.New("gone")}, "event:message\ndata:{\"seq\":1}\n\nevent:error\ndata:{\"code\":500,\"msg\":\"gone\",\"data\":null}\n\n"},
+	} {
+		g := gin.New()
+		RegisterWatchServiceHandler(g, tt.h)
+
+		w, _ := serve(t, g, "GET", "/v1/watch", "")
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+			t.Errorf("Content-Type = %q, want text/event-stream", ct)
+		}
+		if got := w.Body.String(); got != tt.wantBody {
+			t.Errorf("body = %q, want %q", got, tt.wantBody)
+		}
+	}
+
+	g := gin.New()
+	RegisterWatchServiceHandler(g, watchHandler{err: errors.New("denied")})
+	if _, resp := serve(t, g, "GET", "/v1/watch", ""); resp.Code != 500 || resp.Msg != "denied" {
+		t.Errorf("GET /v1/watch failing before any event = %v, want the error rendered as JSON", resp)
+	}
+}
+`
+
+func TestGoldenStreamEmpty(t *testing.T) {
+	empty := &descriptor.FileDescriptorProto{
+		Name:        proto.String("empty/empty.proto"),
+		Package:     proto.String("google.protobuf"),
+		Syntax:      proto.String("proto3"),
+		Options:     &descriptor.FileOptions{GoPackage: proto.String(goldenRepo + "/empty;empty")},
+		MessageType: []*descriptor.DescriptorProto{goldenMessage("Empty")},
+	}
+	watch := goldenMethod("Watch", ".google.protobuf.Empty", ".user.Event", "GET", "/v1/watch")
+	watch.ServerStreaming = proto.Bool(true)
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("Event", goldenField("seq", 1, descriptor.FieldDescriptorProto_TYPE_INT32, "")),
+		},
+		nil,
+		[]*descriptor.ServiceDescriptorProto{goldenService("WatchService", watch)})
+	file.Dependency = []string{"empty/empty.proto"}
+
+	resp := generateGolden(t, "", empty, file)
+	checkGolden(t, "stream_empty", resp)
+
+	api := goldenContent(t, resp, "user/user.api.go")
+	for _, want := range []string{
+		"Watch(ctx *gin.Context, in *router.Empty, stream router.Stream[Event]) error",
+		"router.SSE[Event](ctx)",
+	} {
+		if !strings.Contains(api, want) {
+			t.Errorf("user.api.go has no %s:\n%s", want, api)
+		}
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go": serveTest,
+		"router/user/watch_test.go": watchTest,
+	})
+}
+
+func TestPruneHandlers(t *testing.T) {
+	dir := t.TempDir()
+	stale := `{"other/OtherService":"other","user/RemovedService":"user","user/UserService":"user"}`
+	if err := os.WriteFile(filepath.Join(dir, "handler.json"), []byte(stale), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	generateGolden(t, "prune_handlers=true,path="+dir, goldenUserFile())
+
+	bts, err := os.ReadFile(filepath.Join(dir, "handler.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The services of the other directories are left alone.
+	if want := `{"other/OtherService":"other","user/UserService":"user"}`; string(bts) != want {
+		t.Errorf("handler.json = %s, want %s", bts, want)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	var want strings.Builder
+	for _, file := range generateGolden(t, "", goldenUserFile()).File {
+		want.WriteString(file.GetName() + "\n")
+	}
+
+	dir := t.TempDir()
+	resp := generateGolden(t, "dry_run=true,path="+dir, goldenUserFile())
+	if len(resp.File) != 1 || resp.File[0].GetName() != manifestName {
+		t.Fatalf("dry run generated %d files, want only %s", len(resp.File), manifestName)
+	}
+	if got := resp.File[0].GetContent(); got != want.String() {
+		t.Errorf("manifest = %q, want %q", got, want.String())
+	}
+	// handler.json is left alone.
+	if _, err := os.Stat(filepath.Join(dir, "handler.json")); !os.IsNotExist(err) {
+		t.Errorf("handler.json written by a dry run: %v", err)
+	}
+}
+
+func TestHandlersLock(t *testing.T) {
+	dir := t.TempDir()
+	lock := filepath.Join(dir, "handler.json.lock")
+	if err := os.WriteFile(lock, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A held lock makes the generator wait until it is released.
+	req := generateRequest(t, "path="+dir, goldenUserFile())
+	done := make(chan error, 1)
+	go func() {
+		_, err := Run(req)
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("generated while handler.json.lock was held")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if _, err := os.Stat(filepath.Join(dir, "handler.json")); !os.IsNotExist(err) {
+		t.Fatalf("handler.json written while locked: %v", err)
+	}
+	os.Remove(lock)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, "handler.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o644 {
+		t.Errorf("handler.json mode = %v, want 0644", fi.Mode().Perm())
+	}
+	bts, err := os.ReadFile(filepath.Join(dir, "handler.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"user/UserService":"user"}`; string(bts) != want {
+		t.Errorf("handler.json = %s, want %s", bts, want)
+	}
+
+	// Neither the lock nor a temporary file is left behind.
+	left, _ := filepath.Glob(filepath.Join(dir, ".handler.json.*"))
+	if _, err := os.Stat(lock); !os.IsNotExist(err) || len(left) > 0 {
+		t.Errorf("left behind: lock %v, temporary files %v", err, left)
+	}
+}
+
+func TestHandlersStaleLock(t *testing.T) {
+	dir := t.TempDir()
+	lock := filepath.Join(dir, "handler.json.lock")
+	if err := os.WriteFile(lock, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * handlersLockTimeout)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+	// Keys are kept sorted whatever order they were read in.
+	existing := `{"zeta/ZetaService":"zeta","alpha/AlphaService":"alpha"}`
+	if err := os.WriteFile(filepath.Join(dir, "handler.json"), []byte(existing), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	generateGolden(t, "path="+dir, goldenUserFile())
+
+	if !strings.Contains(buf.String(), "breaking stale") {
+		t.Errorf("log = %q, want the stale lock broken", buf.String())
+	}
+	fi, err := os.Stat(filepath.Join(dir, "handler.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o644 {
+		t.Errorf("handler.json mode = %v, want 0644", fi.Mode().Perm())
+	}
+	bts, err := os.ReadFile(filepath.Join(dir, "handler.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"alpha/AlphaService":"alpha","user/UserService":"user","zeta/ZetaService":"zeta"}`; string(bts) != want {
+		t.Errorf("handler.json = %s, want %s", bts, want)
+	}
+}
+
+func TestHandlersRootKey(t *testing.T) {
+	dir := t.TempDir()
+	file := goldenUserFile()
+	file.Name = proto.String("user.proto")
+	file.Options.GoPackage = proto.String(goldenRepo + ";user")
+
+	generateGolden(t, "path="+dir, file)
+
+	// Services of a file at the root keep the "./" prefix the handlers are looked up by.
+	bts, err := os.ReadFile(filepath.Join(dir, "handler.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"./UserService":"."}`; string(bts) != want {
+		t.Errorf("handler.json = %s, want %s", bts, want)
+	}
+}
+
+func TestHandlersContentError(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "handler.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Run(generateRequest(t, "path="+dir, goldenUserFile()))
+	if err == nil || !strings.Contains(err.Error(), "handler.json file content error") {
+		t.Errorf("err = %v, want a handler.json content error", err)
+	}
+}
+
+func TestHandlersPathUnset(t *testing.T) {
+	_, err := Run(generateRequest(t, "path=", goldenUserFile()))
+	if err == nil || !strings.Contains(err.Error(), "handler.json: path parameter not set") {
+		t.Errorf("err = %v, want an error for the unset path", err)
+	}
+}
+
+// permFlagsTest exercises the flag helpers of the Perm enum of TestGoldenEnumFlags.
+const permFlagsTest = `package user
+
+import "testing"
+
+func TestPermFlags(t *testing.T) {
+	p := Perm_PERM_NONE.Set(Perm_PERM_READ).Set(Perm_PERM_WRITE)
+	if !p.Has(Perm_PERM_READ | Perm_PERM_WRITE) || p.Has(Perm_PERM_ADMIN) {
+		t.Errorf("Has of %d is wrong", p)
+	}
+	if got := p.String(); got != "PERM_READ|PERM_WRITE" {
+		t.Errorf("String() = %q, want PERM_READ|PERM_WRITE", got)
+	}
+	if got := p.Clear(Perm_PERM_READ).String(); got != "PERM_WRITE" {
+		t.Errorf("Clear(PERM_READ).String() = %q, want PERM_WRITE", got)
+	}
+	if got := Perm_PERM_NONE.String(); got != "PERM_NONE" {
+		t.Errorf("String() of 0 = %q, want PERM_NONE", got)
+	}
+	if got := (Perm_PERM_ADMIN | 16).String(); got != "PERM_ADMIN|16" {
+		t.Errorf("String() with an unknown bit = %q, want PERM_ADMIN|16", got)
+	}
+}
+`
+
+func TestGoldenEnumFlags(t *testing.T) {
+	file := goldenFile("user/user.proto", nil,
+		[]*descriptor.EnumDescriptorProto{
+			goldenEnum("Perm", []string{"PERM_NONE", "PERM_READ", "PERM_WRITE", "PERM_ADMIN"}, []int32{0, 1, 2, 4}),
+		},
+		nil)
+	goldenComment(file, " @tag flags:true\n", 5, 0)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "enum_flags", resp)
+	compileGolden(t, resp, map[string]string{"router/user/perm_test.go": permFlagsTest})
+}
+
+func TestEnumFlagsNotPowerOfTwo(t *testing.T) {
+	file := goldenFile("user/user.proto", nil,
+		[]*descriptor.EnumDescriptorProto{
+			goldenEnum("Perm", []string{"PERM_NONE", "PERM_READ", "PERM_ALL"}, []int32{0, 1, 3}),
+		},
+		nil)
+	goldenComment(file, " @tag flags:true\n", 5, 0)
+
+	_, err := Run(generateRequest(t, "", file))
+	if err == nil || !strings.Contains(err.Error(), "PERM_ALL is not a distinct power of two") {
+		t.Fatalf("Run error = %v, want PERM_ALL is not a distinct power of two", err)
+	}
+}
+
+// parseStatusTest parses valid and invalid names with the ParseStatus of TestGoldenEnumParse.
+const parseStatusTest = `package user
+
+import "testing"
+
+func TestParseStatus(t *testing.T) {
+	if v, err := ParseStatus("STATUS_ACTIVE"); err != nil || v != Status_STATUS_ACTIVE {
+		t.Errorf("ParseStatus(STATUS_ACTIVE) = %v, %v, want STATUS_ACTIVE", v, err)
+	}
+	for _, s := range []string{"", "ACTIVE", "status_active"} {
+		if _, err := ParseStatus(s); err == nil {
+			t.Errorf("ParseStatus(%q) succeeded, want an error", s)
+		}
+	}
+}
+`
+
+func TestGoldenEnumParse(t *testing.T) {
+	file := goldenFile("user/user.proto", nil,
+		[]*descriptor.EnumDescriptorProto{
+			goldenEnum("Status", []string{"STATUS_UNKNOWN", "STATUS_ACTIVE"}, []int32{0, 1}),
+		},
+		nil)
+
+	resp := generateGolden(t, "enum_parse=true", file)
+	checkGolden(t, "enum_parse", resp)
+	compileGolden(t, resp, map[string]string{"router/user/status_test.go": parseStatusTest})
+}
+
+// enumBindingTest serves the handler of TestGoldenEnumParseBinding, binding the status
+// enum from the path, the query and a header by name or number.
+const enumBindingTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type itemHandler struct{}
+
+func (itemHandler) ListItems(ctx *gin.Context, in *ListItemsReq, out *ListItemsReq) error {
+	*out = *in
+	return nil
+}
+
+func TestEnumBinding(t *testing.T) {
+	g := gin.New()
+	RegisterItemServiceHandler(g, itemHandler{})
+
+	for _, tt := range []struct {
+		target, mode string
+		code         int
+	}{
+		{"/v1/items/STATUS_ACTIVE?filter=STATUS_ACTIVE", "STATUS_ACTIVE", 0},
+		{"/v1/items/1?filter=1", "1", 0},
+		{"/v1/items/ACTIVE?filter=STATUS_ACTIVE", "STATUS_ACTIVE", 500},
+		{"/v1/items/STATUS_ACTIVE?filter=ACTIVE", "STATUS_ACTIVE", 500},
+		{"/v1/items/STATUS_ACTIVE?filter=STATUS_ACTIVE", "ACTIVE", 500},
+	} {
+		_, resp := serve(t, g, "GET", tt.target, "", "X-Mode", tt.mode)
+		if resp.Code != tt.code {
+			t.Errorf("GET %s with X-Mode %s = %v, want code %d", tt.target, tt.mode, resp, tt.code)
+			continue
+		}
+		out, _ := resp.Data.(map[string]any)
+		if tt.code == 0 && (out["status"] != float64(1) || out["filter"] != float64(1) || out["mode"] != float64(1)) {
+			t.Errorf("GET %s with X-Mode %s = %v, want STATUS_ACTIVE bound from the path, query and header", tt.target, tt.mode, resp)
+		}
+	}
+}
+`
+
+func TestGoldenEnumParseBinding(t *testing.T) {
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("ListItemsReq",
+				goldenField("status", 1, descriptor.FieldDescriptorProto_TYPE_ENUM, ".user.Status"),
+				goldenField("filter", 2, descriptor.FieldDescriptorProto_TYPE_ENUM, ".user.Status"),
+				goldenField("mode", 3, descriptor.FieldDescriptorProto_TYPE_ENUM, ".user.Status"),
+			),
+		},
+		[]*descriptor.EnumDescriptorProto{
+			goldenEnum("Status", []string{"STATUS_UNKNOWN", "STATUS_ACTIVE"}, []int32{0, 1}),
+		},
+		[]*descriptor.ServiceDescriptorProto{
+			goldenService("ItemService",
+				goldenMethod("ListItems", ".user.ListItemsReq", ".user.ListItemsReq", "GET", "/v1/items/{status}"),
+			),
+		})
+	goldenComment(file, " @tag header:X-Mode\n", 4, 0, 2, 2)
+
+	resp := generateGolden(t, "enum_parse=true", file)
+	checkGolden(t, "enum_parse_binding", resp)
+
+	api := goldenContent(t, resp, "user/user.api.go")
+	for _, want := range []string{
+		`router.EnumQuery(ctx, "filter", ParseStatus)`,
+		`router.EnumValue(ctx.Param("status"), ParseStatus)`,
+		`router.EnumValue(header, ParseStatus)`,
+	} {
+		if !strings.Contains(api, want) {
+			t.Errorf("user.api.go has no %s:\n%s", want, api)
+		}
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go": serveTest,
+		"router/user/enum_test.go":  enumBindingTest,
+	})
+}
+
+func TestJSONCase(t *testing.T) {
+	tests := []struct {
+		param string
+		want  string
+	}{
+		{"", "`json:\"userId,omitempty\" form:\"user_id\"`"},
+		{"json_case=camel", "`json:\"userId,omitempty\" form:\"user_id\"`"},
+		{"json_case=snake", "`json:\"user_id,omitempty\" form:\"user_id\"`"},
+		{"json_case=original", "`json:\"user_id,omitempty\" form:\"user_id\"`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			file := goldenFile("user/user.proto",
+				[]*descriptor.DescriptorProto{
+					goldenMessage("User", goldenField("user_id", 1, descriptor.FieldDescriptorProto_TYPE_INT64, "")),
+				},
+				nil, nil)
+
+			resp := generateGolden(t, tt.param, file)
+			if model := goldenContent(t, resp, "user/user.model.go"); !strings.Contains(model, "UserId int64 "+tt.want) {
+				t.Errorf("user.model.go has no UserId int64 %s:\n%s", tt.want, model)
+			}
+		})
+	}
+
+	_, err := Run(generateRequest(t, "json_case=kebab", goldenUserFile()))
+	if err == nil || !strings.Contains(err.Error(), `Unknown json_case "kebab"`) {
+		t.Errorf("Run error = %v, want Unknown json_case", err)
+	}
+}
+
+// lookupTest serves the handler of TestGoldenFieldNameCollision, binding the fields
+// string and reset, generated as String_ and Reset_, from the path and a header.
+const lookupTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type lookupHandler struct{}
+
+func (lookupHandler) Lookup(ctx *gin.Context, in *Lookup, out *Lookup) error {
+	*out = *in
+	return nil
+}
+
+func TestLookup(t *testing.T) {
+	g := gin.New()
+	RegisterLookupServiceHandler(g, lookupHandler{})
+
+	_, resp := serve(t, g, "GET", "/v1/lookups/abc", "", "X-Reset", "5")
+	out, ok := resp.Data.(map[string]any)
+	if resp.Code != 0 || !ok || out["string"] != "abc" || out["reset"] != float64(5) {
+		t.Errorf("GET /v1/lookups/abc = %v, want string abc and reset 5", resp)
+	}
+
+	if _, resp := serve(t, g, "GET", "/v1/lookups/abcdefghi", ""); resp.Code != 400 {
+		t.Errorf("GET /v1/lookups/abcdefghi = %v, want code 400 for a string longer than 8", resp)
+	}
+}
+`
+
+func TestGoldenFieldNameCollision(t *testing.T) {
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("Lookup",
+				goldenField("string", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+				goldenField("reset", 2, descriptor.FieldDescriptorProto_TYPE_INT64, ""),
+			),
+		},
+		nil,
+		[]*descriptor.ServiceDescriptorProto{
+			goldenService("LookupService",
+				goldenMethod("Lookup", ".user.Lookup", ".user.Lookup", "GET", "/v1/lookups/{string}"),
+			),
+		})
+	goldenComment(file, " @tag maxlen:8\n", 4, 0, 2, 0)
+	goldenComment(file, " @tag header:X-Reset\n", 4, 0, 2, 1)
+
+	resp := generateGolden(t, "field_numbers=true", file)
+	checkGolden(t, "field_name_collision", resp)
+
+	model := goldenContent(t, resp, "user/user.model.go")
+	for _, want := range []string{"String_ string", "Reset_ int64", `"String_": 1`} {
+		if !strings.Contains(model, want) {
+			t.Errorf("user.model.go has no %s:\n%s", want, model)
+		}
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":  serveTest,
+		"router/user/lookup_test.go": lookupTest,
+	})
+}
+
+// stdctxValuesTest serves the routes of TestGoldenStdCtxValues behind a middleware setting
+// a value on the gin context, read by the context.Context handler.
+const stdctxValuesTest = `package user
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/app/router/router"
+)
+
+type valuesHandler struct{ userHandler }
+
+func (valuesHandler) GetUser(ctx context.Context, in *GetUserReq, out *User) error {
+	v, ok := router.FromGin(ctx, "request_id")
+	if !ok || ctx.Value("request_id") != v {
+		return fmt.Errorf("request_id is not reachable from the handler context")
+	}
+	out.UserName = v.(string)
+	return nil
+}
+
+func TestStdCtxValues(t *testing.T) {
+	g := gin.New()
+	g.Use(func(ctx *gin.Context) { ctx.Set("request_id", "abc") })
+	RegisterUserServiceHandler(g, valuesHandler{})
+
+	_, resp := serve(t, g, "GET", "/v1/users/1", "")
+	if out, _ := resp.Data.(map[string]any); resp.Code != 0 || out["userName"] != "abc" {
+		t.Errorf("GET /v1/users/1 = %v, want userName abc set by the middleware", resp)
+	}
+}
+`
+
+func TestGoldenStdCtxValues(t *testing.T) {
+	resp := generateGolden(t, "stdctx=true", goldenUserFile())
+	checkGolden(t, "stdctx", resp)
+
+	if api := goldenContent(t, resp, "user/user.api.go"); !strings.Contains(api, "h.GetUser(router.Context(ctx), &input, &output)") {
+		t.Errorf("user.api.go doesn't pass router.Context(ctx) to the handlers:\n%s", api)
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":   serveTest,
+		"router/user/handler_test.go": userHandlerTest("context.Context"),
+		"router/user/values_test.go":  stdctxValuesTest,
+	})
+}
+
+// receiverTest calls the methods generated with short receiver names.
+const receiverTest = `package user
+
+import "testing"
+
+func TestReceiverMethods(t *testing.T) {
+	u := NewUser().WithUserId(1).WithUserName("a")
+	u.SetTags([]string{"x"})
+	if u.GetUserId() != 1 || u.GetUserName() != "a" || len(u.GetTags()) != 1 || u.IsZero() {
+		t.Errorf("user = %+v, want the fields set by the setters", u)
+	}
+	u.Reset()
+	if !u.IsZero() {
+		t.Errorf("user = %+v after Reset, want it zero", u)
+	}
+}
+`
+
+func TestGoldenReceiver(t *testing.T) {
+	const param = "iszero=true,pool=true,gen_setters=true,body_builder=true"
+	resp := generateGolden(t, param+",receiver=short", goldenUserFile())
+	checkGolden(t, "receiver_short", resp)
+	compileGolden(t, resp, map[string]string{"router/user/receiver_test.go": receiverTest})
+
+	model := goldenContent(t, generateGolden(t, param+",receiver=type", goldenUserFile()), "user/user.model.go")
+	for _, want := range []string{"func (getUserReq *GetUserReq) GetUserId() int64", "func (user *User) WithTags(v []string) *User", "func (empty *Empty) Reset()"} {
+		if !strings.Contains(model, want) {
+			t.Errorf("receiver=type: user.model.go doesn't have %q:\n%s", want, model)
+		}
+	}
+	if strings.Contains(model, "func (m *") {
+		t.Errorf("receiver=type: user.model.go still has m receivers:\n%s", model)
+	}
+
+	// short would name the receiver of Value v, the parameter of its setters.
+	file := goldenFile("value/value.proto", []*descriptor.DescriptorProto{
+		goldenMessage("Value", goldenField("name", 1, descriptor.FieldDescriptorProto_TYPE_STRING, "")),
+	}, nil, nil)
+	resp = generateGolden(t, "gen_setters=true,receiver=short", file)
+	if model := goldenContent(t, resp, "value/value.model.go"); !strings.Contains(model, "func (v_ *Value) SetName(v string)") {
+		t.Errorf("receiver=short: value.model.go doesn't rename the receiver shadowing v:\n%s", model)
+	}
+	compileGolden(t, resp, nil)
+}
+
+// routeContextTest checks the route template GetUser finds on its gin context.
+const routeContextTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/app/router/router"
+)
+
+type templateHandler struct{ userHandler }
+
+func (templateHandler) GetUser(ctx *gin.Context, in *GetUserReq, out *User) error {
+	out.UserName = router.RouteTemplate(ctx)
+	return nil
+}
+
+func TestRouteTemplate(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, templateHandler{})
+
+	_, resp := serve(t, g, "GET", "/v1/users/1", "")
+	if out, _ := resp.Data.(map[string]any); resp.Code != 0 || out["userName"] != "/v1/users/{user_id}" {
+		t.Errorf("GET /v1/users/1 = %v, want userName /v1/users/{user_id}", resp)
+	}
+}
+`
+
+func TestGoldenRouteContext(t *testing.T) {
+	resp := generateGolden(t, "route_context=true", goldenUserFile())
+	checkGolden(t, "route_context", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":    serveTest,
+		"router/user/handler_test.go":  userHandlerTest("*gin.Context"),
+		"router/user/template_test.go": routeContextTest,
+	})
+}
+
+// deprecationLogTest checks the deprecated GetUser logs the first time only.
+const deprecationLogTest = `package user
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeprecationLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+	serve(t, g, "GET", "/v1/ping", "")
+	if buf.Len() != 0 {
+		t.Errorf("GET /v1/ping logged %q, want nothing for a method that isn't deprecated", buf.String())
+	}
+	for i := 0; i < 2; i++ {
+		if _, resp := serve(t, g, "GET", "/v1/users/1", ""); resp.Code != 0 {
+			t.Errorf("GET /v1/users/1 = %v, want the deprecated route to still serve", resp)
+		}
+	}
+	if got := strings.Count(buf.String(), "UserService.GetUser is deprecated"); got != 1 {
+		t.Errorf("deprecated GetUser logged %q, want one deprecation line", buf.String())
+	}
+}
+`
+
+func TestGoldenDeprecatedMethod(t *testing.T) {
+	file := goldenUserFile()
+	file.Service[0].Method[0].Options.Deprecated = proto.Bool(true)
+
+	resp := generateGolden(t, "", file)
+	if api := goldenContent(t, resp, "user/user.api.go"); !strings.Contains(api, "\t// Deprecated: Do not use.\n\tGetUser(") || strings.Contains(api, "log.Print") {
+		t.Errorf("user.api.go doesn't mark GetUser deprecated, or logs without deprecation_log:\n%s", api)
+	}
+
+	resp = generateGolden(t, "deprecation_log=true", file)
+	checkGolden(t, "deprecation_log", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":       serveTest,
+		"router/user/handler_test.go":     userHandlerTest("*gin.Context"),
+		"router/user/deprecation_test.go": deprecationLogTest,
+	})
+}
+
+// fieldErrorsTest checks the validation failures of CreateUser are reported per field, with
+// their message under the "message" key.
+const fieldErrorsTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFieldErrors(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+
+	_, resp := serve(t, g, "POST", "/v1/users", ` + "`" + `{"userId": 1}` + "`" + `)
+	errs, _ := resp.Data.([]any)
+	if resp.Code == 0 || len(errs) != 1 {
+		t.Fatalf("POST /v1/users without userName = %v, want one field error", resp)
+	}
+	fe, _ := errs[0].(map[string]any)
+	if msg, _ := fe["message"].(string); fe["field"] != "userName" || msg == "" {
+		t.Errorf("field error = %v, want the userName JSON name and a message", errs[0])
+	}
+
+	_, resp = serve(t, g, "POST", "/v1/users", "{")
+	if _, ok := resp.Data.([]any); resp.Code == 0 || ok {
+		t.Errorf("POST /v1/users with a malformed body = %v, want a regular error", resp)
+	}
+
+	_, resp = serve(t, g, "POST", "/v1/users", ` + "`" + `{"userId": 1, "userName": "a"}` + "`" + `)
+	if resp.Code != 0 {
+		t.Errorf("POST /v1/users with a valid body = %v, want success", resp)
+	}
+}
+`
+
+func TestGoldenFieldErrors(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag validate:required\n", 4, 1, 2, 1)
+
+	resp := generateGolden(t, "field_errors=true", file)
+	checkGolden(t, "field_errors", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":        serveTest,
+		"router/user/handler_test.go":      userHandlerTest("*gin.Context"),
+		"router/user/field_errors_test.go": fieldErrorsTest,
+	})
+}
+
+// packageBasePathTest checks the routes of the acme.user.v1 package are mounted under
+// /acme/user/v1 only.
+const packageBasePathTest = `package user
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPackageBasePath(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+
+	if _, resp := serve(t, g, "GET", "/acme/user/v1/v1/users/1", ""); resp.Code != 0 {
+		t.Errorf("GET /acme/user/v1/v1/users/1 = %v, want success", resp)
+	}
+	if w, _ := serve(t, g, "GET", "/v1/users/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET /v1/users/1 = %d, want the route mounted under the base path only", w.Code)
+	}
+}
+`
+
+// goldenPackageFile returns goldenUserFile declared in the proto package pkg.
+func goldenPackageFile(pkg string) *descriptor.FileDescriptorProto {
+	file := goldenUserFile()
+	file.Package = proto.String(pkg)
+	for _, method := range file.Service[0].Method {
+		method.InputType = proto.String(strings.Replace(method.GetInputType(), ".user.", "."+pkg+".", 1))
+		method.OutputType = proto.String(strings.Replace(method.GetOutputType(), ".user.", "."+pkg+".", 1))
+	}
+	return file
+}
+
+func TestGoldenPackageBasePath(t *testing.T) {
+	resp := generateGolden(t, "package_base_path=true", goldenPackageFile("acme.user.v1"))
+	checkGolden(t, "package_base_path", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":     serveTest,
+		"router/user/handler_test.go":   userHandlerTest("*gin.Context"),
+		"router/user/base_path_test.go": packageBasePathTest,
+	})
+
+	file := goldenPackageFile("acme.user.v1")
+	goldenComment(file, " @tag prefix:/api\n", 6, 0)
+	api := goldenContent(t, generateGolden(t, "package_base_path=true", file), "user/user.api.go")
+	if !strings.Contains(api, `g.GET("/api/v1/users/:user_id"`) || strings.Contains(api, "/acme/user/v1") {
+		t.Errorf("user.api.go doesn't mount the routes under the prefix annotation:\n%s", api)
+	}
+}
+
+// idempotentTest checks CreateUser runs once per Idempotency-Key through the registered
+// IdempotencyFunc, the repeated key getting the recorded response, and on every call
+// without a key.
+const idempotentTest = `package user
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/app/router/router"
+)
+
+type countingHandler struct {
+	userHandler
+	calls *int
+}
+
+func (h countingHandler) CreateUser(ctx *gin.Context, in *User, out *User) error {
+	*h.calls++
+	out.UserId = in.UserId
+	out.UserName = fmt.Sprint("call ", *h.calls)
+	return nil
+}
+
+func TestIdempotent(t *testing.T) {
+	seen := map[string]router.IdempotentResponse{}
+	router.RegisterIdempotency(func(ctx *gin.Context, key string, fn func() router.IdempotentResponse) router.IdempotentResponse {
+		if resp, ok := seen[key]; ok {
+			return resp
+		}
+		seen[key] = fn()
+		return seen[key]
+	})
+	defer router.RegisterIdempotency(nil)
+
+	var calls int
+	g := gin.New()
+	RegisterUserServiceHandler(g, countingHandler{calls: &calls})
+
+	w1, resp1 := serve(t, g, "POST", "/v1/users", ` + "`" + `{"userId": 1}` + "`" + `, "Idempotency-Key", "k1")
+	w2, resp2 := serve(t, g, "POST", "/v1/users", ` + "`" + `{"userId": 1}` + "`" + `, "Idempotency-Key", "k1")
+	if calls != 1 {
+		t.Errorf("CreateUser ran %d times for a repeated key, want 1", calls)
+	}
+	if out, _ := resp1.Data.(map[string]any); resp1.Code != 0 || out["userName"] != "call 1" {
+		t.Errorf("POST /v1/users = %v, want the output of the first call", resp1)
+	}
+	if w2.Code != w1.Code || w2.Body.String() != w1.Body.String() {
+		t.Errorf("POST /v1/users with a repeated key = %d %s, want the recorded %d %s", w2.Code, w2.Body, w1.Code, w1.Body)
+	}
+	if out, _ := resp2.Data.(map[string]any); out["userName"] != "call 1" {
+		t.Errorf("POST /v1/users with a repeated key = %v, want the output of the first call", resp2)
+	}
+
+	for i := 0; i < 2; i++ {
+		serve(t, g, "POST", "/v1/users", ` + "`" + `{"userId": 1}` + "`" + `)
+	}
+	if calls != 3 {
+		t.Errorf("CreateUser ran %d times, want every call without a key to run", calls)
+	}
+}
+`
+
+func TestGoldenIdempotent(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag idempotent:true\n", 6, 0, 2, 1)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "idempotent", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":      serveTest,
+		"router/user/handler_test.go":    userHandlerTest("*gin.Context"),
+		"router/user/idempotent_test.go": idempotentTest,
+	})
+}
+
+// fieldNumbersTest checks Account_fieldNumbers names the fields of Account.
+const fieldNumbersTest = `package user
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldNumbers(t *testing.T) {
+	want := map[string]int32{"Id": 1, "Name": 2, "String_": 5}
+	if !reflect.DeepEqual(Account_fieldNumbers, want) {
+		t.Errorf("Account_fieldNumbers = %v, want %v", Account_fieldNumbers, want)
+	}
+	typ := reflect.TypeOf(Account{})
+	for name := range Account_fieldNumbers {
+		if _, ok := typ.FieldByName(name); !ok {
+			t.Errorf("Account has no field %s", name)
+		}
+	}
+}
+`
+
+func TestGoldenFieldNumbers(t *testing.T) {
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("Account",
+				goldenField("account_id", 1, descriptor.FieldDescriptorProto_TYPE_INT64, ""),
+				goldenField("account_name", 2, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+				goldenField("string", 5, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+			),
+		}, nil, nil)
+
+	resp := generateGolden(t, "field_numbers=true,strip_field_prefix=true", file)
+	checkGolden(t, "field_numbers", resp)
+	compileGolden(t, resp, map[string]string{"router/user/field_numbers_test.go": fieldNumbersTest})
+}
+
+func TestGoldenCommentWrap(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " User is the account of a person signing in, served through `router.Handle(g, verb, path, chain)` by the API.\n", 4, 1)
+	goldenComment(file, " The display name of the user, shown next to every comment they post.\n @tag validate:required,min=2,max=64\n", 4, 1, 2, 1)
+
+	resp := generateGolden(t, "comment_wrap=40", file)
+	checkGolden(t, "comment_wrap", resp)
+
+	model := goldenContent(t, resp, "user/user.model.go")
+	for _, want := range []string{
+		"// User is the account of a person\n// signing in, served through\n// `router.Handle(g, verb, path, chain)`\n// by the API.\n",
+		"\t// The display name of the user, shown\n\t// next to every comment they post.\n",
+		"\t// @tag validate:required,min=2,max=64\n",
+	} {
+		if !strings.Contains(model, want) {
+			t.Errorf("user.model.go doesn't wrap the comments at column 40, want %q:\n%s", want, model)
+		}
+	}
+	compileGolden(t, resp, nil)
+
+	model = goldenContent(t, generateGolden(t, "", file), "user/user.model.go")
+	if !strings.Contains(model, "// User is the account of a person signing in, served through `router.Handle(g, verb, path, chain)` by the API.\n") {
+		t.Errorf("user.model.go wraps the comments without comment_wrap:\n%s", model)
+	}
+}
+
+// sharedChainsTest serves the routes of TestGoldenSharedChains through their shared
+// middleware chains.
+const sharedChainsTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/app/router/router"
+)
+
+func TestSharedChains(t *testing.T) {
+	router.RegisterMiddleware("trace", func(ctx *gin.Context) { ctx.Header("X-Trace", "1") })
+
+	// auth isn't registered yet: the chains using it fail every request.
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+	if _, resp := serve(t, g, "GET", "/v1/users/1", ""); resp.Code != 500 {
+		t.Errorf("GET /v1/users/1 without the auth middleware = %v, want code 500", resp)
+	}
+	if w, resp := serve(t, g, "GET", "/v1/ping", ""); resp.Code != 0 || w.Header().Get("X-Trace") != "1" {
+		t.Errorf("GET /v1/ping = %v, want success through the trace middleware", resp)
+	}
+
+	router.RegisterMiddleware("auth", func(ctx *gin.Context) { ctx.Header("X-Auth", "1") })
+	g = gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+	for _, r := range []struct{ method, target, body string }{
+		{"GET", "/v1/users/1", ""},
+		{"POST", "/v1/users", ` + "`" + `{"userId": 1}` + "`" + `},
+	} {
+		w, resp := serve(t, g, r.method, r.target, r.body)
+		if resp.Code != 0 || w.Header().Get("X-Auth") != "1" || w.Header().Get("X-Trace") != "1" {
+			t.Errorf("%s %s = %v, headers %v, want success through auth and trace", r.method, r.target, resp, w.Header())
+		}
+	}
+}
+`
+
+func TestGoldenSharedChains(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag middleware:auth,trace\n", 6, 0, 2, 0)
+	goldenComment(file, " @tag middleware:auth,trace\n", 6, 0, 2, 1)
+	goldenComment(file, " @tag middleware:trace\n", 6, 0, 2, 2)
+
+	resp := generateGolden(t, "shared_chains=true", file)
+	checkGolden(t, "shared_chains", resp)
+
+	api := goldenContent(t, resp, "user/user.api.go")
+	if n := strings.Count(api, "router.Chain("); n != 2 {
+		t.Errorf("user.api.go resolves %d chains, want one per distinct middleware set:\n%s", n, api)
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":   serveTest,
+		"router/user/handler_test.go": userHandlerTest("*gin.Context"),
+		"router/user/chains_test.go":  sharedChainsTest,
+	})
+}
+
+// retryTest serves GetUser, annotated retry:3 by TestGoldenRetry, through a handler
+// failing a number of times before succeeding.
+const retryTest = `package user
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/app/router/router"
+)
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "unavailable" }
+func (temporaryError) Temporary() bool { return true }
+
+// retryHandler fails with err the first failures calls of GetUser, counted in calls.
+type retryHandler struct {
+	userHandler
+	failures int
+	err      error
+	calls    *int
+}
+
+func (h retryHandler) GetUser(ctx *gin.Context, in *GetUserReq, out *User) error {
+	*h.calls++
+	if *h.calls <= h.failures {
+		out.UserName = "partial"
+		return h.err
+	}
+	return h.userHandler.GetUser(ctx, in, out)
+}
+
+func TestRetry(t *testing.T) {
+	defer func(backoff time.Duration) { router.RetryBackoff = backoff }(router.RetryBackoff)
+	router.RetryBackoff = 0
+
+	tests := []struct {
+		failures int
+		err      error
+		calls    int
+		code     int
+	}{
+		{0, nil, 1, 0},
+		{2, temporaryError{}, 3, 0},
+		{3, temporaryError{}, 3, 500},
+		{1, errors.New("denied"), 1, 500},
+	}
+	for _, tt := range tests {
+		calls := 0
+		g := gin.New()
+		RegisterUserServiceHandler(g, retryHandler{failures: tt.failures, err: tt.err, calls: &calls})
+
+		_, resp := serve(t, g, "GET", "/v1/users/1", "")
+		if resp.Code != tt.code || calls != tt.calls {
+			t.Errorf("GET /v1/users/1 failing %d times with %v = %v after %d calls, want code %d after %d calls", tt.failures, tt.err, resp, calls, tt.code, tt.calls)
+		}
+		// The output of a failed attempt is cleared before the next one.
+		if data, _ := resp.Data.(map[string]any); tt.code == 0 && data["userName"] != "user1" {
+			t.Errorf("GET /v1/users/1 failing %d times = %v, want the output of the last attempt", tt.failures, resp)
+		}
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	defer func(backoff time.Duration) { router.RetryBackoff = backoff }(router.RetryBackoff)
+	router.RetryBackoff = time.Hour
+
+	calls := 0
+	g := gin.New()
+	RegisterUserServiceHandler(g, retryHandler{failures: 2, err: temporaryError{}, calls: &calls})
+
+	// The canceled request stops waiting for the next attempt.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest("GET", "/v1/users/1", nil).WithContext(ctx))
+	if calls != 1 {
+		t.Errorf("canceled GET /v1/users/1 called GetUser %d times, want 1", calls)
+	}
+}
+`
+
+func TestGoldenRetry(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag retry:3\n", 6, 0, 2, 0)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "retry", resp)
+	if api := goldenContent(t, resp, "user/user.api.go"); !strings.Contains(api, "router.Retry(ctx.Request.Context(), 3, func() error {") {
+		t.Errorf("user.api.go doesn't retry GetUser:\n%s", api)
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":   serveTest,
+		"router/user/handler_test.go": userHandlerTest("*gin.Context"),
+		"router/user/retry_test.go":   retryTest,
+	})
+
+	file = goldenUserFile()
+	goldenComment(file, " @tag retry:3\n", 6, 0, 2, 1)
+	if _, err := Run(generateRequest(t, "", file)); err == nil || !strings.Contains(err.Error(), "only idempotent verbs can be retried") {
+		t.Errorf("Run = %v, want an error for retrying the POST CreateUser", err)
+	}
+}
+
+// headerFieldsTest binds the request_id and limit fields of GetUserReq from the headers
+// named by their annotations in TestGoldenHeaderFields.
+const headerFieldsTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// headerHandler returns the user named after the request id, its id the limit.
+type headerHandler struct{ userHandler }
+
+func (headerHandler) GetUser(ctx *gin.Context, in *GetUserReq, out *User) error {
+	out.UserId, out.UserName = int64(in.Limit), in.RequestId
+	return nil
+}
+
+func TestHeaderFields(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, headerHandler{})
+
+	_, resp := serve(t, g, "GET", "/v1/users/1?request_id=query&limit=3", "", "X-Request-Id", "abc", "X-Limit", "20")
+	if data, _ := resp.Data.(map[string]any); resp.Code != 0 || data["userName"] != "abc" || data["userId"] != float64(20) {
+		t.Errorf("GET /v1/users/1 = %v, want the fields bound from the headers", resp)
+	}
+	// Absent headers leave the values bound from the query.
+	_, resp = serve(t, g, "GET", "/v1/users/1?request_id=query&limit=3", "")
+	if data, _ := resp.Data.(map[string]any); resp.Code != 0 || data["userName"] != "query" || data["userId"] != float64(3) {
+		t.Errorf("GET /v1/users/1 without headers = %v, want the fields bound from the query", resp)
+	}
+	if _, resp := serve(t, g, "GET", "/v1/users/1", "", "X-Limit", "many"); resp.Code == 0 {
+		t.Errorf("GET /v1/users/1 with X-Limit many = %v, want an error", resp)
+	}
+}
+`
+
+func TestGoldenHeaderFields(t *testing.T) {
+	file := goldenUserFile()
+	req := file.MessageType[0]
+	req.Field = append(req.Field,
+		goldenField("request_id", 2, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+		goldenField("limit", 3, descriptor.FieldDescriptorProto_TYPE_INT32, ""),
+	)
+	goldenComment(file, " @tag header:X-Request-Id\n", 4, 0, 2, 1)
+	goldenComment(file, " @tag header:X-Limit\n", 4, 0, 2, 2)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "header_fields", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":   serveTest,
+		"router/user/handler_test.go": userHandlerTest("*gin.Context"),
+		"router/user/header_test.go":  headerFieldsTest,
+	})
+
+	file = goldenUserFile()
+	goldenComment(file, " @tag header:X-Tags\n", 4, 1, 2, 2)
+	if _, err := Run(generateRequest(t, "", file)); err == nil || !strings.Contains(err.Error(), "which is repeated") {
+		t.Errorf("Run = %v, want an error for binding a header to the repeated tags", err)
+	}
+}
+
+// cacheControlTest checks the successful responses of GetUser, annotated
+// cachecontrol:"public, max-age=60" by TestGoldenCacheControl, are cacheable.
+const cacheControlTest = `package user
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// missingUserHandler fails to find the user 0.
+type missingUserHandler struct{ userHandler }
+
+func (h missingUserHandler) GetUser(ctx *gin.Context, in *GetUserReq, out *User) error {
+	if in.UserId == 0 {
+		return errors.New("not found")
+	}
+	return h.userHandler.GetUser(ctx, in, out)
+}
+
+func TestCacheControl(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, missingUserHandler{})
+
+	tests := []struct {
+		target, want string
+	}{
+		{"/v1/users/1", "public, max-age=60"},
+		// Errors aren't cached.
+		{"/v1/users/0", ""},
+		{"/v1/ping", ""},
+	}
+	for _, tt := range tests {
+		if w, _ := serve(t, g, "GET", tt.target, ""); w.Header().Get("Cache-Control") != tt.want {
+			t.Errorf("GET %s Cache-Control = %q, want %q", tt.target, w.Header().Get("Cache-Control"), tt.want)
+		}
+	}
+}
+`
+
+func TestGoldenCacheControl(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, ` @tag cachecontrol:"public, max-age=60"`+"\n", 6, 0, 2, 0)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "cache_control", resp)
+	if api := goldenContent(t, resp, "user/user.api.go"); !strings.Contains(api, `ctx.Header("Cache-Control", "public, max-age=60")`) {
+		t.Errorf("user.api.go doesn't set the Cache-Control of GetUser:\n%s", api)
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":         serveTest,
+		"router/user/handler_test.go":       userHandlerTest("*gin.Context"),
+		"router/user/cache_control_test.go": cacheControlTest,
+	})
+
+	file = goldenUserFile()
+	goldenComment(file, " @tag cachecontrol:no-store\n", 6, 0, 2, 1)
+	if _, err := Run(generateRequest(t, "", file)); err == nil || !strings.Contains(err.Error(), "only GET and HEAD responses are cacheable") {
+		t.Errorf("Run = %v, want an error for caching the POST CreateUser", err)
+	}
+}
+
+// strictJSONTest checks CreateUser rejects the bodies with unknown fields.
+const strictJSONTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStrictJSON(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+
+	if _, resp := serve(t, g, "POST", "/v1/users", ` + "`" + `{"userId": 1}` + "`" + `); resp.Code != 0 {
+		t.Errorf("POST /v1/users = %v, want success", resp)
+	}
+	if _, resp := serve(t, g, "POST", "/v1/users", ` + "`" + `{"userId": 1, "nick": "ann"}` + "`" + `); resp.Code == 0 {
+		t.Errorf("POST /v1/users with an unknown field = %v, want an error", resp)
+	}
+}
+`
+
+func TestGoldenStrictJSON(t *testing.T) {
+	tests := []struct {
+		param, golden, annotation string
+	}{
+		{"", "strict_json_method", " @tag strictjson:true\n"},
+		{"strict_json=true", "strict_json", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			file := goldenUserFile()
+			if tt.annotation != "" {
+				goldenComment(file, tt.annotation, 6, 0, 2, 1)
+			}
+
+			resp := generateGolden(t, tt.param, file)
+			checkGolden(t, tt.golden, resp)
+			if api := goldenContent(t, resp, "user/user.api.go"); !strings.Contains(api, "ctx.ShouldBindBodyWith(&input, router.StrictJSON)") {
+				t.Errorf("user.api.go doesn't bind CreateUser through router.StrictJSON:\n%s", api)
+			}
+			compileGolden(t, resp, map[string]string{
+				"router/user/serve_test.go":   serveTest,
+				"router/user/handler_test.go": userHandlerTest("*gin.Context"),
+				"router/user/strict_test.go":  strictJSONTest,
+			})
+		})
+	}
+
+	// strictjson:false opts a method out of strict_json=true.
+	file := goldenUserFile()
+	goldenComment(file, " @tag strictjson:false\n", 6, 0, 2, 1)
+	if api := goldenContent(t, generateGolden(t, "strict_json=true", file), "user/user.api.go"); strings.Contains(api, "router.StrictJSON") {
+		t.Errorf("user.api.go binds CreateUser through router.StrictJSON despite strictjson:false:\n%s", api)
+	}
+}
+
+// trailingSlashTest requests the routes of TestGoldenTrailingSlash with a trailing slash:
+// GetUser is redirected, CreateUser stripped and Ping strict.
+const trailingSlashTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTrailingSlash(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+
+	if w, _ := serve(t, g, "GET", "/v1/users/1/?x=1", ""); w.Code != 301 || w.Header().Get("Location") != "/v1/users/1?x=1" {
+		t.Errorf("GET /v1/users/1/ = %d to %q, want 301 to /v1/users/1?x=1", w.Code, w.Header().Get("Location"))
+	}
+	if _, resp := serve(t, g, "POST", "/v1/users/", ` + "`" + `{"userId": 1}` + "`" + `); resp.Code != 0 {
+		t.Errorf("POST /v1/users/ = %v, want success", resp)
+	}
+	// gin would redirect /v1/ping/ without the annotation.
+	if w, _ := serve(t, g, "GET", "/v1/ping/", ""); w.Code != 404 {
+		t.Errorf("GET /v1/ping/ = %d, want 404", w.Code)
+	}
+	if _, resp := serve(t, g, "GET", "/v1/ping", ""); resp.Code != 0 {
+		t.Errorf("GET /v1/ping = %v, want success", resp)
+	}
+}
+`
+
+func TestGoldenTrailingSlash(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag trailingslash:redirect\n", 6, 0, 2, 0)
+	goldenComment(file, " @tag trailingslash:strip\n", 6, 0, 2, 1)
+	goldenComment(file, " @tag trailingslash:strict\n", 6, 0, 2, 2)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "trailing_slash", resp)
+	if api := goldenContent(t, resp, "user/user.api.go"); !strings.Contains(api, `router.TrailingSlash(g, "GET", "/v1/users/:user_id", "redirect")`) {
+		t.Errorf("user.api.go doesn't redirect the trailing slash of GetUser:\n%s", api)
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":          serveTest,
+		"router/user/handler_test.go":        userHandlerTest("*gin.Context"),
+		"router/user/trailing_slash_test.go": trailingSlashTest,
+	})
+
+	file = goldenUserFile()
+	goldenComment(file, " @tag trailingslash:keep\n", 6, 0, 2, 0)
+	if _, err := Run(generateRequest(t, "", file)); err == nil || !strings.Contains(err.Error(), `is not one of "redirect", "strip" or "strict"`) {
+		t.Errorf("Run = %v, want an error for trailingslash:keep", err)
+	}
+}
+
+// registerAllTest serves UserService and AdminService of TestGoldenRegisterAll through a
+// single RegisterAll.
+const registerAllTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type allHandler struct{ userHandler }
+
+func (allHandler) Reset(ctx *gin.Context, in *Empty, out *Empty) error {
+	return nil
+}
+
+func TestRegisterAll(t *testing.T) {
+	g := gin.New()
+	RegisterAll(g, allHandler{})
+
+	for _, target := range []string{"/v1/users/1", "/v1/ping", "/v1/admin/reset"} {
+		if w, resp := serve(t, g, "GET", target, ""); w.Code != 200 || resp.Code != 0 {
+			t.Errorf("GET %s = %d %v, want success", target, w.Code, resp)
+		}
+	}
+}
+`
+
+func TestGoldenRegisterAll(t *testing.T) {
+	file := goldenUserFile()
+	file.Service = append(file.Service, goldenService("AdminService",
+		goldenMethod("Reset", ".user.Empty", ".user.Empty", "GET", "/v1/admin/reset"),
+	))
+
+	resp := generateGolden(t, "gen_register_all=true", file)
+	checkGolden(t, "register_all", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":        serveTest,
+		"router/user/handler_test.go":      userHandlerTest("*gin.Context"),
+		"router/user/register_all_test.go": registerAllTest,
+	})
+
+	file.Service[1].Method = append(file.Service[1].Method, goldenMethod("Ping", ".user.Empty", ".user.Empty", "GET", "/v1/admin/ping"))
+	if _, err := Run(generateRequest(t, "gen_register_all=true", file)); err == nil || !strings.Contains(err.Error(), "both declare Ping") {
+		t.Errorf("Run = %v, want an error for the Ping methods of both services", err)
+	}
+}
+
+// middlewareFuncsPkg is the middleware_pkg of TestGoldenMiddlewareFuncs.
+const middlewareFuncsPkg = `package mw
+
+import "github.com/gin-gonic/gin"
+
+func Auth(ctx *gin.Context) { ctx.Header("X-Auth", "1") }
+
+func RateLimit(ctx *gin.Context) { ctx.Header("X-Rate-Limit", "1") }
+`
+
+// middlewareFuncsTest serves the routes of TestGoldenMiddlewareFuncs through the functions
+// of middlewareFuncsPkg, no middleware being registered by name.
+const middlewareFuncsTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMiddlewareFuncs(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+
+	w, resp := serve(t, g, "GET", "/v1/users/1", "")
+	if resp.Code != 0 || w.Header().Get("X-Auth") != "1" || w.Header().Get("X-Rate-Limit") != "1" {
+		t.Errorf("GET /v1/users/1 = %v, headers %v, want success through Auth and RateLimit", resp, w.Header())
+	}
+	if w, resp := serve(t, g, "GET", "/v1/ping", ""); resp.Code != 0 || w.Header().Get("X-Auth") != "" {
+		t.Errorf("GET /v1/ping = %v, headers %v, want success without middlewares", resp, w.Header())
+	}
+}
+`
+
+func TestGoldenMiddlewareFuncs(t *testing.T) {
+	for _, tt := range []struct{ param, golden string }{
+		{"middleware_mode=func,middleware_pkg=example.com/app/mw", "middleware_funcs"},
+		{"middleware_mode=func,middleware_pkg=example.com/app/mw,shared_chains=true", "middleware_funcs_shared"},
+	} {
+		t.Run(tt.golden, func(t *testing.T) {
+			file := goldenUserFile()
+			goldenComment(file, " @tag middleware:auth,rate_limit\n", 6, 0, 2, 0)
+
+			resp := generateGolden(t, tt.param, file)
+			checkGolden(t, tt.golden, resp)
+			if api := goldenContent(t, resp, "user/user.api.go"); !strings.Contains(api, "gin.HandlersChain{mw.Auth, mw.RateLimit}") {
+				t.Errorf("user.api.go doesn't reference the middleware functions:\n%s", api)
+			}
+			compileGolden(t, resp, map[string]string{
+				"mw/mw.go":                    middlewareFuncsPkg,
+				"router/user/serve_test.go":   serveTest,
+				"router/user/handler_test.go": userHandlerTest("*gin.Context"),
+				"router/user/mw_test.go":      middlewareFuncsTest,
+			})
+		})
+	}
+
+	if _, err := Run(generateRequest(t, "middleware_mode=func", goldenUserFile())); err == nil || !strings.Contains(err.Error(), "requires middleware_pkg") {
+		t.Errorf("Run = %v, want an error for the missing middleware_pkg", err)
+	}
+}
+
+// formKeyTest checks ListUsers binds the user_name query parameter from the key WANT,
+// and not from OTHER.
+const formKeyTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type listHandler struct{ userHandler }
+
+func (listHandler) ListUsers(ctx *gin.Context, in *User, out *User) error {
+	*out = *in
+	return nil
+}
+
+func TestFormKey(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, listHandler{})
+
+	if _, resp := serve(t, g, "GET", "/v1/users?WANT=ann", ""); resp.Code != 0 || resp.Data.(map[string]any)["userName"] != "ann" {
+		t.Errorf("GET /v1/users?WANT=ann = %v, want userName ann", resp)
+	}
+	if _, resp := serve(t, g, "GET", "/v1/users?OTHER=ann", ""); resp.Code != 0 || resp.Data.(map[string]any)["userName"] != nil {
+		t.Errorf("GET /v1/users?OTHER=ann = %v, want no userName", resp)
+	}
+}
+`
+
+func TestGoldenFormKey(t *testing.T) {
+	tests := []struct {
+		param, golden, want, other string
+	}{
+		{"", "form_key_proto", "user_name", "userName"},
+		{"form_key=json", "form_key_json", "userName", "user_name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			file := goldenUserFile()
+			file.Service[0].Method = append(file.Service[0].Method, goldenMethod("ListUsers", ".user.User", ".user.User", "GET", "/v1/users"))
+
+			resp := generateGolden(t, tt.param, file)
+			checkGolden(t, tt.golden, resp)
+
+			model := goldenContent(t, resp, "user/user.model.go")
+			if want := `json:"userName,omitempty" form:"` + tt.want + `"`; !strings.Contains(model, want) {
+				t.Errorf("user.model.go has no %s:\n%s", want, model)
+			}
+			compileGolden(t, resp, map[string]string{
+				"router/user/serve_test.go":    serveTest,
+				"router/user/handler_test.go":  userHandlerTest("*gin.Context"),
+				"router/user/form_key_test.go": strings.NewReplacer("WANT", tt.want, "OTHER", tt.other).Replace(formKeyTest),
+			})
+		})
+	}
+}
+
+// streamDecodeTest checks CreateUser is called once per element of the JSON array body.
+const streamDecodeTest = `package user
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type collectHandler struct {
+	userHandler
+	calls *int
+}
+
+func (h collectHandler) CreateUser(ctx *gin.Context, in *User, out *User) error {
+	*h.calls++
+	if in.UserId == 0 {
+		return errors.New("no user_id")
+	}
+	out.Tags = append(out.Tags, fmt.Sprint(in.UserId))
+	return nil
+}
+
+func TestStreamDecode(t *testing.T) {
+	var calls int
+	g := gin.New()
+	RegisterUserServiceHandler(g, collectHandler{calls: &calls})
+
+	_, resp := serve(t, g, "POST", "/v1/users", ` + "`" + `[{"userId": 1}, {"userId": 2}]` + "`" + `)
+	if out, _ := resp.Data.(map[string]any); resp.Code != 0 || calls != 2 || fmt.Sprint(out["tags"]) != "[1 2]" {
+		t.Errorf("POST /v1/users with two elements = %v after %d calls, want tags [1 2] after 2", resp, calls)
+	}
+
+	calls = 0
+	_, resp = serve(t, g, "POST", "/v1/users", ` + "`" + `[{"userId": 1}, {}, {"userId": 3}]` + "`" + `)
+	if resp.Code == 0 || calls != 2 {
+		t.Errorf("POST /v1/users with a failing element = %v after %d calls, want an error after 2", resp, calls)
+	}
+
+	calls = 0
+	if _, resp := serve(t, g, "POST", "/v1/users", ` + "`" + `{"userId": 1}` + "`" + `); resp.Code == 0 || calls != 0 {
+		t.Errorf("POST /v1/users with an object = %v after %d calls, want an error before any call", resp, calls)
+	}
+}
+`
+
+func TestGoldenStreamDecode(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag stream_decode:true\n", 6, 0, 2, 1)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "stream_decode", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":         serveTest,
+		"router/user/handler_test.go":       userHandlerTest("*gin.Context"),
+		"router/user/stream_decode_test.go": streamDecodeTest,
+	})
+}
+
+// unexportedNameTest uses the message of TestGoldenUnexportedName from another package.
+const unexportedNameTest = `package other
+
+import "example.com/app/router/user"
+
+var _ = user.Éclair{Ñame: "crème", Size: 2}
+`
+
+func TestGoldenUnexportedName(t *testing.T) {
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("éclair",
+				goldenField("ñame", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+				goldenField("size", 2, descriptor.FieldDescriptorProto_TYPE_INT64, ""),
+			),
+		}, nil, nil)
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "unexported_name", resp)
+	for _, want := range []string{"éclair would be unexported, generating Éclair", "ñame would be unexported, generating Ñame"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log %q has no warning %q", buf.String(), want)
+		}
+	}
+	compileGolden(t, resp, map[string]string{"router/other/other.go": unexportedNameTest})
+}
+
+func TestUnexportedNameWarnings(t *testing.T) {
+	file := goldenUserFile()
+	file.MessageType = append(file.MessageType, goldenMessage("éclair"))
+	// Only the names of types and fields are forced exported, not the service name.
+	file.Service[0].Name = proto.String("ñService")
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	// Every run warns again, the names warned about aren't kept across runs.
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+		if _, err := Run(generateRequest(t, "", file)); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		if !strings.Contains(buf.String(), "éclair would be unexported") {
+			t.Errorf("run %d logged %q, want the warning about éclair", i+1, buf.String())
+		}
+		if strings.Contains(buf.String(), "ñService") {
+			t.Errorf("run %d logged %q, want no warning about the service name", i+1, buf.String())
+		}
+	}
+}
+
+// statusTest checks CreateUser answers 201 and the other methods 200.
+const statusTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStatus(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+
+	if w, resp := serve(t, g, "POST", "/v1/users", ` + "`" + `{"userId": 1}` + "`" + `); w.Code != 201 || resp.Code != 0 {
+		t.Errorf("POST /v1/users = %d %v, want 201 and success", w.Code, resp)
+	}
+	if w, resp := serve(t, g, "GET", "/v1/users/1", ""); w.Code != 200 || resp.Code != 0 {
+		t.Errorf("GET /v1/users/1 = %d %v, want 200 and success", w.Code, resp)
+	}
+}
+`
+
+func TestGoldenStatus(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag status:201\n", 6, 0, 2, 1)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "status", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":   serveTest,
+		"router/user/handler_test.go": userHandlerTest("*gin.Context"),
+		"router/user/status_test.go":  statusTest,
+	})
+}
+
+// transformTest applies the transforms registered in the router package to an Account.
+const transformTest = `package user
+
+import (
+	"testing"
+
+	"example.com/app/router/router"
+)
+
+func TestApplyTransforms(t *testing.T) {
+	a := &Account{Email: "ann@example.com", Password: "secret", Name: "ann"}
+	if err := a.ApplyTransforms(); err == nil {
+		t.Errorf("ApplyTransforms without registered transforms = nil, want an error")
+	}
+
+	router.RegisterTransform("encrypt", func(v string) (string, error) { return "enc(" + v + ")", nil })
+	router.RegisterTransform("hash", func(v string) (string, error) { return "hash(" + v + ")", nil })
+	a = &Account{Email: "ann@example.com", Password: "secret", Name: "ann"}
+	if err := a.ApplyTransforms(); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Account{Email: "enc(ann@example.com)", Password: "hash(secret)", Name: "ann"}); *a != want {
+		t.Errorf("ApplyTransforms = %+v, want %+v", *a, want)
+	}
+}
+`
+
+func TestGoldenTransform(t *testing.T) {
+	account := func(transform string) *descriptor.FileDescriptorProto {
+		file := goldenFile("user/user.proto",
+			[]*descriptor.DescriptorProto{
+				goldenMessage("Account",
+					goldenField("email", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+					goldenField("password", 2, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+					goldenField("name", 3, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+				),
+			}, nil, nil)
+		goldenComment(file, " @tag transform:encrypt\n", 4, 0, 2, 0)
+		goldenComment(file, " @tag transform:"+transform+"\n", 4, 0, 2, 1)
+		return file
+	}
+
+	resp := generateGolden(t, "", account("hash"))
+	checkGolden(t, "transform", resp)
+	compileGolden(t, resp, map[string]string{"router/user/transform_test.go": transformTest})
+
+	_, err := Run(generateRequest(t, "", account("rot13")))
+	if want := "transform: rot13 of field password is not a known transform"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Run = %v, want an error containing %q", err, want)
+	}
+}
+
+// accessLogTest checks the access lines logged for CreateUser, counting the bytes read from
+// chunked bodies, which have no Content-Length, and logging the error of a failed request.
+const accessLogTest = `package user
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+
+	body := ` + "`" + `{"userId": 1}` + "`" + `
+	w, _ := serve(t, g, "POST", "/v1/users", body)
+	want := fmt.Sprintf("UserService.CreateUser POST /v1/users status=200 in=%d out=%d duration=", len(body), w.Body.Len())
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("access log %q, want a line containing %q", buf.String(), want)
+	}
+
+	// The MultiReader hides the length of the body: the request is chunked.
+	buf.Reset()
+	req := httptest.NewRequest("POST", "/v1/users", io.MultiReader(strings.NewReader(body)))
+	req.Header.Set("Content-Type", "application/json")
+	g.ServeHTTP(httptest.NewRecorder(), req)
+	if req.ContentLength != -1 || !strings.Contains(buf.String(), fmt.Sprintf(" in=%d ", len(body))) {
+		t.Errorf("access log %q of a chunked body, want in=%d", buf.String(), len(body))
+	}
+
+	buf.Reset()
+	serve(t, g, "POST", "/v1/users", "{")
+	if !strings.Contains(buf.String(), " error=") {
+		t.Errorf("access log %q of a failed request, want its error", buf.String())
+	}
+}
+`
+
+func TestGoldenAccessLog(t *testing.T) {
+	resp := generateGolden(t, "access_log=true", goldenUserFile())
+	checkGolden(t, "access_log", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":      serveTest,
+		"router/user/handler_test.go":    userHandlerTest("*gin.Context"),
+		"router/user/access_log_test.go": accessLogTest,
+	})
+}
+
+// accessLogFieldErrorsTest checks a maxlen field error is logged as the error of the request.
+const accessLogFieldErrorsTest = `package user
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAccessLogFieldErrors(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+
+	_, resp := serve(t, g, "POST", "/v1/users", ` + "`" + `{"userName": "annabel"}` + "`" + `)
+	if errs, _ := resp.Data.([]any); resp.Code != 400 || len(errs) != 1 {
+		t.Errorf("POST /v1/users with a long userName = %v, want one field error", resp)
+	}
+	if want := ` + "`" + `error="userName is longer than 5"` + "`" + `; !strings.Contains(buf.String(), want) {
+		t.Errorf("access log %q, want a line containing %q", buf.String(), want)
+	}
+}
+`
+
+func TestGoldenAccessLogFieldErrors(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag maxlen:5\n", 4, 1, 2, 1)
+
+	resp := generateGolden(t, "access_log=true,field_errors=true", file)
+	checkGolden(t, "access_log_field_errors", resp)
+	api := goldenContent(t, resp, "user/user.api.go")
+	for _, want := range []string{
+		`err := errors.New("userName is longer than 5")`,
+		`router.FieldError(ctx, 400, router.FieldErrors{{"field": "userName", "message": err.Error()}})`,
+	} {
+		if !strings.Contains(api, want) {
+			t.Errorf("user.api.go has no %s:\n%s", want, api)
+		}
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":      serveTest,
+		"router/user/handler_test.go":    userHandlerTest("*gin.Context"),
+		"router/user/access_log_test.go": accessLogFieldErrorsTest,
+	})
+}
+
+// metricsTest checks the duration of a request is observed with the labels of its route
+// once the response is written.
+const metricsTest = `package user
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/app/router/router"
+)
+
+func TestMetrics(t *testing.T) {
+	var labels []map[string]string
+	router.RegisterObserveFunc(func(l map[string]string, seconds float64) {
+		if seconds < 0 {
+			t.Errorf("observed %v seconds", seconds)
+		}
+		labels = append(labels, l)
+	})
+	defer router.RegisterObserveFunc(nil)
+
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+
+	serve(t, g, "GET", "/v1/users/1", "")
+	want := []map[string]string{{"service": "UserService", "method": "GetUser", "status": "2xx"}}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("observed labels %v, want %v", labels, want)
+	}
+
+	for status, want := range map[int]string{200: "2xx", 404: "4xx", 503: "5xx"} {
+		if got := router.StatusClass(status); got != want {
+			t.Errorf("StatusClass(%d) = %q, want %q", status, got, want)
+		}
+	}
+}
+`
+
+func TestGoldenMetrics(t *testing.T) {
+	resp := generateGolden(t, "metrics=true", goldenUserFile())
+	checkGolden(t, "metrics", resp)
+	if api := goldenContent(t, resp, "user/user.api.go"); !strings.Contains(api, `defer router.Metrics(ctx, "UserService", "GetUser")()`) {
+		t.Errorf("user.api.go doesn't defer router.Metrics:\n%s", api)
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":   serveTest,
+		"router/user/handler_test.go": userHandlerTest("*gin.Context"),
+		"router/user/metrics_test.go": metricsTest,
+	})
+}
+
+// enumCodesTest converts Tier values to and from their external codes.
+const enumCodesTest = `package user
+
+import "testing"
+
+func TestEnumCodes(t *testing.T) {
+	if got := Tier_TIER_SILVER.ToCode(); got != "B2" {
+		t.Errorf("Tier_TIER_SILVER.ToCode() = %q, want B2", got)
+	}
+	if got := Tier_TIER_UNKNOWN.ToCode(); got != "" {
+		t.Errorf("Tier_TIER_UNKNOWN.ToCode() = %q, want no code", got)
+	}
+
+	var v Tier
+	if err := v.FromCode("A1"); err != nil || v != Tier_TIER_GOLD {
+		t.Errorf("FromCode(A1) = %v, %v, want Tier_TIER_GOLD", v, err)
+	}
+	if err := v.FromCode("Z9"); err == nil || v != Tier_TIER_GOLD {
+		t.Errorf("FromCode(Z9) = %v, %v, want an error leaving the value as it was", v, err)
+	}
+}
+`
+
+func TestGoldenEnumCodes(t *testing.T) {
+	tier := func(silver string) *descriptor.FileDescriptorProto {
+		file := goldenFile("user/user.proto", nil,
+			[]*descriptor.EnumDescriptorProto{
+				goldenEnum("Tier", []string{"TIER_UNKNOWN", "TIER_GOLD", "TIER_SILVER"}, []int32{0, 1, 2}),
+			}, nil)
+		goldenComment(file, " @tag code:A1\n", 5, 0, 2, 1)
+		goldenComment(file, " @tag code:"+silver+"\n", 5, 0, 2, 2)
+		return file
+	}
+
+	resp := generateGolden(t, "", tier("B2"))
+	checkGolden(t, "enum_codes", resp)
+	compileGolden(t, resp, map[string]string{"router/user/enum_codes_test.go": enumCodesTest})
+
+	_, err := Run(generateRequest(t, "", tier("A1")))
+	if want := "code: A1 is used by several values of Tier"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Run = %v, want an error containing %q", err, want)
+	}
+}
+
+// lazyEnumMapsTest checks that the Status maps are built on the first lookup, and only then.
+const lazyEnumMapsTest = `package user
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestLazyEnumMaps(t *testing.T) {
+	if lazyStatus_name != nil || lazyStatus_value != nil {
+		t.Fatal("Status maps built before the first lookup")
+	}
+
+	if got := StatusName(1); got != "STATUS_ACTIVE" {
+		t.Errorf("StatusName(1) = %q, want STATUS_ACTIVE", got)
+	}
+	names, values := reflect.ValueOf(lazyStatus_name).Pointer(), reflect.ValueOf(lazyStatus_value).Pointer()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, ok := StatusValue("STATUS_ENABLED"); !ok || v != 1 {
+				t.Errorf("StatusValue(STATUS_ENABLED) = %d, %v, want 1, true", v, ok)
+			}
+		}()
+	}
+	wg.Wait()
+	if s, err := ParseStatus("STATUS_UNKNOWN"); err != nil || s != Status_STATUS_UNKNOWN {
+		t.Errorf("ParseStatus(STATUS_UNKNOWN) = %v, %v", s, err)
+	}
+	if _, ok := StatusValue("STATUS_MISSING"); ok {
+		t.Error("StatusValue(STATUS_MISSING) found a value")
+	}
+
+	if reflect.ValueOf(lazyStatus_name).Pointer() != names || reflect.ValueOf(lazyStatus_value).Pointer() != values {
+		t.Error("Status maps built more than once")
+	}
+}
+`
+
+func TestGoldenLazyEnumMaps(t *testing.T) {
+	status := func(messages ...*descriptor.DescriptorProto) *descriptor.FileDescriptorProto {
+		return goldenFile("user/user.proto", messages,
+			[]*descriptor.EnumDescriptorProto{
+				goldenEnum("Status", []string{"STATUS_UNKNOWN", "STATUS_ACTIVE", "STATUS_ENABLED"}, []int32{0, 1, 1}),
+			}, nil)
+	}
+
+	resp := generateGolden(t, "lazy_enum_maps=true,enum_parse=true", status())
+	checkGolden(t, "lazy_enum_maps", resp)
+	compileGolden(t, resp, map[string]string{"router/user/lazy_enum_maps_test.go": lazyEnumMapsTest})
+
+	_, err := Run(generateRequest(t, "lazy_enum_maps=true", status(goldenMessage("StatusName"))))
+	if want := "accessors of Status collide with message StatusName"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Run = %v, want an error containing %q", err, want)
+	}
+}
+
+// serverBuilderTest serves UserService through the server built by NewUserServiceServer.
+const serverBuilderTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/app/router/router"
+)
+
+func TestNewServer(t *testing.T) {
+	router.RegisterMiddleware("trace", func(ctx *gin.Context) { ctx.Header("X-Trace", "1") })
+
+	srv := NewUserServiceServer(userHandler{}, ":8080")
+	if srv.Addr != ":8080" {
+		t.Errorf("Addr = %q, want :8080", srv.Addr)
+	}
+	if _, resp := serve(t, srv.Handler, "GET", "/v1/users/1", ""); resp.Code != 0 {
+		t.Errorf("GET /v1/users/1 = %v, want success", resp)
+	}
+	if w, resp := serve(t, srv.Handler, "GET", "/v1/ping", ""); resp.Code != 0 || w.Header().Get("X-Trace") != "1" {
+		t.Errorf("GET /v1/ping = %v, want success through the trace middleware", resp)
+	}
+}
+`
+
+func TestGoldenServerBuilder(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag middleware:trace\n", 6, 0, 2, 2)
+
+	resp := generateGolden(t, "server_builder=true", file)
+	checkGolden(t, "server_builder", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":   serveTest,
+		"router/user/handler_test.go": userHandlerTest("*gin.Context"),
+		"router/user/server_test.go":  serverBuilderTest,
+	})
+}
+
+// fieldNumberTagTest reads the order tags of Account.
+const fieldNumberTagTest = `package user
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOrderTags(t *testing.T) {
+	typ := reflect.TypeOf(Account{})
+	for name, want := range map[string]string{"Name": "3", "Email": "7", "Tags": "12"} {
+		f, _ := typ.FieldByName(name)
+		if got := f.Tag.Get("order"); got != want {
+			t.Errorf("order tag of %s = %q, want %q", name, got, want)
+		}
+		if f.Tag.Get("json") == "" {
+			t.Errorf("%s lost its json tag", name)
+		}
+	}
+}
+`
+
+func TestGoldenFieldNumberTag(t *testing.T) {
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("Account",
+				goldenField("name", 3, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+				goldenField("email", 7, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+				goldenRepeated(goldenField("tags", 12, descriptor.FieldDescriptorProto_TYPE_STRING, "")),
+			),
+		}, nil, nil)
+
+	resp := generateGolden(t, "field_number_tag=true", file)
+	checkGolden(t, "field_number_tag", resp)
+	compileGolden(t, resp, map[string]string{"router/user/order_test.go": fieldNumberTagTest})
+}
+
+// decompressTest posts gzipped and plain bodies to CreateUser.
+const decompressTest = `package user
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/app/router/router"
+)
+
+// decompressHandler records the Content-Encoding and length of the CreateUser requests.
+type decompressHandler struct {
+	userHandler
+	encoding *string
+	length   *int64
+}
+
+func (h decompressHandler) CreateUser(ctx *gin.Context, in *User, out *User) error {
+	*h.encoding, *h.length = ctx.GetHeader("Content-Encoding"), ctx.Request.ContentLength
+	return h.userHandler.CreateUser(ctx, in, out)
+}
+
+func TestDecompressRequest(t *testing.T) {
+	var encoding string
+	var length int64
+	g := gin.New()
+	RegisterUserServiceHandler(g, decompressHandler{encoding: &encoding, length: &length})
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(` + "`" + `{"userId": 1, "userName": "ann"}` + "`" + `))
+	zw.Close()
+
+	_, resp := serve(t, g, "POST", "/v1/users", buf.String(), "Content-Encoding", "gzip")
+	if out, _ := resp.Data.(map[string]any); resp.Code != 0 || out["userName"] != "ann" {
+		t.Errorf("POST /v1/users gzipped = %v, want userName ann", resp)
+	}
+	// The handler sees the request as it is once decompressed.
+	if encoding != "" || length != -1 {
+		t.Errorf("POST /v1/users gzipped reached CreateUser with Content-Encoding %q and length %d, want none and -1", encoding, length)
+	}
+	if _, resp := serve(t, g, "POST", "/v1/users", ` + "`" + `{"userId": 1}` + "`" + `, "Content-Encoding", "gzip"); resp.Code != 500 {
+		t.Errorf("POST /v1/users with an invalid gzip body = %v, want the bind error code 500", resp)
+	}
+	if _, resp := serve(t, g, "POST", "/v1/users", ` + "`" + `{"userId": 1}` + "`" + `); resp.Code != 0 {
+		t.Errorf("POST /v1/users uncompressed = %v, want success", resp)
+	}
+
+	// A body inflating past router.MaxDecompressedBody fails to bind.
+	defer func(max int64) { router.MaxDecompressedBody = max }(router.MaxDecompressedBody)
+	router.MaxDecompressedBody = 16
+	if _, resp := serve(t, g, "POST", "/v1/users", buf.String(), "Content-Encoding", "gzip"); resp.Code != 500 {
+		t.Errorf("POST /v1/users inflating past 16 bytes = %v, want the bind error code 500", resp)
+	}
+}
+`
+
+func TestGoldenDecompressRequest(t *testing.T) {
+	resp := generateGolden(t, "decompress_request=true", goldenUserFile())
+	checkGolden(t, "decompress_request", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":      serveTest,
+		"router/user/handler_test.go":    userHandlerTest("*gin.Context"),
+		"router/user/decompress_test.go": decompressTest,
+	})
+}
+
+// wildcardTest gets files of TestGoldenWildcard by their nested path.
+const wildcardTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type fileHandler struct{}
+
+func (fileHandler) GetFile(ctx *gin.Context, in *File, out *File) error {
+	*out = *in
+	return nil
+}
+
+func TestWildcard(t *testing.T) {
+	g := gin.New()
+	RegisterFileServiceHandler(g, fileHandler{})
+
+	_, resp := serve(t, g, "GET", "/v1/files/docs/a/b.txt", "")
+	if out, _ := resp.Data.(map[string]any); resp.Code != 0 || out["path"] != "docs/a/b.txt" {
+		t.Errorf("GET /v1/files/docs/a/b.txt = %v, want path docs/a/b.txt", resp)
+	}
+}
+`
+
+func TestGoldenWildcard(t *testing.T) {
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("File",
+				goldenField("path", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+			),
+		},
+		nil,
+		[]*descriptor.ServiceDescriptorProto{
+			goldenService("FileService",
+				goldenMethod("GetFile", ".user.File", ".user.File", "GET", "/v1/files/{path=**}"),
+			),
+		})
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "wildcard", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":    serveTest,
+		"router/user/wildcard_test.go": wildcardTest,
+	})
+
+	file.MessageType[0].Field[0].Type = descriptor.FieldDescriptorProto_TYPE_INT64.Enum()
+	_, err := Run(generateRequest(t, "", file))
+	if want := "wildcard: path is not a string field of File"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Run = %v, want an error containing %q", err, want)
+	}
+}
+
+// markerTest checks which messages of TestGoldenMarkerInterfaces implement the markers.
+const markerTest = `package user
+
+import (
+	"testing"
+
+	"example.com/app/router/router"
+)
+
+// pair uses the markers as type parameter constraints.
+func pair[In router.RequestMessage, Out router.ResponseMessage](in In, out Out) []any {
+	return []any{in, out}
+}
+
+func TestMarkers(t *testing.T) {
+	_ = pair(&GetUserReq{}, &User{})
+
+	tests := []struct {
+		name          string
+		m             any
+		req, response bool
+	}{
+		{"GetUserReq", &GetUserReq{}, true, false},
+		{"User", &User{}, true, true},
+		{"Profile", &Profile{}, false, false},
+	}
+	for _, tt := range tests {
+		if _, ok := tt.m.(router.RequestMessage); ok != tt.req {
+			t.Errorf("%s implements router.RequestMessage: %v, want %v", tt.name, ok, tt.req)
+		}
+		if _, ok := tt.m.(router.ResponseMessage); ok != tt.response {
+			t.Errorf("%s implements router.ResponseMessage: %v, want %v", tt.name, ok, tt.response)
+		}
+	}
+}
+`
+
+func TestGoldenMarkerInterfaces(t *testing.T) {
+	file := goldenUserFile()
+	file.MessageType = append(file.MessageType, goldenMessage("Profile",
+		goldenField("bio", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+	))
+
+	resp := generateGolden(t, "marker_interfaces=true", file)
+	checkGolden(t, "marker_interfaces", resp)
+	compileGolden(t, resp, map[string]string{"router/user/marker_test.go": markerTest})
+}
+
+// otelTest records the spans opened around GetUser through a registered SpanFunc.
+const otelTest = `package user
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/app/router/router"
+)
+
+type spanHandler struct {
+	userHandler
+	events *[]string
+}
+
+func (h spanHandler) GetUser(ctx *gin.Context, in *GetUserReq, out *User) error {
+	*h.events = append(*h.events, "handler")
+	return h.userHandler.GetUser(ctx, in, out)
+}
+
+func TestOTelSpan(t *testing.T) {
+	var events []string
+	g := gin.New()
+	RegisterUserServiceHandler(g, spanHandler{events: &events})
+
+	if _, resp := serve(t, g, "GET", "/v1/users/1", ""); resp.Code != 0 {
+		t.Errorf("GET /v1/users/1 without a SpanFunc = %v, want success", resp)
+	}
+
+	var attrs map[string]string
+	router.RegisterSpanFunc(func(ctx *gin.Context, name string, a map[string]string) func() {
+		events, attrs = append(events, "start "+name), a
+		return func() { events = append(events, "end "+name) }
+	})
+	defer router.RegisterSpanFunc(nil)
+
+	events = nil
+	serve(t, g, "GET", "/v1/users/1", "")
+	if want := []string{"start UserService/GetUser", "handler", "end UserService/GetUser"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+	if want := map[string]string{"http.method": "GET", "http.route": "/v1/users/{user_id}"}; !reflect.DeepEqual(attrs, want) {
+		t.Errorf("attrs = %v, want %v", attrs, want)
+	}
+}
+`
+
+func TestGoldenOTel(t *testing.T) {
+	resp := generateGolden(t, "otel=true", goldenUserFile())
+	checkGolden(t, "otel", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":   serveTest,
+		"router/user/handler_test.go": userHandlerTest("*gin.Context"),
+		"router/user/otel_test.go":    otelTest,
+	})
+}
+
+func TestGoldenErrorKey(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag validate:required\n", 4, 1, 2, 1)
+
+	resp := generateGolden(t, "field_errors=true,error_key=error", file)
+	checkGolden(t, "error_key", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":        serveTest,
+		"router/user/handler_test.go":      userHandlerTest("*gin.Context"),
+		"router/user/field_errors_test.go": strings.ReplaceAll(fieldErrorsTest, `"message"`, `"error"`),
+	})
+}
+
+// mapValuesTest uses the map of Money values of TestGoldenMapValues.
+const mapValuesTest = `package user
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMapValues(t *testing.T) {
+	w := &Wallet{Prices: map[string]Money{"eur": {Units: 5}}}
+	var prices map[string]Money = w.GetPrices()
+	prices["eur"] = Money{Units: 6}
+
+	b, err := json.Marshal(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Wallet
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, w) || got.Prices["eur"].Units != 6 {
+		t.Errorf("round trip of %s = %+v, want %+v", b, got, w)
+	}
+}
+`
+
+func TestGoldenMapValues(t *testing.T) {
+	entry := goldenMessage("PricesEntry",
+		goldenField("key", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+		goldenField("value", 2, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".user.Money"),
+	)
+	entry.Options = &descriptor.MessageOptions{MapEntry: proto.Bool(true)}
+	wallet := goldenMessage("Wallet",
+		goldenRepeated(goldenField("prices", 1, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".user.Wallet.PricesEntry")),
+	)
+	wallet.NestedType = []*descriptor.DescriptorProto{entry}
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("Money", goldenField("units", 1, descriptor.FieldDescriptorProto_TYPE_INT64, "")),
+			wallet,
+		}, nil, nil)
+
+	resp := generateGolden(t, "map_values=value", file)
+	checkGolden(t, "map_values", resp)
+	if model := goldenContent(t, resp, "user/user.model.go"); strings.Contains(model, "]*Money") {
+		t.Errorf("user.model.go has pointer map values with map_values=value:\n%s", model)
+	}
+	compileGolden(t, resp, map[string]string{"router/user/map_values_test.go": mapValuesTest})
+
+	if model := goldenContent(t, generateGolden(t, "", file), "user/user.model.go"); !strings.Contains(model, "map[string]*Money") {
+		t.Errorf("user.model.go has no pointer map values by default:\n%s", model)
+	}
+}
+
+// defaultsFromTest checks CreateUser applies the UserDefaults of TestGoldenDefaultsFrom to
+// the zero fields of the bound User.
+const defaultsFromTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDefaultsFrom(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+
+	tests := []struct {
+		body string
+		id   float64
+		name string
+	}{
+		{` + "`" + `{"tags": ["a"]}` + "`" + `, 7, "guest"},
+		{` + "`" + `{"userId": 1, "userName": "ann"}` + "`" + `, 1, "ann"},
+	}
+	for _, tt := range tests {
+		_, resp := serve(t, g, "POST", "/v1/users", tt.body)
+		if out, _ := resp.Data.(map[string]any); resp.Code != 0 || out["userId"] != tt.id || out["userName"] != tt.name {
+			t.Errorf("POST /v1/users %s = %v, want userId %v and userName %s", tt.body, resp, tt.id, tt.name)
+		}
+	}
+}
+`
+
+func TestGoldenDefaultsFrom(t *testing.T) {
+	file := goldenUserFile()
+	defaults := goldenMessage("UserDefaults",
+		goldenField("user_id", 1, descriptor.FieldDescriptorProto_TYPE_INT64, ""),
+		goldenField("user_name", 2, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+	)
+	defaults.Field[0].DefaultValue = proto.String("7")
+	defaults.Field[1].DefaultValue = proto.String("guest")
+	file.MessageType = append(file.MessageType, defaults)
+
+	resp := generateGolden(t, "defaults_from=UserDefaults", file)
+	checkGolden(t, "defaults_from", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":    serveTest,
+		"router/user/handler_test.go":  userHandlerTest("*gin.Context"),
+		"router/user/defaults_test.go": defaultsFromTest,
+	})
+}
+
+// validateTest checks the validate tags of User are enforced after binding, whatever the
+// bindcheck:false annotation of CreateUser.
+const validateTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidate(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+
+	tests := []struct {
+		body string
+		ok   bool
+	}{
+		{` + "`" + `{"userName": "ann"}` + "`" + `, true},
+		{` + "`" + `{"userName": "a"}` + "`" + `, false},
+		{` + "`" + `{"tags": ["a"]}` + "`" + `, false},
+		// bindcheck:false ignores the decoding error of userId, not the validation.
+		{` + "`" + `{"userId": "x", "userName": "ann"}` + "`" + `, true},
+		{` + "`" + `{"userId": "x"}` + "`" + `, false},
+	}
+	for _, tt := range tests {
+		if _, resp := serve(t, g, "POST", "/v1/users", tt.body); (resp.Code == 0) != tt.ok {
+			t.Errorf("POST /v1/users %s = %v, want success %v", tt.body, resp, tt.ok)
+		}
+	}
+}
+`
+
+func TestGoldenValidate(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag validate:required,min=2\n", 4, 1, 2, 1)
+	goldenComment(file, " @tag bindcheck:false\n", 6, 0, 2, 1)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "validate", resp)
+	if model := goldenContent(t, resp, "user/user.model.go"); !strings.Contains(model, `validate:"required,min=2"`) {
+		t.Errorf("user.model.go has no validate tag:\n%s", model)
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":    serveTest,
+		"router/user/handler_test.go":  userHandlerTest("*gin.Context"),
+		"router/user/validate_test.go": validateTest,
+	})
+}
+
+func TestGoldenBlockComments(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " User is a person signing in.\n Globs like a/*/b are escaped.\n", 4, 1)
+	goldenComment(file, " The display name.\n", 4, 1, 2, 1)
+	goldenComment(file, " GetUser returns the user.\n", 6, 0, 2, 0)
+
+	resp := generateGolden(t, "comment_style=block", file)
+	checkGolden(t, "block_comments", resp)
+
+	// The block comments must be the doc comments of their declarations.
+	fset := token.NewFileSet()
+	model, err := parser.ParseFile(fset, "user.model.go", goldenContent(t, resp, "user/user.model.go"), parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := map[string]string{}
+	ast.Inspect(model, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.GenDecl:
+			if n.Doc != nil && len(n.Specs) == 1 {
+				if spec, ok := n.Specs[0].(*ast.TypeSpec); ok {
+					docs[spec.Name.Name] = strings.TrimSpace(n.Doc.Text())
+				}
+			}
+		case *ast.Field:
+			if n.Doc != nil && len(n.Names) == 1 {
+				docs[n.Names[0].Name] = strings.TrimSpace(n.Doc.Text())
+			}
+		}
+		return true
+	})
+	want := map[string]string{
+		"User":     "User is a person signing in.\nGlobs like a/* /b are escaped.",
+		"UserName": "The display name.",
+	}
+	if !reflect.DeepEqual(docs, want) {
+		t.Errorf("doc comments = %q, want %q", docs, want)
+	}
+	if api := goldenContent(t, resp, "user/user.api.go"); !strings.Contains(api, "\t/*\n\t   GetUser returns the user.\n\t*/\n\t// UserService.GetUser handles") {
+		t.Errorf("user.api.go has no block comment on the GetUser route:\n%s", api)
+	}
+	compileGolden(t, resp, nil)
+
+	if _, err := Run(generateRequest(t, "comment_style=doc", file)); err == nil || !strings.Contains(err.Error(), `Unknown comment_style "doc": want "line" or "block".`) {
+		t.Errorf("Run = %v, want Unknown comment_style", err)
+	}
+}
+
+// validateAggregateTest checks every failing field of User is reported, the nested one
+// prefixed with its path.
+const validateAggregateTest = `package user
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"example.com/app/router/router"
+)
+
+func TestValidateAggregate(t *testing.T) {
+	var verrs router.ValidationErrors
+	if err := (&User{Profile: &Profile{}}).Validate(); !errors.As(err, &verrs) {
+		t.Fatalf("Validate() = %v, want router.ValidationErrors", err)
+	}
+	var fields []string
+	for _, ve := range verrs {
+		fields = append(fields, ve.Field)
+	}
+	if want := []string{"userId", "userName", "profile.bio"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("failing fields = %q, want %q", fields, want)
+	}
+	if err := (&User{UserId: 1, UserName: "ann"}).Validate(); err != nil {
+		t.Errorf("Validate() of a valid user = %v, want nil", err)
+	}
+
+	g := gin.New()
+	RegisterUserServiceHandler(g, userHandler{})
+	_, resp := serve(t, g, "POST", "/v1/users", ` + "`" + `{"profile": {}}` + "`" + `)
+	if errs, _ := resp.Data.([]any); resp.Code == 0 || len(errs) != 3 {
+		t.Errorf("POST /v1/users = %v, want the three field errors", resp)
+	}
+}
+`
+
+func TestGoldenValidateAggregate(t *testing.T) {
+	file := goldenUserFile()
+	file.MessageType = append(file.MessageType, goldenMessage("Profile",
+		goldenField("bio", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+	))
+	user := file.MessageType[1]
+	user.Field = append(user.Field, goldenField("profile", 4, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".user.Profile"))
+	goldenComment(file, " @tag validate:gt=0\n", 4, 1, 2, 0)
+	goldenComment(file, " @tag validate:required\n", 4, 1, 2, 1)
+	goldenComment(file, " @tag validate:required\n", 4, 3, 2, 0)
+
+	resp := generateGolden(t, "validate_aggregate=true", file)
+	checkGolden(t, "validate_aggregate", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":     serveTest,
+		"router/user/handler_test.go":   userHandlerTest("*gin.Context"),
+		"router/user/aggregate_test.go": validateAggregateTest,
+	})
+}
+
+// pathParamsTest binds the path variables of TestGoldenPathParams.
+const pathParamsTest = `package user
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type lookupHandler struct{}
+
+func (lookupHandler) Lookup(ctx *gin.Context, in *Lookup, out *Lookup) error {
+	*out = *in
+	return nil
+}
+
+func TestPathParams(t *testing.T) {
+	g := gin.New()
+	RegisterLookupServiceHandler(g, lookupHandler{})
+
+	_, resp := serve(t, g, "GET", "/v1/orgs/3/refs/42/shelves/a/books/b/true", "")
+	out, _ := resp.Data.(map[string]any)
+	ref, _ := out["ref"].(map[string]any)
+	if resp.Code != 0 || out["orgId"] != float64(3) || ref["id"] != float64(42) || out["name"] != "shelves/a/books/b" || out["active"] != true {
+		t.Errorf("GET /v1/orgs/3/refs/42/shelves/a/books/b/true = %v, want every path variable bound", resp)
+	}
+	for _, target := range []string{"/v1/orgs/x/refs/42/shelves/a/books/b/true", "/v1/orgs/3/refs/42/shelves/a/books/b/maybe"} {
+		if _, resp := serve(t, g, "GET", target, ""); resp.Code != 500 {
+			t.Errorf("GET %s = %v, want code 500 for a value that doesn't parse", target, resp)
+		}
+	}
+}
+`
+
+func TestGoldenPathParams(t *testing.T) {
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("Ref",
+				goldenField("id", 1, descriptor.FieldDescriptorProto_TYPE_INT64, ""),
+			),
+			goldenMessage("Lookup",
+				goldenField("org_id", 1, descriptor.FieldDescriptorProto_TYPE_INT32, ""),
+				goldenField("ref", 2, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".user.Ref"),
+				goldenField("name", 3, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+				goldenField("active", 4, descriptor.FieldDescriptorProto_TYPE_BOOL, ""),
+			),
+		},
+		nil,
+		[]*descriptor.ServiceDescriptorProto{
+			goldenService("LookupService",
+				goldenMethod("Lookup", ".user.Lookup", ".user.Lookup", "GET", "/v1/orgs/{org_id}/refs/{ref.id}/{name=shelves/*/books/*}/{active}"),
+			),
+		})
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "path_params", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":       serveTest,
+		"router/user/path_params_test.go": pathParamsTest,
+	})
+}
+
+func TestGoldenServiceBindCheck(t *testing.T) {
+	// The service turns bindcheck off, GetUser turns it back on.
+	file := goldenUserFile()
+	goldenComment(file, " @tag bindcheck:false\n", 6, 0)
+	goldenComment(file, " @tag bindcheck:true\n", 6, 0, 2, 0)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "service_bindcheck", resp)
+
+	api := goldenContent(t, resp, "user/user.api.go")
+	for _, method := range []string{"GetUser", "CreateUser"} {
+		start := strings.Index(api, "UserService."+method+" handles")
+		if start < 0 {
+			t.Fatalf("user.api.go has no %s handler:\n%s", method, api)
+		}
+		end := strings.Index(api[start:], "err := h."+method)
+		checked := !strings.Contains(api[start:start+end], "_ = ctx.ShouldBind")
+		if want := method == "GetUser"; checked != want {
+			t.Errorf("%s checks its binding = %v, want %v:\n%s", method, checked, want, api[start:start+end])
+		}
+	}
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":   serveTest,
+		"router/user/handler_test.go": userHandlerTest("*gin.Context"),
+	})
+}
+
+// errcodeTest checks the codes of the bind and handler errors of TestGoldenErrcode: 422 for
+// CreateUser, annotated errcode:422, and the GEN_ERROR_CODE 418 for the other methods.
+const errcodeTest = `package user
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type failingHandler struct{ userHandler }
+
+func (failingHandler) GetUser(ctx *gin.Context, in *GetUserReq, out *User) error {
+	return errors.New("no such user")
+}
+
+func (failingHandler) CreateUser(ctx *gin.Context, in *User, out *User) error {
+	return errors.New("can't create")
+}
+
+func TestErrcode(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, failingHandler{})
+
+	tests := []struct {
+		method, target, body string
+		code                 int
+	}{
+		{"POST", "/v1/users", ` + "`" + `{"userId": 1}` + "`" + `, 422},
+		{"POST", "/v1/users", "{", 422},
+		{"GET", "/v1/users/1", "", 418},
+		{"GET", "/v1/users/x", "", 418},
+	}
+	for _, tt := range tests {
+		if _, resp := serve(t, g, tt.method, tt.target, tt.body); resp.Code != tt.code {
+			t.Errorf("%s %s %s = %v, want code %d", tt.method, tt.target, tt.body, resp, tt.code)
+		}
+	}
+}
+`
+
+func TestGoldenErrcode(t *testing.T) {
+	t.Setenv("GEN_ERROR_CODE", "418")
+	file := goldenUserFile()
+	goldenComment(file, " @tag errcode:422\n", 6, 0, 2, 1)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "errcode", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":   serveTest,
+		"router/user/handler_test.go": userHandlerTest("*gin.Context"),
+		"router/user/errcode_test.go": errcodeTest,
+	})
+
+	file = goldenUserFile()
+	goldenComment(file, " @tag errcode:teapot\n", 6, 0, 2, 1)
+	_, err := Run(generateRequest(t, "", file))
+	if want := "errcode: teapot of CreateUser is not an HTTP status code"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Run = %v, want an error containing %q", err, want)
+	}
+}
+
+// headTest checks the HEAD route of TestGoldenHead answers with the status only.
+const headTest = `package user
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type headHandler struct{ userHandler }
+
+func (headHandler) HeadUser(ctx *gin.Context, in *GetUserReq, out *User) error {
+	if in.UserId != 7 {
+		return errors.New("no such user")
+	}
+	out.UserName = "ann"
+	return nil
+}
+
+func TestHead(t *testing.T) {
+	g := gin.New()
+	RegisterUserServiceHandler(g, headHandler{})
+
+	// The body isn't bound, malformed as it is.
+	req := httptest.NewRequest("HEAD", "/v1/users/7", strings.NewReader("{"))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, req)
+	if w.Code != 200 || w.Body.Len() != 0 {
+		t.Errorf("HEAD /v1/users/7 = %d %q, want 200 and no body", w.Code, w.Body)
+	}
+
+	if _, resp := serve(t, g, "HEAD", "/v1/users/1", ""); resp.Code != 500 {
+		t.Errorf("HEAD /v1/users/1 = %v, want the handler error", resp)
+	}
+}
+`
+
+func TestGoldenHead(t *testing.T) {
+	head := &annotations.HttpRule{Pattern: &annotations.HttpRule_Custom{Custom: &annotations.CustomHttpPattern{Kind: "HEAD", Path: "/v1/users/{user_id}"}}}
+	file := goldenUserFile()
+	method := goldenMethod("HeadUser", ".user.GetUserReq", ".user.User", "GET", "/v1/users/{user_id}")
+	if err := proto.SetExtension(method.Options, annotations.E_Http, head); err != nil {
+		t.Fatal(err)
+	}
+	file.Service[0].Method = append(file.Service[0].Method, method)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "head", resp)
+	compileGolden(t, resp, map[string]string{
+		"router/user/serve_test.go":   serveTest,
+		"router/user/handler_test.go": userHandlerTest("*gin.Context"),
+		"router/user/head_test.go":    headTest,
+	})
+
+	file = goldenUserFile()
+	goldenBindings(file.Service[0].Method[0], head)
+	_, err := Run(generateRequest(t, "", file))
+	if want := "can't mix HEAD and other verbs"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Run = %v, want an error containing %q", err, want)
+	}
+}
+
+func TestGroupByPackage(t *testing.T) {
+	// The files of a.b.c, wherever they are, are generated under a/b/c.
+	abc := func(name, message string) *descriptor.FileDescriptorProto {
+		return &descriptor.FileDescriptorProto{
+			Name:        proto.String(name),
+			Package:     proto.String("a.b.c"),
+			Syntax:      proto.String("proto3"),
+			Options:     &descriptor.FileOptions{GoPackage: proto.String(goldenRepo + "/a/b/c;c")},
+			MessageType: []*descriptor.DescriptorProto{goldenMessage(message)},
+		}
+	}
+
+	resp := generateGolden(t, "group_by=package", abc("api/user.proto", "User"), abc("shared/v1/group.proto", "Group"))
+	var names []string
+	for _, file := range resp.File {
+		names = append(names, file.GetName())
+	}
+	if want := []string{"a/b/c/user.model.go", "a/b/c/user.api.go", "a/b/c/group.model.go", "a/b/c/group.api.go"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("generated %q, want %q", names, want)
+	}
+
+	if _, err := Run(generateRequest(t, "group_by=dir", goldenUserFile())); err == nil || !strings.Contains(err.Error(), `Unknown group_by "dir"`) {
+		t.Errorf("Run = %v, want an error for the unknown group_by", err)
+	}
+}
+
+func TestImportOrder(t *testing.T) {
+	var files []*descriptor.FileDescriptorProto
+	var fields []*descriptor.FieldDescriptorProto
+	for i, pkg := range []string{"delta", "alpha", "echo", "charlie", "bravo"} {
+		files = append(files, goldenFile(pkg+"/"+pkg+".proto", []*descriptor.DescriptorProto{goldenMessage("Item")}, nil, nil))
+		fields = append(fields, goldenField(pkg, int32(i+1), descriptor.FieldDescriptorProto_TYPE_MESSAGE, "."+pkg+".Item"))
+	}
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{goldenMessage("User", fields...)},
+		nil,
+		[]*descriptor.ServiceDescriptorProto{
+			goldenService("UserService", goldenMethod("GetUser", ".delta.Item", ".bravo.Item", "POST", "/v1/users")),
+		})
+	for _, f := range files {
+		file.Dependency = append(file.Dependency, f.GetName())
+	}
+	files = append(files, file)
+
+	want := "\t\"example.com/app/router/alpha\"\n\t\"example.com/app/router/bravo\"\n\t\"example.com/app/router/charlie\"\n\t\"example.com/app/router/delta\"\n\t\"example.com/app/router/echo\"\n"
+	first := generateGolden(t, "", files...)
+	if model := goldenContent(t, first, "user/user.model.go"); !strings.Contains(model, want) {
+		t.Errorf("user.model.go doesn't import the packages sorted by path:\n%s", model)
+	}
+	for i := 0; i < 10; i++ {
+		resp := generateGolden(t, "", files...)
+		for _, name := range []string{"user/user.model.go", "user/user.api.go"} {
+			if got := goldenContent(t, resp, name); got != goldenContent(t, first, name) {
+				t.Fatalf("%s differs between runs:\n%s", name, got)
+			}
+		}
+	}
+}
+
+func TestRouteDocComments(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " @tag middleware:auth\n", 6, 0, 2, 1)
+
+	// Each route registration, by gin or router.Handle, is preceded by the comment naming it.
+	fset := token.NewFileSet()
+	api, err := parser.ParseFile(fset, "user.api.go", goldenContent(t, generateGolden(t, "", file), "user/user.api.go"), parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmap := ast.NewCommentMap(fset, api, api.Comments)
+	var docs []string
+	ast.Inspect(api, func(n ast.Node) bool {
+		stmt, ok := n.(*ast.ExprStmt)
+		if !ok {
+			return true
+		}
+		if _, ok := stmt.X.(*ast.CallExpr); ok {
+			// The comment of the method, if any, comes first.
+			for _, c := range cmap[stmt] {
+				lines := strings.Split(strings.TrimSpace(c.Text()), "\n")
+				docs = append(docs, lines[len(lines)-1])
+			}
+		}
+		return false
+	})
+	want := []string{
+		"UserService.GetUser handles GET /v1/users/{user_id}",
+		"UserService.CreateUser handles POST /v1/users",
+		"UserService.Ping handles GET /v1/ping",
+	}
+	if !reflect.DeepEqual(docs, want) {
+		t.Errorf("route registrations documented by %q, want %q", docs, want)
+	}
+}
+
+func TestOutputNames(t *testing.T) {
+	deep := goldenUserFile()
+	deep.Name = proto.String("api/user/v1/user.proto")
+
+	tests := []struct {
+		param string
+		file  *descriptor.FileDescriptorProto
+		want  []string
+	}{
+		{"", goldenUserFile(), []string{"user/user.model.go", "user/user.api.go"}},
+		{"file_suffix=.gen.go", goldenUserFile(), []string{"user/user.model.go", "user/user.gen.go"}},
+		{"flat=true", goldenUserFile(), []string{"user.model.go", "user.api.go"}},
+		{"flat=true,file_suffix=.gen.go", goldenUserFile(), []string{"user.model.go", "user.gen.go"}},
+		{"", deep, []string{"api/user/v1/user.model.go", "api/user/v1/user.api.go"}},
+	}
+	for _, tt := range tests {
+		var names []string
+		for _, file := range generateGolden(t, tt.param, tt.file).File {
+			names = append(names, file.GetName())
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("generated %q from %s with %q, want %q", names, tt.file.GetName(), tt.param, tt.want)
+		}
+	}
+
+	for param, want := range map[string]string{
+		"file_suffix=gen.go":         `Invalid file_suffix "gen.go"`,
+		"file_suffix=.gen.txt":       `Invalid file_suffix ".gen.txt"`,
+		"file_suffix=.model.go":      "is the suffix of another generated file",
+		"flat=true,group_by=package": "enable only one",
+	} {
+		if _, err := Run(generateRequest(t, param, goldenUserFile())); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Run(%q) = %v, want an error containing %q", param, err, want)
+		}
+	}
+}
+
+func TestGoldenImportMode(t *testing.T) {
+	// Only the enums of public imports get aliases.
+	base := goldenFile("base/base.proto", nil, []*descriptor.EnumDescriptorProto{
+		goldenEnum("Kind", []string{"KIND_UNKNOWN", "KIND_HOME"}, []int32{0, 1}),
+	}, nil)
+	user := goldenFile("user/user.proto", []*descriptor.DescriptorProto{
+		goldenMessage("User", goldenField("kind", 1, descriptor.FieldDescriptorProto_TYPE_ENUM, ".base.Kind")),
+	}, nil, nil)
+	user.Dependency = []string{"base/base.proto"}
+	user.PublicDependency = []int32{0}
+
+	tests := []struct {
+		param, golden string
+		alias         bool
+	}{
+		{"", "import_mode_alias", true},
+		{"import_mode=reference", "import_mode_reference", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			resp := generateGolden(t, tt.param, base, user)
+			checkGolden(t, tt.golden, resp)
+
+			model := goldenContent(t, resp, "user/user.model.go")
+			if got := strings.Contains(model, "type Kind = base.Kind"); got != tt.alias {
+				t.Errorf("user.model.go aliases base.Kind: %v, want %v:\n%s", got, tt.alias, model)
+			}
+			if !strings.Contains(model, "Kind base.Kind ") {
+				t.Errorf("user.model.go doesn't reference base.Kind:\n%s", model)
+			}
+			compileGolden(t, resp, nil)
+		})
+	}
+
+	if _, err := Run(generateRequest(t, "import_mode=copy", base, user)); err == nil || !strings.Contains(err.Error(), `Unknown import_mode "copy"`) {
+		t.Errorf("Run = %v, want an error for the unknown import_mode", err)
+	}
+}
+
+func TestGoldenTrailingComments(t *testing.T) {
+	file := goldenUserFile()
+	goldenComment(file, " The display name.\n", 4, 1, 2, 1)
+	goldenTrailingComment(file, " Shown next to every comment.\n", 4, 1, 2, 1)
+	goldenTrailingComment(file, " At most 2 tags. @tag maxlen:2\n", 4, 1, 2, 2)
+
+	resp := generateGolden(t, "", file)
+	checkGolden(t, "trailing_comments", resp)
+
+	model := goldenContent(t, resp, "user/user.model.go")
+	for _, want := range []string{
+		"\t// The display name.\n\t// Shown next to every comment.\n\tUserName ",
+		"\t// At most 2 tags. @tag maxlen:2\n\tTags ",
+	} {
+		if !strings.Contains(model, want) {
+			t.Errorf("user.model.go doesn't render the trailing comments, want %q:\n%s", want, model)
+		}
+	}
+	// The annotations of trailing comments apply as well.
+	if api := goldenContent(t, resp, "user/user.api.go"); !strings.Contains(api, "if len(input.Tags) > 2 {") {
+		t.Errorf("user.api.go has no length check of Tags:\n%s", api)
+	}
+	compileGolden(t, resp, nil)
+}
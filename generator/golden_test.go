@@ -0,0 +1,494 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+	"google.golang.org/genproto/googleapis/api/annotations"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files from the generated output")
+
+// goldenRepo is the repo parameter of the golden tests: the generated files live in the
+// router directory of the example.com/app module, as router.sh lays them out.
+const goldenRepo = "example.com/app/router"
+
+// goldenGoMod is the go.mod of the module the generated files are compiled in.
+const goldenGoMod = "module example.com/app\n\ngo 1.21\n"
+
+// goldenDeps are the modules imported by the generated code and the router package, at
+// the versions the golden tests are known to pass with.
+var goldenDeps = []string{
+	"github.com/gin-gonic/gin@v1.10.0",
+	"github.com/go-playground/validator/v10@v10.20.0",
+	"github.com/golang/protobuf@v1.5.4",
+	"google.golang.org/protobuf@v1.34.1",
+}
+
+// goldenDownloadTimeout bounds the download of goldenDeps when they aren't all in the
+// module cache.
+const goldenDownloadTimeout = time.Minute
+
+// goldenFile returns a proto3 file declaring the messages, enums and services in the
+// package named after its directory.
+func goldenFile(name string, messages []*descriptor.DescriptorProto, enums []*descriptor.EnumDescriptorProto, services []*descriptor.ServiceDescriptorProto) *descriptor.FileDescriptorProto {
+	pkg := filepath.Base(filepath.Dir(name))
+	return &descriptor.FileDescriptorProto{
+		Name:        proto.String(name),
+		Package:     proto.String(pkg),
+		Syntax:      proto.String("proto3"),
+		Options:     &descriptor.FileOptions{GoPackage: proto.String(goldenRepo + "/" + pkg + ";" + pkg)},
+		MessageType: messages,
+		EnumType:    enums,
+		Service:     services,
+	}
+}
+
+// goldenMessage returns a message declaring the fields.
+func goldenMessage(name string, fields ...*descriptor.FieldDescriptorProto) *descriptor.DescriptorProto {
+	return &descriptor.DescriptorProto{Name: proto.String(name), Field: fields}
+}
+
+// goldenField returns a singular field, of the message or enum typeName if not empty.
+func goldenField(name string, number int32, typ descriptor.FieldDescriptorProto_Type, typeName string) *descriptor.FieldDescriptorProto {
+	field := &descriptor.FieldDescriptorProto{
+		Name:     proto.String(name),
+		Number:   proto.Int32(number),
+		Type:     typ.Enum(),
+		Label:    descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		JsonName: proto.String(goldenJSONName(name)),
+	}
+	if typeName != "" {
+		field.TypeName = proto.String(typeName)
+	}
+	return field
+}
+
+// goldenRepeated returns the field made repeated.
+func goldenRepeated(field *descriptor.FieldDescriptorProto) *descriptor.FieldDescriptorProto {
+	field.Label = descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	return field
+}
+
+// goldenJSONName returns the lowerCamelCase JSON name protoc gives a field: underscores
+// are dropped and the ASCII letters following them upper-cased.
+func goldenJSONName(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range name {
+		switch {
+		case r == '_':
+			upper = true
+			continue
+		case upper && 'a' <= r && r <= 'z':
+			r -= 'a' - 'A'
+		}
+		upper = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// goldenEnum returns an enum with the values in order, numbered by numbers.
+func goldenEnum(name string, values []string, numbers []int32) *descriptor.EnumDescriptorProto {
+	enum := &descriptor.EnumDescriptorProto{Name: proto.String(name)}
+	for i, value := range values {
+		enum.Value = append(enum.Value, &descriptor.EnumValueDescriptorProto{Name: proto.String(value), Number: proto.Int32(numbers[i])})
+	}
+	return enum
+}
+
+// goldenService returns a service with the methods.
+func goldenService(name string, methods ...*descriptor.MethodDescriptorProto) *descriptor.ServiceDescriptorProto {
+	return &descriptor.ServiceDescriptorProto{Name: proto.String(name), Method: methods}
+}
+
+// goldenMethod returns a method bound to the HTTP rule, its body set to * for verbs
+// other than GET.
+func goldenMethod(name, in, out, verb, url string) *descriptor.MethodDescriptorProto {
+	rule := &annotations.HttpRule{}
+	switch verb {
+	case "GET":
+		rule.Pattern = &annotations.HttpRule_Get{Get: url}
+	case "POST":
+		rule.Pattern = &annotations.HttpRule_Post{Post: url}
+		rule.Body = "*"
+	case "PUT":
+		rule.Pattern = &annotations.HttpRule_Put{Put: url}
+		rule.Body = "*"
+	case "DELETE":
+		rule.Pattern = &annotations.HttpRule_Delete{Delete: url}
+	}
+
+	method := &descriptor.MethodDescriptorProto{
+		Name:       proto.String(name),
+		InputType:  proto.String(in),
+		OutputType: proto.String(out),
+		Options:    &descriptor.MethodOptions{},
+	}
+	if err := proto.SetExtension(method.Options, annotations.E_Http, rule); err != nil {
+		panic(err)
+	}
+	return method
+}
+
+// goldenBindings appends the rules to the additional_bindings of the method.
+func goldenBindings(method *descriptor.MethodDescriptorProto, rules ...*annotations.HttpRule) {
+	ext, err := proto.GetExtension(method.Options, annotations.E_Http)
+	if err != nil {
+		panic(err)
+	}
+	rule := ext.(*annotations.HttpRule)
+	rule.AdditionalBindings = append(rule.AdditionalBindings, rules...)
+	if err := proto.SetExtension(method.Options, annotations.E_Http, rule); err != nil {
+		panic(err)
+	}
+}
+
+// goldenComment adds a leading comment to the element of the file at the source path,
+// e.g. 4, 0, 2, 1 for the second field of the first message.
+func goldenComment(file *descriptor.FileDescriptorProto, comment string, path ...int32) {
+	if file.SourceCodeInfo == nil {
+		file.SourceCodeInfo = &descriptor.SourceCodeInfo{}
+	}
+	file.SourceCodeInfo.Location = append(file.SourceCodeInfo.Location, &descriptor.SourceCodeInfo_Location{
+		Path:            path,
+		LeadingComments: proto.String(comment),
+	})
+}
+
+// goldenTrailingComment adds a trailing comment, written on the line of the declaration,
+// to the element of the file at the source path, next to its leading comment if any.
+func goldenTrailingComment(file *descriptor.FileDescriptorProto, comment string, path ...int32) {
+	if file.SourceCodeInfo == nil {
+		file.SourceCodeInfo = &descriptor.SourceCodeInfo{}
+	}
+	for _, loc := range file.SourceCodeInfo.Location {
+		if reflect.DeepEqual(loc.Path, path) {
+			loc.TrailingComments = proto.String(comment)
+			return
+		}
+	}
+	file.SourceCodeInfo.Location = append(file.SourceCodeInfo.Location, &descriptor.SourceCodeInfo_Location{
+		Path:             path,
+		TrailingComments: proto.String(comment),
+	})
+}
+
+// goldenUserFile returns user/user.proto, serving GetUserReq and User from UserService:
+// GetUser on GET /v1/users/{user_id}, CreateUser on POST /v1/users and Ping on GET /v1/ping.
+func goldenUserFile() *descriptor.FileDescriptorProto {
+	return goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("GetUserReq",
+				goldenField("user_id", 1, descriptor.FieldDescriptorProto_TYPE_INT64, ""),
+			),
+			goldenMessage("User",
+				goldenField("user_id", 1, descriptor.FieldDescriptorProto_TYPE_INT64, ""),
+				goldenField("user_name", 2, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+				goldenRepeated(goldenField("tags", 3, descriptor.FieldDescriptorProto_TYPE_STRING, "")),
+			),
+			goldenMessage("Empty"),
+		},
+		nil,
+		[]*descriptor.ServiceDescriptorProto{
+			goldenService("UserService",
+				goldenMethod("GetUser", ".user.GetUserReq", ".user.User", "GET", "/v1/users/{user_id}"),
+				goldenMethod("CreateUser", ".user.User", ".user.User", "POST", "/v1/users"),
+				goldenMethod("Ping", ".user.Empty", ".user.Empty", "GET", "/v1/ping"),
+			),
+		})
+}
+
+// goldenContent returns the content of the generated file, failing the test if the
+// response has no such file.
+func goldenContent(t *testing.T, resp *plugin.CodeGeneratorResponse, name string) string {
+	t.Helper()
+
+	for _, file := range resp.File {
+		if file.GetName() == name {
+			return file.GetContent()
+		}
+	}
+	t.Fatalf("no generated file %s", name)
+	return ""
+}
+
+// generateRequest returns the request generating all the files with the parameter.
+// handler.json is written to a temporary directory, where it starts empty.
+func generateRequest(t *testing.T, param string, files ...*descriptor.FileDescriptorProto) *plugin.CodeGeneratorRequest {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "handler.json"), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	params := "repo=" + goldenRepo + ",paths=source_relative,path=" + dir
+	if param != "" {
+		params += "," + param
+	}
+	req := &plugin.CodeGeneratorRequest{Parameter: proto.String(params), ProtoFile: files}
+	for _, file := range files {
+		req.FileToGenerate = append(req.FileToGenerate, file.GetName())
+	}
+	return req
+}
+
+// generate runs the generator on the request as the plugin does and returns its response.
+// The generator exits on errors.
+func generate(req *plugin.CodeGeneratorRequest) *plugin.CodeGeneratorResponse {
+	g := New()
+	g.Request = req
+	g.CommandLineParameters(g.Request.GetParameter())
+	g.WrapTypes()
+	g.SetPackageNames()
+	g.BuildTypeNameMap()
+	g.GenerateAllFiles()
+	return g.Response
+}
+
+// generateGolden runs the generator on the files with the parameter and returns its output.
+func generateGolden(t *testing.T, param string, files ...*descriptor.FileDescriptorProto) *plugin.CodeGeneratorResponse {
+	t.Helper()
+
+	return generate(generateRequest(t, param, files...))
+}
+
+// generateError runs the generator on the request in a child process of the test binary,
+// as the generator exits on errors, and returns what the child printed. The test fails if
+// the generation succeeds.
+func generateError(t *testing.T, req *plugin.CodeGeneratorRequest) string {
+	t.Helper()
+
+	data, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "request.pb")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestGenerateChild$")
+	cmd.Env = append(os.Environ(), "GOLDEN_REQUEST="+path)
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("generating with %q succeeded, want an error:\n%s", req.GetParameter(), out)
+	}
+	return string(out)
+}
+
+// TestGenerateChild generates the request written by generateError, in the child process
+// running it.
+func TestGenerateChild(t *testing.T) {
+	req := os.Getenv("GOLDEN_REQUEST")
+	if req == "" {
+		t.Skip("only run by generateError")
+	}
+
+	data, err := os.ReadFile(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r plugin.CodeGeneratorRequest
+	if err := proto.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	generate(&r)
+}
+
+// checkGolden compares the generated files with testdata/<name>.golden, holding every file
+// under a "-- <file name> --" line, and rewrites it with -update.
+func checkGolden(t *testing.T, name string, resp *plugin.CodeGeneratorResponse) {
+	t.Helper()
+
+	var got bytes.Buffer
+	for _, file := range resp.File {
+		got.WriteString("-- " + file.GetName() + " --\n")
+		got.WriteString(file.GetContent())
+	}
+
+	golden := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(golden, got.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v, run go test -update to create it", err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("output differs from %s, run go test -update to rewrite it after checking the diff:\n%s", golden, got.String())
+	}
+}
+
+// compileGolden vets the generated Go files, together with the extra files keyed by their
+// path in the module, inside a module holding the router package written by router.sh,
+// then runs the tests among the extra files against the generated code. It is skipped in
+// short mode or when the dependencies of the generated code can't be downloaded.
+func compileGolden(t *testing.T, resp *plugin.CodeGeneratorResponse, extra map[string]string) {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("skipping the compilation of the generated code in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("go.mod", goldenGoMod)
+	if err := os.Mkdir(filepath.Join(dir, "proto"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	script, err := filepath.Abs(filepath.Join("..", "docker", "generator", "router.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The proto directory is empty: router.sh only writes the router package.
+	if out, err := exec.Command("bash", script, dir).CombinedOutput(); err != nil {
+		t.Fatalf("router.sh: %v\n%s", err, out)
+	}
+
+	for _, file := range resp.File {
+		if strings.HasSuffix(file.GetName(), ".go") {
+			write("router/"+file.GetName(), file.GetContent())
+		}
+	}
+	for name, content := range extra {
+		write(name, content)
+	}
+
+	// The dependencies are taken from the module cache when they are all there. Otherwise
+	// they are downloaded, within goldenDownloadTimeout for the test to be skipped rather
+	// than hang without network.
+	env := []string{"GOFLAGS=-mod=mod", "GOPROXY=off"}
+	run := func(ctx context.Context, args ...string) ([]byte, error) {
+		cmd := exec.CommandContext(ctx, "go", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), env...)
+		return cmd.CombinedOutput()
+	}
+	get := append([]string{"get"}, goldenDeps...)
+	if _, err := run(context.Background(), get...); err != nil {
+		env = env[:1]
+		ctx, cancel := context.WithTimeout(context.Background(), goldenDownloadTimeout)
+		defer cancel()
+		if out, err := run(ctx, get...); err != nil {
+			t.Skipf("can't download the dependencies of the generated code: %v\n%s", err, out)
+		}
+	}
+	if out, err := run(context.Background(), "vet", "./..."); err != nil {
+		t.Fatalf("generated code doesn't compile: %v\n%s", err, out)
+	}
+	if out, err := run(context.Background(), "test", "./..."); err != nil {
+		t.Fatalf("tests of the generated code fail: %v\n%s", err, out)
+	}
+}
+
+// serveTest is serve, the helper of the tests running the handlers generated in the user
+// package by the golden tests.
+const serveTest = `package user
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/app/router/router"
+)
+
+// serve sends the request to h, with the body as JSON when not empty and the headers given
+// as name, value pairs, and returns the recorder and the JSON response it holds, if any.
+func serve(t *testing.T, h http.Handler, method, target, body string, header ...string) (*httptest.ResponseRecorder, router.Response) {
+	t.Helper()
+
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	var resp router.Response
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s %s: %v\n%s", method, target, err, w.Body)
+		}
+	}
+	return w, resp
+}
+`
+
+// userHandlerTest returns the source of userHandler, the UserServiceHandler of
+// goldenUserFile served by the runtime tests, its methods taking a context of type ctxType,
+// *gin.Context or context.Context. GetUser returns the user named after the user_id,
+// CreateUser echoes its input and Ping does nothing.
+func userHandlerTest(ctxType string) string {
+	pkg := "github.com/gin-gonic/gin"
+	if ctxType == "context.Context" {
+		pkg = "context"
+	}
+	return strings.ReplaceAll(`package user
+
+import (
+	"fmt"
+
+	"`+pkg+`"
+)
+
+type userHandler struct{}
+
+func (userHandler) GetUser(ctx CTX, in *GetUserReq, out *User) error {
+	out.UserId, out.UserName = in.UserId, fmt.Sprint("user", in.UserId)
+	return nil
+}
+
+func (userHandler) CreateUser(ctx CTX, in *User, out *User) error {
+	*out = *in
+	return nil
+}
+
+func (userHandler) Ping(ctx CTX, in *Empty, out *Empty) error {
+	return nil
+}
+`, "CTX", ctxType)
+}
@@ -39,7 +39,7 @@ var RegisterMiddleware = router.RegisterMiddleware
 
 		`
 
-		os.WriteFile(path+"/handler.go", []byte(str), os.ModePerm)
+		os.WriteFile(path+"/handler.go", []byte(str), 0o644)
 		return
 	}
 
@@ -52,17 +52,22 @@ var RegisterMiddleware = router.RegisterMiddleware
 
 `
 	for k, v := range m {
+		// Files generated with flat=true are in the root package of the repo.
+		imp := strings.TrimSuffix(strings.TrimSuffix(repo, "/")+`/`+v, "/.")
+		name := imp[strings.LastIndex(imp, "/")+1:]
+
 		vers, alias := "", ""
 		if arr := strings.Split(v, "/"); len(arr) > 1 {
-			vers = arr[len(arr)-1]
-			if len(vers) > 3 && vers[0] == 'v' && vers[2] == '_' {
-				vers = strings.ToUpper(vers[:1]) + vers[1:]
+			if last := arr[len(arr)-1]; len(last) > 3 && last[0] == 'v' && last[2] == '_' {
+				vers = strings.ToUpper(last[:1]) + last[1:]
 				alias = arr[len(arr)-2] + vers
+				name = alias
 			}
 		}
 
 		sla[k] = vers
 		sls = append(sls, k)
+		m[k] = name
 
 		if _, ok := ext[v]; ok {
 			continue
@@ -70,13 +75,7 @@ var RegisterMiddleware = router.RegisterMiddleware
 
 		ext[v] = 1
 
-		if vers != "" {
-			str += "\t" + alias + `"` + strings.TrimSuffix(repo, "/") + `/` + v + `"` + "\n"
-			m[k] = alias
-			continue
-		}
-
-		str += "\t" + `"` + strings.TrimSuffix(repo, "/") + `/` + v + `"` + "\n"
+		str += "\t" + alias + `"` + imp + `"` + "\n"
 	}
 	str += ")\n\n"
 
@@ -99,7 +98,7 @@ var RegisterMiddleware = router.RegisterMiddleware
 	}
 	str += ")\n"
 
-	os.WriteFile(path+"/handler.go", sortImports(str), os.ModePerm)
+	os.WriteFile(path+"/handler.go", sortImports(str), 0o644)
 }
 
 func sortImports(data string) []byte {
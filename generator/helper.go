@@ -123,9 +123,25 @@ func CamelCase(s string) string {
 	return string(t)
 }
 
+// exported makes sure the CamelCased name of a generated type or field is an exported
+// identifier. Names whose first letter has no ASCII upper case (e.g. é) are upper-cased
+// and names not starting with a letter get an X prefix.
+func exported(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	if name == "" || unicode.IsUpper(r) {
+		return name
+	}
+
+	fixed := "X" + name
+	if unicode.IsLetter(r) && unicode.IsUpper(unicode.ToUpper(r)) {
+		fixed = string(unicode.ToUpper(r)) + name[size:]
+	}
+	return fixed
+}
+
 // CamelCaseSlice is like CamelCase, but the argument is a slice of strings to
-// be joined with "_".
-func CamelCaseSlice(elem []string) string { return CamelCase(strings.Join(elem, "_")) }
+// be joined with "_". It names the generated types, which are always exported.
+func CamelCaseSlice(elem []string) string { return exported(CamelCase(strings.Join(elem, "_"))) }
 
 // dottedSlice turns a sliced name into a dotted name.
 func dottedSlice(elem []string) string { return strings.Join(elem, ".") }
@@ -171,6 +187,15 @@ func isScalar(field *descriptor.FieldDescriptorProto) bool {
 	}
 }
 
+// Is this field a numeric scalar, neither bool nor enum?
+func isNumber(field *descriptor.FieldDescriptorProto) bool {
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_BOOL, descriptor.FieldDescriptorProto_TYPE_ENUM:
+		return false
+	}
+	return isScalar(field)
+}
+
 // badToUnderscore is the mapping function used to generate Go names from package names,
 // which can be dotted in the input .proto file.  It replaces non-identifier characters such as
 // dot or dash with underscore.
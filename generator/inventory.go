@@ -0,0 +1,73 @@
+package generator
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
+)
+
+// inventoryName is the file listing the outputs and their symbols with manifest=true.
+const inventoryName = "rain_manifest.json"
+
+// inventoryFile describes an output file: the proto it was generated from and the Go names
+// of the messages, enums and services it declares.
+type inventoryFile struct {
+	Name     string   `json:"name"`
+	Source   string   `json:"source"`
+	Messages []string `json:"messages,omitempty"`
+	Enums    []string `json:"enums,omitempty"`
+	Services []string `json:"services,omitempty"`
+}
+
+// generateInventory appends rain_manifest.json to the response, listing every generated
+// file sorted by name with the symbols it declares, so that build caches can tell what changed.
+// The model file declares the messages and enums of a proto, the other files its services.
+func (g *Generator) generateInventory() {
+	sources := make(map[string]*FileDescriptor)
+	for _, file := range g.genFiles {
+		for _, suffix := range []string{".model.go", g.apiSuffix, ".fuzz.go", ".iface.go", ".openapi.json"} {
+			sources[file.goFileName(g.pathType, suffix)] = file
+		}
+	}
+
+	files := make([]inventoryFile, 0, len(g.Response.File))
+	for _, f := range g.Response.File {
+		file, ok := sources[f.GetName()]
+		if !ok {
+			continue
+		}
+
+		entry := inventoryFile{Name: f.GetName(), Source: file.GetName()}
+		if f.GetName() == file.goFileName(g.pathType, ".model.go") {
+			for _, desc := range file.desc {
+				if !desc.GetOptions().GetMapEntry() {
+					entry.Messages = append(entry.Messages, CamelCaseSlice(desc.TypeName()))
+				}
+			}
+			for _, enum := range file.enum {
+				entry.Enums = append(entry.Enums, CamelCaseSlice(enum.TypeName()))
+			}
+		} else {
+			for _, service := range file.Service {
+				entry.Services = append(entry.Services, CamelCase(service.GetName()))
+			}
+		}
+		sort.Strings(entry.Messages)
+		sort.Strings(entry.Enums)
+		sort.Strings(entry.Services)
+		files = append(files, entry)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	out, err := json.MarshalIndent(map[string][]inventoryFile{"files": files}, "", "  ")
+	if err != nil {
+		g.Fail("manifest:", err.Error())
+	}
+
+	g.Response.File = append(g.Response.File, &plugin.CodeGeneratorResponse_File{
+		Name:    proto.String(inventoryName),
+		Content: proto.String(string(out) + "\n"),
+	})
+}
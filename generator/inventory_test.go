@@ -0,0 +1,41 @@
+package generator
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+func TestInventory(t *testing.T) {
+	file := goldenUserFile()
+	file.MessageType[1].NestedType = []*descriptor.DescriptorProto{goldenMessage("Address")}
+	file.EnumType = []*descriptor.EnumDescriptorProto{goldenEnum("Status", []string{"STATUS_UNKNOWN"}, []int32{0})}
+	other := goldenFile("group/group.proto", []*descriptor.DescriptorProto{goldenMessage("Group")}, nil, nil)
+
+	resp := generateGolden(t, "manifest=true", other, file)
+	if last := resp.File[len(resp.File)-1]; last.GetName() != inventoryName {
+		t.Fatalf("last generated file is %s, want %s", last.GetName(), inventoryName)
+	}
+
+	var got map[string][]inventoryFile
+	if err := json.Unmarshal([]byte(goldenContent(t, resp, inventoryName)), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]inventoryFile{"files": {
+		{Name: "group/group.api.go", Source: "group/group.proto"},
+		{Name: "group/group.model.go", Source: "group/group.proto", Messages: []string{"Group"}},
+		{Name: "user/user.api.go", Source: "user/user.proto", Services: []string{"UserService"}},
+		{Name: "user/user.model.go", Source: "user/user.proto", Messages: []string{"Empty", "GetUserReq", "User", "User_Address"}, Enums: []string{"Status"}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("manifest = %+v, want %+v", got, want)
+	}
+
+	// The manifest doesn't depend on the order of the files.
+	again := generateGolden(t, "manifest=true", file, other)
+	if a, b := goldenContent(t, resp, inventoryName), goldenContent(t, again, inventoryName); a != b {
+		t.Errorf("manifest depends on the order of the files:\n%s\n%s", a, b)
+	}
+}
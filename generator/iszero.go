@@ -0,0 +1,50 @@
+package generator
+
+import (
+	"strings"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// generateIsZero prints IsZero, reporting whether every field of the message holds its
+// zero value. Nested messages delegate to their own IsZero and a nil message is zero.
+func (g *Generator) generateIsZero(mc *msgCtx, topLevelFields []topLevelField) {
+	recv := g.receiverName(mc.goName)
+
+	g.P("// IsZero reports whether all fields of ", mc.goName, " are at their zero value.")
+	g.P("func (", recv, " *", mc.goName, ") IsZero() bool {")
+	g.P("if ", recv, " == nil {")
+	g.P("return true")
+	g.P("}")
+	g.P()
+	for i, field := range mc.message.Field {
+		f, ok := topLevelFields[i].(*simpleField)
+		if !ok {
+			continue
+		}
+		g.P("if ", g.nonZero(field, f, recv+"."+f.goName), " {")
+		g.P("return false")
+		g.P("}")
+	}
+	g.P()
+	g.P("return true")
+	g.P("}")
+	g.P()
+}
+
+// nonZero returns the condition under which the field x holds a non-zero value.
+func (g *Generator) nonZero(field *descriptor.FieldDescriptorProto, f *simpleField, x string) string {
+	switch {
+	case isRepeated(field):
+		return "len(" + x + ") > 0"
+	case strings.Contains(f.goType, "interface{}"):
+		return x + " != nil"
+	case field.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE && !isWellKnownType(field):
+		return "!" + x + ".IsZero()"
+	case strings.HasPrefix(f.goType, "*"):
+		return x + " != nil"
+	case f.goType == "json.Number":
+		return x + ` != ""`
+	}
+	return zeroCheck(field, x)
+}
@@ -0,0 +1,51 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// profileIsZeroTest checks the IsZero of the Profile of TestGoldenIsZero.
+const profileIsZeroTest = `package user
+
+import "testing"
+
+func TestProfileIsZero(t *testing.T) {
+	tests := []struct {
+		name string
+		m    *Profile
+		want bool
+	}{
+		{"nil", nil, true},
+		{"all zero", &Profile{}, true},
+		{"zero child", &Profile{Child: &Profile{}}, true},
+		{"name", &Profile{Name: "ann"}, false},
+		{"age", &Profile{Age: 1}, false},
+		{"tags", &Profile{Tags: []string{"admin"}}, false},
+		{"child", &Profile{Child: &Profile{Age: 1}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.m.IsZero(); got != tt.want {
+			t.Errorf("%s: IsZero() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+`
+
+func TestGoldenIsZero(t *testing.T) {
+	file := goldenFile("user/user.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("Profile",
+				goldenField("name", 1, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+				goldenField("age", 2, descriptor.FieldDescriptorProto_TYPE_INT32, ""),
+				goldenRepeated(goldenField("tags", 3, descriptor.FieldDescriptorProto_TYPE_STRING, "")),
+				goldenField("child", 4, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".user.Profile"),
+			),
+		},
+		nil, nil)
+
+	resp := generateGolden(t, "iszero=true", file)
+	checkGolden(t, "iszero", resp)
+	compileGolden(t, resp, map[string]string{"router/user/iszero_test.go": profileIsZeroTest})
+}
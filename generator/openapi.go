@@ -0,0 +1,429 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// openAPIObject is a JSON object of the OpenAPI document.
+type openAPIObject = map[string]interface{}
+
+// generateOpenAPIFile prints an OpenAPI 3.0 document describing the routes of the services
+// of the file, as registered by generateService, additional_bindings included, and the
+// messages and enums they use.
+// Responses are described wrapped in the router.Response envelope router.JSON writes.
+func (g *Generator) generateOpenAPIFile(file *FileDescriptor) {
+	g.file = file
+
+	schemas := openAPIObject{}
+	paths := openAPIObject{}
+	for index, service := range file.FileDescriptorProto.Service {
+		servName := CamelCase(service.GetName())
+		prefix := g.servicePrefix(file, index)
+		_, methodAnnotations := g.methodAnnotations(index, service)
+		serviceComment, _ := g.makeComments(fmt.Sprintf("6,%d", index))
+		var errorEnum *EnumDescriptor
+		if val, ok := parseAnnotations(serviceComment)["errors"]; ok {
+			errorEnum, _ = g.errorEnum(file, val)
+		}
+
+		for i, method := range service.Method {
+			// Every binding is an operation of its own, named like the adapter method serving it.
+			names := g.adapterMethodNames(method)
+			for j, rule := range g.httpRules(method) {
+				verb, url := g.httpPattern(method, rule)
+				url = regPathParam.ReplaceAllString(prefix+url, "{$1}")
+
+				op := openAPIObject{
+					"operationId": servName + "_" + names[j],
+					"tags":        []string{servName},
+					"responses":   g.openAPIResponses(verb, method, methodAnnotations[i], errorEnum, schemas),
+				}
+				if doc := openAPIDoc(file, fmt.Sprintf("6,%d,2,%d", index, i)); doc != "" {
+					op["summary"] = strings.SplitN(doc, "\n", 2)[0]
+					op["description"] = doc
+				}
+				if method.GetOptions().GetDeprecated() {
+					op["deprecated"] = true
+				}
+
+				params, body := g.openAPIInput(verb, url, method, methodAnnotations[i], schemas)
+				if len(params) > 0 {
+					op["parameters"] = params
+				}
+				if body != nil {
+					op["requestBody"] = body
+				}
+
+				item, ok := paths[url].(openAPIObject)
+				if !ok {
+					item = openAPIObject{}
+					paths[url] = item
+				}
+				item[strings.ToLower(verb)] = op
+			}
+		}
+	}
+
+	doc := openAPIObject{
+		"openapi": "3.0.3",
+		"info": openAPIObject{
+			"title":   file.GetName(),
+			"version": "1.0.0",
+		},
+		"paths": paths,
+	}
+	if len(schemas) > 0 {
+		doc["components"] = openAPIObject{"schemas": schemas}
+	}
+
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		g.Fail("openapi:", err.Error())
+	}
+	g.Write(out)
+	g.WriteString("\n")
+}
+
+// openAPIDoc returns the leading and trailing comments at path in file without their @tag lines.
+func openAPIDoc(file *FileDescriptor, path string) string {
+	loc, ok := file.comments[path]
+	if !ok {
+		return ""
+	}
+
+	var lines []string
+	for _, line := range strings.Split(commentText(loc), "\n") {
+		if !regAnnotation.MatchString(line) {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// openAPIInput returns the parameters and request body of a route: the path variables,
+// then the fields of the input bound from the query for read-only verbs or the body otherwise.
+func (g *Generator) openAPIInput(verb, url string, method *descriptor.MethodDescriptorProto, customAnnotations map[string]string, schemas openAPIObject) ([]openAPIObject, openAPIObject) {
+	message, ok := g.ObjectNamed(method.GetInputType()).(*Descriptor)
+	if !ok || method.GetInputType() == ".google.protobuf.Empty" || len(message.Field) == 0 {
+		return nil, nil
+	}
+
+	var params []openAPIObject
+	inPath := map[string]bool{}
+	for _, m := range regPathParam.FindAllStringSubmatch(url, -1) {
+		msg, field := g.openAPIFieldByPath(message, m[1])
+		if field == nil {
+			g.Fail("path variable:", m[1], "is not a field of", message.GetName())
+		}
+		inPath[m[1]] = true
+		params = append(params, openAPIObject{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   g.openAPIFieldSchema(msg, field, schemas),
+		})
+	}
+
+	contentType := "application/json"
+	switch strings.ToLower(customAnnotations["binding"]) {
+	case "form", "formpost":
+		contentType = "application/x-www-form-urlencoded"
+	case "formmultipart":
+		contentType = "multipart/form-data"
+	case "query":
+		contentType = ""
+	}
+
+	if !readOnlyVerbs[verb] && contentType != "" {
+		return params, openAPIObject{
+			"required": true,
+			"content": openAPIObject{
+				contentType: openAPIObject{"schema": g.openAPIRef(message, schemas)},
+			},
+		}
+	}
+
+	for i, field := range message.Field {
+		if inPath[field.GetName()] || inPath[field.GetJsonName()] {
+			continue
+		}
+		if _, isMap := g.mapEntry(field); isMap || field.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+			continue
+		}
+		param := openAPIObject{
+			"name":   g.formName(field),
+			"in":     "query",
+			"schema": g.openAPIFieldSchema(message, field, schemas),
+		}
+		if loc, ok := message.file.comments[fmt.Sprintf("%s,%d,%d", message.path, messageFieldPath, i)]; ok && hasValidateRule(parseAnnotations(commentText(loc))["validate"], "required") {
+			param["required"] = true
+		}
+		params = append(params, param)
+	}
+	return params, nil
+}
+
+// hasValidateRule reports whether the validate annotation value, e.g. required,email, has
+// the rule.
+func hasValidateRule(validate, rule string) bool {
+	for _, r := range strings.Split(validate, ",") {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// openAPIResponses returns the responses of a route: its success status with the output in
+// the data of the envelope, the event stream of server-streaming methods, or no content for
+// HEAD, and the envelope alone on error, its code listing the values of the service's
+// errors:<enum> if any.
+func (g *Generator) openAPIResponses(verb string, method *descriptor.MethodDescriptorProto, customAnnotations map[string]string, errorEnum *EnumDescriptor, schemas openAPIObject) openAPIObject {
+	envelope := func(data openAPIObject) openAPIObject {
+		props := openAPIObject{
+			"code": openAPIObject{"type": "integer"},
+			"msg":  openAPIObject{"type": "string"},
+		}
+		if data == nil && errorEnum != nil {
+			props["code"] = openAPIErrorCodes(errorEnum)
+		}
+		if data != nil {
+			props["data"] = data
+		}
+		return openAPIObject{
+			"application/json": openAPIObject{
+				"schema": openAPIObject{"type": "object", "properties": props},
+			},
+		}
+	}
+
+	status := "200"
+	if val, ok := g.successStatus(customAnnotations); ok {
+		status = val
+	}
+
+	success := openAPIObject{"description": "OK"}
+	if verb != "HEAD" {
+		data := openAPIObject{"type": "object"}
+		if message, ok := g.ObjectNamed(method.GetOutputType()).(*Descriptor); ok && method.GetOutputType() != ".google.protobuf.Empty" {
+			data = g.openAPIRef(message, schemas)
+		}
+		success["content"] = envelope(data)
+		if method.GetServerStreaming() {
+			// Server-streaming methods send every output as the data of a server-sent event.
+			success["content"] = openAPIObject{
+				"text/event-stream": openAPIObject{"schema": data},
+			}
+		}
+	}
+
+	return openAPIObject{
+		status: success,
+		"default": openAPIObject{
+			"description": "Error",
+			"content":     envelope(nil),
+		},
+	}
+}
+
+// openAPIErrorCodes returns the schema of the envelope code of an error from the enum named
+// by errors:<enum>: the numbers of its values, described with their names and the external
+// codes given by code:<code> annotations on them.
+func openAPIErrorCodes(enum *EnumDescriptor) openAPIObject {
+	var values []int32
+	var lines []string
+	seen := make(map[int32]bool)
+	for i, e := range enum.Value {
+		if !seen[e.GetNumber()] {
+			seen[e.GetNumber()] = true
+			values = append(values, e.GetNumber())
+		}
+		line := fmt.Sprintf("%d: %s", e.GetNumber(), e.GetName())
+		if loc, ok := enum.file.comments[fmt.Sprintf("%s,%d,%d", enum.path, enumValuePath, i)]; ok {
+			if code, ok := parseAnnotations(commentText(loc))["code"]; ok {
+				line += " (" + code + ")"
+			}
+		}
+		lines = append(lines, line)
+	}
+	return openAPIObject{
+		"type":        "integer",
+		"enum":        values,
+		"description": strings.Join(lines, "\n"),
+	}
+}
+
+// openAPIFieldByPath returns the field a dotted path variable like user.id names, and the
+// message declaring it.
+func (g *Generator) openAPIFieldByPath(message *Descriptor, name string) (*Descriptor, *descriptor.FieldDescriptorProto) {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		field := g.fieldByName(message, part)
+		if field == nil || i == len(parts)-1 {
+			return message, field
+		}
+		next, ok := g.ObjectNamed(field.GetTypeName()).(*Descriptor)
+		if !ok {
+			return nil, nil
+		}
+		message = next
+	}
+	return nil, nil
+}
+
+// openAPISchemaName returns the name of the schema of a message or enum: its full proto name.
+func openAPISchemaName(obj Object) string {
+	return obj.File().GetPackage() + "." + strings.Join(obj.TypeName(), ".")
+}
+
+// openAPIRef returns a reference to the schema of a message, adding it to schemas first.
+func (g *Generator) openAPIRef(message *Descriptor, schemas openAPIObject) openAPIObject {
+	name := openAPISchemaName(message)
+	ref := openAPIObject{"$ref": "#/components/schemas/" + name}
+	if _, ok := schemas[name]; ok {
+		return ref
+	}
+
+	schema := openAPIObject{"type": "object"}
+	schemas[name] = schema // registered before the fields so that recursive messages terminate
+
+	props := openAPIObject{}
+	for _, field := range message.Field {
+		props[g.jsonName(field)] = g.openAPIFieldSchema(message, field, schemas)
+	}
+	if len(props) > 0 {
+		schema["properties"] = props
+	}
+	if doc := openAPIDoc(message.file, message.path); doc != "" {
+		schema["description"] = doc
+	}
+	return ref
+}
+
+// openAPIEnumRef returns a reference to the schema of an enum, adding it to schemas first.
+// Enums are written as their numbers by the generated structs; x-enum-varnames names them
+// and x-enum-descriptions holds the comments of the values, if any.
+func (g *Generator) openAPIEnumRef(enum *EnumDescriptor, schemas openAPIObject) openAPIObject {
+	name := openAPISchemaName(enum)
+	ref := openAPIObject{"$ref": "#/components/schemas/" + name}
+	if _, ok := schemas[name]; ok {
+		return ref
+	}
+
+	var (
+		values     []int32
+		names      []string
+		docs       []string
+		documented bool
+	)
+	seen := map[int32]bool{}
+	for i, v := range enum.Value {
+		if seen[v.GetNumber()] { // aliases share the number of the first value
+			continue
+		}
+		seen[v.GetNumber()] = true
+
+		doc := openAPIDoc(enum.file, fmt.Sprintf("%s,%d,%d", enum.path, enumValuePath, i))
+		documented = documented || doc != ""
+		values = append(values, v.GetNumber())
+		names = append(names, v.GetName())
+		docs = append(docs, doc)
+	}
+
+	schema := openAPIObject{
+		"type":            "integer",
+		"format":          "int32",
+		"enum":            values,
+		"x-enum-varnames": names,
+	}
+	if documented {
+		schema["x-enum-descriptions"] = docs
+	}
+	if doc := openAPIDoc(enum.file, enum.path); doc != "" {
+		schema["description"] = doc
+	}
+	schemas[name] = schema
+	return ref
+}
+
+// openAPIFieldSchema returns the schema of a field of message, derived from its Go type.
+func (g *Generator) openAPIFieldSchema(message *Descriptor, field *descriptor.FieldDescriptorProto, schemas openAPIObject) openAPIObject {
+	if d, ok := g.mapEntry(field); ok {
+		return openAPIObject{
+			"type":                 "object",
+			"additionalProperties": g.openAPIFieldSchema(d, d.Field[1], schemas),
+		}
+	}
+
+	var elem openAPIObject
+	typ, _ := g.GoType("", message, field)
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		elem = g.openAPIEnumRef(g.ObjectNamed(field.GetTypeName()).(*EnumDescriptor), schemas)
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		elem = openAPIObject{"type": "string", "format": "byte"}
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_GROUP:
+		if isRepeated(field) {
+			typ = strings.TrimPrefix(typ, "[]")
+		}
+		switch typ {
+		case "interface{}":
+			elem = openAPIObject{}
+		case "map[string]interface{}":
+			elem = openAPIObject{"type": "object"}
+		case "[]interface{}":
+			elem = openAPIObject{"type": "array", "items": openAPIObject{}}
+		default:
+			if wrapper, ok := wrapperTypes[field.GetTypeName()]; ok {
+				elem = openAPIObject{"type": "string", "format": "byte"}
+				if wrapper != "*[]byte" {
+					elem = openAPIScalar(strings.TrimPrefix(wrapper, "*"))
+				}
+				elem["nullable"] = true
+			} else if isWellKnownType(field) {
+				elem = openAPIObject{"type": "object"}
+			} else {
+				elem = g.openAPIRef(g.ObjectNamed(field.GetTypeName()).(*Descriptor), schemas)
+			}
+		}
+	default:
+		elem = openAPIScalar(strings.TrimPrefix(strings.TrimPrefix(typ, "[]"), "*"))
+		if isJSString(field) {
+			elem = openAPIObject{"type": "string", "format": elem["format"]}
+		}
+	}
+
+	if isRepeated(field) {
+		return openAPIObject{"type": "array", "items": elem}
+	}
+	return elem
+}
+
+// openAPIScalar returns the schema of a scalar Go type.
+func openAPIScalar(typ string) openAPIObject {
+	switch typ {
+	case "bool":
+		return openAPIObject{"type": "boolean"}
+	case "json.Number":
+		return openAPIObject{"type": "number"}
+	case "string":
+		return openAPIObject{"type": "string"}
+	case "float32":
+		return openAPIObject{"type": "number", "format": "float"}
+	case "float64":
+		return openAPIObject{"type": "number", "format": "double"}
+	case "int32":
+		return openAPIObject{"type": "integer", "format": "int32"}
+	case "uint32", "int64", "uint64":
+		schema := openAPIObject{"type": "integer", "format": "int64"}
+		if strings.HasPrefix(typ, "u") {
+			schema["minimum"] = 0
+		}
+		return schema
+	}
+	return openAPIObject{}
+}
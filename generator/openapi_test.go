@@ -0,0 +1,175 @@
+package generator
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"google.golang.org/genproto/googleapis/api/annotations"
+)
+
+func TestOpenAPIErrorCodes(t *testing.T) {
+	file := goldenUserFile()
+	file.EnumType = append(file.EnumType, goldenEnum("UserError", []string{"USER_OK", "USER_NOT_FOUND", "USER_MISSING"}, []int32{0, 404, 404}))
+	goldenComment(file, " @tag errors:UserError\n", 6, 0)
+	goldenComment(file, " @tag code:E_NOT_FOUND\n", 5, 0, 2, 1)
+
+	resp := generateGolden(t, "gen_openapi=true", file)
+
+	var doc struct {
+		Paths map[string]map[string]struct {
+			Responses map[string]struct {
+				Content map[string]struct {
+					Schema struct {
+						Properties map[string]struct {
+							Enum        []int32
+							Description string
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(goldenContent(t, resp, "user/user.openapi.json")), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	code := doc.Paths["/v1/users/{user_id}"]["get"].Responses["default"].Content["application/json"].Schema.Properties["code"]
+	if want := []int32{0, 404}; !reflect.DeepEqual(code.Enum, want) {
+		t.Errorf("error code enum = %v, want %v", code.Enum, want)
+	}
+	if want := "0: USER_OK\n404: USER_NOT_FOUND (E_NOT_FOUND)\n404: USER_MISSING"; code.Description != want {
+		t.Errorf("error code description = %q, want %q", code.Description, want)
+	}
+}
+
+func TestOpenAPIQueryParameters(t *testing.T) {
+	file := goldenFile("search/search.proto",
+		[]*descriptor.DescriptorProto{
+			goldenMessage("SearchReq",
+				goldenField("org_id", 1, descriptor.FieldDescriptorProto_TYPE_INT64, ""),
+				goldenField("query", 2, descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+				goldenRepeated(goldenField("tags", 3, descriptor.FieldDescriptorProto_TYPE_STRING, "")),
+				goldenField("page", 4, descriptor.FieldDescriptorProto_TYPE_INT32, ""),
+			),
+		},
+		nil,
+		[]*descriptor.ServiceDescriptorProto{
+			goldenService("SearchService",
+				goldenMethod("Search", ".search.SearchReq", ".search.SearchReq", "GET", "/v1/orgs/{org_id}/search"),
+			),
+		})
+	goldenComment(file, " @tag validate:required,min=2\n", 4, 0, 2, 1)
+
+	resp := generateGolden(t, "gen_openapi=true", file)
+
+	var doc struct {
+		Paths map[string]map[string]struct {
+			Parameters []struct {
+				Name     string
+				In       string
+				Required bool
+				Schema   struct {
+					Type  string
+					Items struct{ Type string }
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(goldenContent(t, resp, "search/search.openapi.json")), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	type param struct {
+		name, in  string
+		required  bool
+		typ, item string
+	}
+	var got []param
+	for _, p := range doc.Paths["/v1/orgs/{org_id}/search"]["get"].Parameters {
+		got = append(got, param{p.Name, p.In, p.Required, p.Schema.Type, p.Schema.Items.Type})
+	}
+	want := []param{
+		{"org_id", "path", true, "integer", ""},
+		{"query", "query", true, "string", ""},
+		{"tags", "query", false, "array", "string"},
+		{"page", "query", false, "integer", ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parameters = %+v, want %+v", got, want)
+	}
+}
+
+func TestOpenAPIEnumVarnames(t *testing.T) {
+	file := goldenUserFile()
+	file.EnumType = append(file.EnumType,
+		goldenEnum("Status", []string{"STATUS_UNKNOWN", "STATUS_ACTIVE", "STATUS_ENABLED", "STATUS_BANNED"}, []int32{0, 1, 1, 2}),
+		goldenEnum("Role", []string{"ROLE_USER", "ROLE_ADMIN"}, []int32{0, 1}),
+	)
+	file.MessageType[1].Field = append(file.MessageType[1].Field,
+		goldenField("status", 4, descriptor.FieldDescriptorProto_TYPE_ENUM, ".user.Status"),
+		goldenField("role", 5, descriptor.FieldDescriptorProto_TYPE_ENUM, ".user.Role"),
+	)
+	goldenComment(file, " Banned users can't sign in.\n", 5, 0, 2, 3)
+
+	resp := generateGolden(t, "gen_openapi=true", file)
+
+	var doc struct {
+		Components struct {
+			Schemas map[string]struct {
+				Enum         []int32
+				Varnames     []string `json:"x-enum-varnames"`
+				Descriptions []string `json:"x-enum-descriptions"`
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(goldenContent(t, resp, "user/user.openapi.json")), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	status := doc.Components.Schemas["user.Status"]
+	if want := []int32{0, 1, 2}; !reflect.DeepEqual(status.Enum, want) {
+		t.Errorf("Status enum = %v, want %v", status.Enum, want)
+	}
+	if want := []string{"STATUS_UNKNOWN", "STATUS_ACTIVE", "STATUS_BANNED"}; !reflect.DeepEqual(status.Varnames, want) {
+		t.Errorf("Status x-enum-varnames = %q, want %q without the alias", status.Varnames, want)
+	}
+	if want := []string{"", "", "Banned users can't sign in."}; !reflect.DeepEqual(status.Descriptions, want) {
+		t.Errorf("Status x-enum-descriptions = %q, want %q", status.Descriptions, want)
+	}
+
+	role := doc.Components.Schemas["user.Role"]
+	if want := []string{"ROLE_USER", "ROLE_ADMIN"}; !reflect.DeepEqual(role.Varnames, want) || role.Descriptions != nil {
+		t.Errorf("Role x-enum-varnames = %q, x-enum-descriptions = %q, want %q and no descriptions", role.Varnames, role.Descriptions, want)
+	}
+}
+
+func TestGoldenOpenAPIBindings(t *testing.T) {
+	file := goldenUserFile()
+	goldenBindings(file.Service[0].Method[0],
+		&annotations.HttpRule{Pattern: &annotations.HttpRule_Post{Post: "/v1/lookups"}, Body: "*"},
+		&annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: "/v1/accounts/{user_id}"}},
+	)
+
+	resp := generateGolden(t, "gen_openapi=true", file)
+	checkGolden(t, "openapi_bindings", resp)
+
+	var doc struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		}
+	}
+	if err := json.Unmarshal([]byte(goldenContent(t, resp, "user/user.openapi.json")), &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct{ path, verb, id string }{
+		{"/v1/users/{user_id}", "get", "UserService_GetUser"},
+		{"/v1/lookups", "post", "UserService_GetUserBinding1"},
+		{"/v1/accounts/{user_id}", "get", "UserService_GetUserBinding2"},
+	} {
+		if got := doc.Paths[tt.path][tt.verb].OperationID; got != tt.id {
+			t.Errorf("operationId of %s %s = %q, want %q", tt.verb, tt.path, got, tt.id)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package generator
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// regPathParam matches the {name} and {name=segment/*} path variables of a URL template.
+var regPathParam = regexp.MustCompile(`\{([^}=]+)(?:=([^}]*))?\}`)
+
+// pathParamSegments returns the gin path segments registered for the path variable name
+// bound to the template tmpl, and the Go expression rebuilding its value from them.
+// Each * segment of the template becomes a gin parameter, the literal segments are kept.
+func (g *Generator) pathParamSegments(name, tmpl string) (string, string) {
+	if tmpl == "" || tmpl == "*" {
+		return ":" + name, `ctx.Param("` + name + `")`
+	}
+
+	var segs, expr []string
+	lit, n := "", 0
+	for i, seg := range strings.Split(tmpl, "/") {
+		if i > 0 {
+			lit += "/"
+		}
+		switch {
+		case seg == "*":
+			n++
+			param := name + "_" + strconv.Itoa(n)
+			segs = append(segs, ":"+param)
+			if lit != "" {
+				expr = append(expr, strconv.Quote(lit))
+			}
+			expr = append(expr, `ctx.Param("`+param+`")`)
+			lit = ""
+		case strings.Contains(seg, "*"):
+			g.Fail("path variable", name, "has an unsupported template", tmpl)
+		default:
+			segs = append(segs, seg)
+			lit += seg
+		}
+	}
+	if lit != "" {
+		expr = append(expr, strconv.Quote(lit))
+	}
+	return strings.Join(segs, "/"), strings.Join(expr, " + ")
+}
+
+// ginPath translates the {name} and {name=segment/*} path variables of url to gin parameters.
+// The {name=**} segments are left to regWildcard.
+func (g *Generator) ginPath(url string) string {
+	return regPathParam.ReplaceAllStringFunc(url, func(s string) string {
+		m := regPathParam.FindStringSubmatch(s)
+		if m[2] == "**" {
+			return s
+		}
+		segs, _ := g.pathParamSegments(m[1], m[2])
+		return segs
+	})
+}
+
+// generatePathParams prints the assignment of the path variables of url to the input
+// fields they name, by proto, JSON or Go name. Dotted names like {user.id} set the field
+// of a nested message, allocated on the way. Numeric and bool values are parsed, enum
+// values are looked up by name, or by name or number with enum_parse=true, and invalid
+// values fail the request.
+func (g *Generator) generatePathParams(method *descriptor.MethodDescriptorProto, url, code string) {
+	matches := regPathParam.FindAllStringSubmatch(url, -1)
+	if len(matches) == 0 {
+		return
+	}
+
+	message, ok := g.ObjectNamed(method.GetInputType()).(*Descriptor)
+	if !ok {
+		g.Fail("path variable: input of", method.GetName(), "is not a message")
+	}
+
+	for _, m := range matches {
+		if m[2] == "**" {
+			continue
+		}
+
+		target, msg := "input", message
+		parts := strings.Split(m[1], ".")
+		var field *descriptor.FieldDescriptorProto
+		for i, part := range parts {
+			field = g.fieldByName(msg, part)
+			if field == nil || isRepeated(field) {
+				g.Fail("path variable:", m[1], "is not a singular field of", message.GetName())
+			}
+			target += "." + g.fieldGoName(msg, field)
+			if i == len(parts)-1 {
+				break
+			}
+
+			next, ok := g.ObjectNamed(field.GetTypeName()).(*Descriptor)
+			if !ok || field.GetType() != descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+				g.Fail("path variable:", m[1], "goes through", field.GetName(), "which is not a message")
+			}
+			if typ, _ := g.GoType("", msg, field); strings.HasPrefix(typ, "*") {
+				g.P(`if ` + target + ` == nil {`)
+				g.P(target + ` = new(` + strings.TrimPrefix(typ, "*") + `)`)
+				g.P(`}`)
+			}
+			msg = next
+		}
+
+		_, value := g.pathParamSegments(m[1], m[2])
+		if m[2] != "" && m[2] != "*" && field.GetType() != descriptor.FieldDescriptorProto_TYPE_STRING {
+			g.Fail("path variable:", m[1], "with a segment template must be a string field")
+		}
+
+		typ, _ := g.GoType("", msg, field)
+		g.generatePathParamAssign(field, target, typ, value, code)
+	}
+	g.P()
+}
+
+// generatePathParamAssign prints the conversion of the path parameter value to the type
+// of field and its assignment to target.
+func (g *Generator) generatePathParamAssign(field *descriptor.FieldDescriptorProto, target, typ, value, code string) {
+	elem := strings.TrimPrefix(typ, "*")
+	parse, parsed := "", elem
+	assign := func(v string) {
+		if parsed != elem {
+			v = elem + `(` + v + `)`
+		}
+		if typ != elem {
+			g.P(`x := ` + v)
+			g.P(target + ` = &x`)
+			return
+		}
+		g.P(target + ` = ` + v)
+	}
+
+	if elem == "json.Number" {
+		// The value is kept verbatim once checked to be a number.
+		g.addExternalImport("encoding/json", "")
+		g.addExternalImport("strconv", "")
+		parsed = "string"
+		g.P(`if _, err := strconv.ParseFloat(` + value + `, 64); err == nil {`)
+		assign(value)
+		g.P(`} else {`)
+		g.P(g.errorCall(code))
+		g.P(g.returnErr())
+		g.P(`}`)
+		return
+	}
+
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		if typ == elem {
+			g.P(target + ` = ` + value)
+			return
+		}
+		g.P(`{`)
+		assign(value)
+		g.P(`}`)
+		return
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		if g.enumParse {
+			// Numbers and names are accepted, as router.EnumQuery does in the query.
+			parsed = elem
+			g.P(`if v, err := router.EnumValue(` + value + `, ` + enumParseFunc(elem) + `); err == nil {`)
+			assign("v")
+			g.P(`} else {`)
+			g.P(g.errorCall(code))
+			g.P(g.returnErr())
+			g.P(`}`)
+			return
+		}
+
+		g.addExternalImport("fmt", "")
+
+		parsed = "int32"
+		g.P(`if v, ok := ` + g.enumValueLookup(elem, value) + `; ok {`)
+		assign("v")
+		g.P(`} else {`)
+		g.P(`err := fmt.Errorf("invalid ` + field.GetName() + `: %q", ` + value + `)`)
+		g.P(g.errorCall(code))
+		g.P(g.returnErr())
+		g.P(`}`)
+		return
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		parse, parsed = `strconv.ParseBool(`+value+`)`, "bool"
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		parse, parsed = `strconv.ParseFloat(`+value+`, 32)`, "float64"
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		parse, parsed = `strconv.ParseFloat(`+value+`, 64)`, "float64"
+	case descriptor.FieldDescriptorProto_TYPE_INT32, descriptor.FieldDescriptorProto_TYPE_SINT32, descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		parse, parsed = `strconv.ParseInt(`+value+`, 10, 32)`, "int64"
+	case descriptor.FieldDescriptorProto_TYPE_INT64, descriptor.FieldDescriptorProto_TYPE_SINT64, descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		parse, parsed = `strconv.ParseInt(`+value+`, 10, 64)`, "int64"
+	case descriptor.FieldDescriptorProto_TYPE_UINT32, descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		parse, parsed = `strconv.ParseUint(`+value+`, 10, 32)`, "uint64"
+	case descriptor.FieldDescriptorProto_TYPE_UINT64, descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		parse, parsed = `strconv.ParseUint(`+value+`, 10, 64)`, "uint64"
+	default:
+		g.Fail("path variable:", field.GetName(), "must be a scalar or enum field")
+	}
+
+	g.addExternalImport("strconv", "")
+
+	g.P(`if v, err := ` + parse + `; err == nil {`)
+	assign("v")
+	g.P(`} else {`)
+	g.P(g.errorCall(code))
+	g.P(g.returnErr())
+	g.P(`}`)
+}
@@ -0,0 +1,43 @@
+package generator
+
+// generatePool prints Reset and the sync.Pool backed GetXxx/PutXxx pair of a request or
+// response message. PutXxx resets the message before handing it back to the pool.
+func (g *Generator) generatePool(mc *msgCtx) {
+	for _, desc := range g.file.desc {
+		if name := CamelCaseSlice(desc.TypeName()); name == "Get"+mc.goName || name == "Put"+mc.goName {
+			g.Fail("pool: Get/Put functions of", mc.goName, "collide with message", name)
+		}
+	}
+
+	g.addExternalImport("sync", "")
+
+	recv := g.receiverName(mc.goName)
+	pool := "pool" + mc.goName
+
+	g.P("// Reset sets all fields of ", mc.goName, " to their zero value.")
+	g.P("func (", recv, " *", mc.goName, ") Reset() {")
+	g.P("*", recv, " = ", mc.goName, "{}")
+	g.P("}")
+	g.P()
+	g.P("var ", pool, " = sync.Pool{")
+	g.P("New: func() any {")
+	g.P("return new(", mc.goName, ")")
+	g.P("},")
+	g.P("}")
+	g.P()
+	g.P("// Get", mc.goName, " returns an empty ", mc.goName, " from the pool.")
+	g.P("func Get", mc.goName, "() *", mc.goName, " {")
+	g.P("return ", pool, ".Get().(*", mc.goName, ")")
+	g.P("}")
+	g.P()
+	g.P("// Put", mc.goName, " resets m and returns it to the pool. m must not be used afterwards.")
+	g.P("func Put", mc.goName, "(m *", mc.goName, ") {")
+	g.P("if m == nil {")
+	g.P("return")
+	g.P("}")
+	g.P()
+	g.P("m.Reset()")
+	g.P(pool, ".Put(m)")
+	g.P("}")
+	g.P()
+}
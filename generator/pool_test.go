@@ -0,0 +1,33 @@
+package generator
+
+import "testing"
+
+// userPoolTest round-trips a User through the pool of TestGoldenPool.
+const userPoolTest = `package user
+
+import "testing"
+
+func TestUserPool(t *testing.T) {
+	m := GetUser()
+	if m.UserId != 0 || m.UserName != "" || m.Tags != nil {
+		t.Fatalf("GetUser() = %+v, want an empty User", m)
+	}
+
+	m.UserId, m.UserName, m.Tags = 1, "ann", []string{"admin"}
+	PutUser(m)
+	if m.UserId != 0 || m.UserName != "" || m.Tags != nil {
+		t.Errorf("PutUser left %+v, want it reset", m)
+	}
+	if m := GetUser(); m.UserId != 0 || m.UserName != "" || m.Tags != nil {
+		t.Errorf("GetUser() after PutUser = %+v, want an empty User", m)
+	}
+
+	PutUser(nil)
+}
+`
+
+func TestGoldenPool(t *testing.T) {
+	resp := generateGolden(t, "pool=true", goldenUserFile())
+	checkGolden(t, "pool", resp)
+	compileGolden(t, resp, map[string]string{"router/user/pool_test.go": userPoolTest})
+}
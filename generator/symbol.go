@@ -31,12 +31,18 @@ func (ms *messageSymbol) GenerateAlias(g *Generator, filename string, pkg GoPack
 type enumSymbol struct {
 	name   string
 	proto3 bool // Whether this came from a proto3 file.
+	lazy   bool // Whether the name/value maps are behind the lazy_enum_maps accessors.
 }
 
 func (es enumSymbol) GenerateAlias(g *Generator, filename string, pkg GoPackageName) {
 	s := es.name
 	g.P("// ", s, " from public import ", filename)
 	g.P("type ", s, " = ", pkg, ".", s)
+	if es.lazy {
+		g.P("var ", s, "Name = ", pkg, ".", s, "Name")
+		g.P("var ", s, "Value = ", pkg, ".", s, "Value")
+		return
+	}
 	g.P("var ", s, "_name = ", pkg, ".", s, "_name")
 	g.P("var ", s, "_value = ", pkg, ".", s, "_value")
 }
@@ -25,17 +25,12 @@ func main() {
 		g.Error(err, "parsing input proto")
 	}
 
-	if len(g.Request.FileToGenerate) == 0 {
-		g.Fail("no files to generate")
+	resp, err := generator.Run(g.Request)
+	if err != nil {
+		g.Fail(err.Error())
 	}
 
-	g.CommandLineParameters(g.Request.GetParameter())
-	g.WrapTypes()
-	g.SetPackageNames()
-	g.BuildTypeNameMap()
-	g.GenerateAllFiles()
-
-	data, err = proto.Marshal(g.Response)
+	data, err = proto.Marshal(resp)
 	if err != nil {
 		g.Error(err, "failed to marshal output proto")
 	}